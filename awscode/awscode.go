@@ -1,7 +1,9 @@
 package awscode
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -10,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type SpotDetails struct {
@@ -41,7 +44,7 @@ type SpotPriceContainer struct {
 	Err error
 }
 
-func DescribeSpotPriceHistory(sess *session.Session, instanceTypes []string,
+func DescribeSpotPriceHistory(ctx context.Context, sess *session.Session, instanceTypes []string,
 	availabilityZone string, priceChan chan SpotPriceContainer, startTime *time.Time) {
 
 	svc := ec2.New(sess)
@@ -59,7 +62,15 @@ func DescribeSpotPriceHistory(sess *session.Session, instanceTypes []string,
 		},
 		StartTime: startTime,
 	}
-	resp, err := svc.DescribeSpotPriceHistory(params)
+	resp := &ec2.DescribeSpotPriceHistoryOutput{}
+	err := Retry(ctx, IsTransient, func() error {
+		resp.SpotPriceHistory = nil
+		return svc.DescribeSpotPriceHistoryPagesWithContext(ctx, params,
+			func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+				resp.SpotPriceHistory = append(resp.SpotPriceHistory, page.SpotPriceHistory...)
+				return true
+			})
+	})
 	priceChan <- SpotPriceContainer{Out: resp, Err: err}
 }
 
@@ -90,62 +101,168 @@ func GetInstanceTypes(sess *session.Session) {
 }
 
 type SpotConfig struct {
-	MaxCV                        float64
-	MinGB                        float64
-	MaxDollarsPerGB              float64
-	MaxDollarsPerCPU             float64
-	AutoScalingGroupName         string
-	LaunchConfigurationPrefix    string
-	MaxAutoscalingNodes          int
-	HistoricalHours              float64
-	RegionName                   string
-	MaxTotalDollarsPerHour       float64
-	MinMarkupPercentage          float64
-	MinPriceDifferencePercentage float64
-	MaxPodKills                  int
-	MemoryBufferPercentage       float64
-	UpdateIntervalSeconds        float64
-	MinimumTurnoverSeconds       float64
+	MaxCV                         float64
+	MinGB                         float64
+	MaxDollarsPerGB               float64
+	MaxDollarsPerCPU              float64
+	AutoScalingGroupName          string
+	LaunchConfigurationPrefix     string
+	MaxAutoscalingNodes           int
+	HistoricalHours               float64
+	WeightingKernel               string
+	RegionName                    string
+	MaxTotalDollarsPerHour        float64
+	MinMarkupPercentage           float64
+	MinPriceDifferencePercentage  float64
+	MemoryBufferPercentage        float64
+	UpdateIntervalSeconds         float64
+	MinimumTurnoverSeconds        float64
+	SwitchingCostPerNode          float64
+	AmortizationHours             float64
+	RotateNodes                   bool
+	RotationBatchSize             int
+	DrainTimeoutSeconds           float64
+	ReadyTimeoutSeconds           float64
+	InterruptionQueueURL          string
+	InterruptionWindowHours       float64
+	InterruptionExclusionSeconds  float64
+	InterruptionPenaltyPercentage float64
+	InterruptionFrequencyFile     string
+	MaxInterruptionBucket         int
+	RejectUnknownFrequency        bool
+	FrequencyPenaltyPercentage    float64
+	WatchSpotPolicies             bool
+	SpotPolicyNamespace           string
+	LeaderElect                   bool
+	LeaderElectionNamespace       string
+	LeaderElectionName            string
+	RegistryNamespace             string
+	RegistryName                  string
+	KeepLaunchConfigurations      int
+	ListenAddress                 string
+	HeartbeatTimeoutSeconds       float64
+	EventObject                   string
+	NotificationsFile             string
+	PriceHistoryFile              string
+	PriceHistoryRetentionHours    float64
+	RecordDirectory               string
+	RecordKeep                    int
+	LogFormat                     string
+	LogLevel                      string
+
+	// Owner names what created the configuration's launch configurations in
+	// the registry; it is set for SpotPolicies and is not a flag.
+	Owner string
 }
 
 func GetSpotConfigFromCommand(cmd *cobra.Command) SpotConfig {
-	maxCV, _ := cmd.PersistentFlags().GetFloat64("maxCV")
-	minGB, _ := cmd.PersistentFlags().GetFloat64("minGB")
-	maxDollarsPerGB, _ := cmd.PersistentFlags().GetFloat64("maxDollarsPerGB")
-	maxDollarsPerCPU, _ := cmd.PersistentFlags().GetFloat64("maxDollarsPerCPU")
-	autoScalingGroupName, _ := cmd.PersistentFlags().GetString("autoScalingGroupName")
-	launchConfigurationPrefix, _ := cmd.PersistentFlags().GetString("launchConfigurationPrefix")
-	maxAutoscalingNodes, _ := cmd.PersistentFlags().GetInt("maxAutoscalingNodes")
-	historicalHours, _ := cmd.PersistentFlags().GetFloat64("historicalHours")
-	regionName, _ := cmd.PersistentFlags().GetString("regionName")
-	maxTotalDollarsPerHour, _ := cmd.PersistentFlags().GetFloat64("maxTotalDollarsPerHour")
-	minMarkupPercentage, _ := cmd.PersistentFlags().GetFloat64("minMarkupPercentage")
-	minPriceDifferencePercentage, _ := cmd.PersistentFlags().GetFloat64("minPriceDifferencePercentage")
-	maxPodKills, _ := cmd.PersistentFlags().GetInt("maxPodKills")
-	memoryBufferPercentage, _ := cmd.PersistentFlags().GetFloat64("memoryBufferPercentage")
-	updateIntervalSeconds, _ := cmd.PersistentFlags().GetFloat64("updateIntervalSeconds")
-	minimumTurnoverSeconds, _ := cmd.PersistentFlags().GetFloat64("minimumTurnoverSeconds")
+	return GetSpotConfigFromFlags(cmd.PersistentFlags())
+}
+
+// GetSpotConfigFromFlags reads the configuration from a flag set holding the
+// root command's persistent flags.
+func GetSpotConfigFromFlags(flags *pflag.FlagSet) SpotConfig {
+	maxCV, _ := flags.GetFloat64("maxCV")
+	minGB, _ := flags.GetFloat64("minGB")
+	maxDollarsPerGB, _ := flags.GetFloat64("maxDollarsPerGB")
+	maxDollarsPerCPU, _ := flags.GetFloat64("maxDollarsPerCPU")
+	autoScalingGroupName, _ := flags.GetString("autoScalingGroupName")
+	launchConfigurationPrefix, _ := flags.GetString("launchConfigurationPrefix")
+	maxAutoscalingNodes, _ := flags.GetInt("maxAutoscalingNodes")
+	historicalHours, _ := flags.GetFloat64("historicalHours")
+	weightingKernel, _ := flags.GetString("weightingKernel")
+	regionName, _ := flags.GetString("regionName")
+	maxTotalDollarsPerHour, _ := flags.GetFloat64("maxTotalDollarsPerHour")
+	minMarkupPercentage, _ := flags.GetFloat64("minMarkupPercentage")
+	minPriceDifferencePercentage, _ := flags.GetFloat64("minPriceDifferencePercentage")
+	memoryBufferPercentage, _ := flags.GetFloat64("memoryBufferPercentage")
+	updateIntervalSeconds, _ := flags.GetFloat64("updateIntervalSeconds")
+	minimumTurnoverSeconds, _ := flags.GetFloat64("minimumTurnoverSeconds")
+	switchingCostPerNode, _ := flags.GetFloat64("switchingCostPerNode")
+	amortizationHours, _ := flags.GetFloat64("amortizationHours")
+	rotateNodes, _ := flags.GetBool("rotateNodes")
+	rotationBatchSize, _ := flags.GetInt("rotationBatchSize")
+	drainTimeoutSeconds, _ := flags.GetFloat64("drainTimeoutSeconds")
+	readyTimeoutSeconds, _ := flags.GetFloat64("readyTimeoutSeconds")
+	interruptionQueueURL, _ := flags.GetString("interruptionQueueURL")
+	interruptionWindowHours, _ := flags.GetFloat64("interruptionWindowHours")
+	interruptionExclusionSeconds, _ := flags.GetFloat64("interruptionExclusionSeconds")
+	interruptionPenaltyPercentage, _ := flags.GetFloat64("interruptionPenaltyPercentage")
+	interruptionFrequencyFile, _ := flags.GetString("interruptionFrequencyFile")
+	maxInterruptionBucket, _ := flags.GetInt("maxInterruptionBucket")
+	rejectUnknownFrequency, _ := flags.GetBool("rejectUnknownFrequency")
+	frequencyPenaltyPercentage, _ := flags.GetFloat64("frequencyPenaltyPercentage")
+	watchSpotPolicies, _ := flags.GetBool("watchSpotPolicies")
+	spotPolicyNamespace, _ := flags.GetString("spotPolicyNamespace")
+	leaderElect, _ := flags.GetBool("leaderElect")
+	leaderElectionNamespace, _ := flags.GetString("leaderElectionNamespace")
+	leaderElectionName, _ := flags.GetString("leaderElectionName")
+	registryNamespace, _ := flags.GetString("registryNamespace")
+	registryName, _ := flags.GetString("registryName")
+	keepLaunchConfigurations, _ := flags.GetInt("keepLaunchConfigurations")
+	listenAddress, _ := flags.GetString("listenAddress")
+	heartbeatTimeoutSeconds, _ := flags.GetFloat64("heartbeatTimeoutSeconds")
+	eventObject, _ := flags.GetString("eventObject")
+	notificationsFile, _ := flags.GetString("notificationsFile")
+	priceHistoryFile, _ := flags.GetString("priceHistoryFile")
+	priceHistoryRetentionHours, _ := flags.GetFloat64("priceHistoryRetentionHours")
+	recordDirectory, _ := flags.GetString("record")
+	recordKeep, _ := flags.GetInt("recordKeep")
+	logFormat, _ := flags.GetString("logFormat")
+	logLevel, _ := flags.GetString("logLevel")
 
 	return SpotConfig{
-		MaxCV:                        maxCV,
-		MinGB:                        minGB,
-		MaxDollarsPerGB:              maxDollarsPerGB,
-		MaxDollarsPerCPU:             maxDollarsPerCPU,
-		AutoScalingGroupName:         autoScalingGroupName,
-		LaunchConfigurationPrefix:    launchConfigurationPrefix,
-		MaxAutoscalingNodes:          maxAutoscalingNodes,
-		HistoricalHours:              historicalHours,
-		RegionName:                   regionName,
-		MaxTotalDollarsPerHour:       maxTotalDollarsPerHour,
-		MinMarkupPercentage:          minMarkupPercentage,
-		MinPriceDifferencePercentage: minPriceDifferencePercentage,
-		MaxPodKills:                  maxPodKills,
-		MemoryBufferPercentage:       memoryBufferPercentage,
-		UpdateIntervalSeconds:        updateIntervalSeconds,
-		MinimumTurnoverSeconds:       minimumTurnoverSeconds}
+		MaxCV:                         maxCV,
+		MinGB:                         minGB,
+		MaxDollarsPerGB:               maxDollarsPerGB,
+		MaxDollarsPerCPU:              maxDollarsPerCPU,
+		AutoScalingGroupName:          autoScalingGroupName,
+		LaunchConfigurationPrefix:     launchConfigurationPrefix,
+		MaxAutoscalingNodes:           maxAutoscalingNodes,
+		HistoricalHours:               historicalHours,
+		WeightingKernel:               weightingKernel,
+		RegionName:                    regionName,
+		MaxTotalDollarsPerHour:        maxTotalDollarsPerHour,
+		MinMarkupPercentage:           minMarkupPercentage,
+		MinPriceDifferencePercentage:  minPriceDifferencePercentage,
+		MemoryBufferPercentage:        memoryBufferPercentage,
+		UpdateIntervalSeconds:         updateIntervalSeconds,
+		MinimumTurnoverSeconds:        minimumTurnoverSeconds,
+		SwitchingCostPerNode:          switchingCostPerNode,
+		AmortizationHours:             amortizationHours,
+		RotateNodes:                   rotateNodes,
+		RotationBatchSize:             rotationBatchSize,
+		DrainTimeoutSeconds:           drainTimeoutSeconds,
+		ReadyTimeoutSeconds:           readyTimeoutSeconds,
+		InterruptionQueueURL:          interruptionQueueURL,
+		InterruptionWindowHours:       interruptionWindowHours,
+		InterruptionExclusionSeconds:  interruptionExclusionSeconds,
+		InterruptionPenaltyPercentage: interruptionPenaltyPercentage,
+		InterruptionFrequencyFile:     interruptionFrequencyFile,
+		MaxInterruptionBucket:         maxInterruptionBucket,
+		RejectUnknownFrequency:        rejectUnknownFrequency,
+		FrequencyPenaltyPercentage:    frequencyPenaltyPercentage,
+		WatchSpotPolicies:             watchSpotPolicies,
+		SpotPolicyNamespace:           spotPolicyNamespace,
+		LeaderElect:                   leaderElect,
+		LeaderElectionNamespace:       leaderElectionNamespace,
+		LeaderElectionName:            leaderElectionName,
+		RegistryNamespace:             registryNamespace,
+		RegistryName:                  registryName,
+		KeepLaunchConfigurations:      keepLaunchConfigurations,
+		ListenAddress:                 listenAddress,
+		HeartbeatTimeoutSeconds:       heartbeatTimeoutSeconds,
+		EventObject:                   eventObject,
+		NotificationsFile:             notificationsFile,
+		PriceHistoryFile:              priceHistoryFile,
+		PriceHistoryRetentionHours:    priceHistoryRetentionHours,
+		RecordDirectory:               recordDirectory,
+		RecordKeep:                    recordKeep,
+		LogFormat:                     logFormat,
+		LogLevel:                      logLevel}
 }
 
-func GetAutoscaler(sess *session.Session, autoscalerName string) *autoscaling.Group {
+func GetAutoscaler(ctx context.Context, sess *session.Session, autoscalerName string) (*autoscaling.Group, error) {
 
 	autoscaling_svc := autoscaling.New(sess)
 
@@ -153,39 +270,124 @@ func GetAutoscaler(sess *session.Session, autoscalerName string) *autoscaling.Gr
 		AutoScalingGroupNames: []*string{aws.String(autoscalerName)},
 		MaxRecords:            aws.Int64(10),
 	}
-	resp, err := autoscaling_svc.DescribeAutoScalingGroups(params)
+	var resp *autoscaling.DescribeAutoScalingGroupsOutput
+	err := Retry(ctx, IsTransient, func() (err error) {
+		resp, err = autoscaling_svc.DescribeAutoScalingGroupsWithContext(ctx, params)
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not describe autoscaling group '%v': %w", autoscalerName, err)
 	}
 
 	if len(resp.AutoScalingGroups) != 1 {
-		panic(fmt.Sprintf(
+		return nil, fmt.Errorf(
 			"You should not have more or less than 1 matched autoscaling groups to autoscaler name '%v'.  You have %v",
-			autoscalerName, len(resp.AutoScalingGroups)))
+			autoscalerName, len(resp.AutoScalingGroups))
 	}
-	return resp.AutoScalingGroups[0]
+	return resp.AutoScalingGroups[0], nil
+}
+
+// OwnsLaunchConfiguration reports whether a launch configuration name belongs
+// to launchConfigurationPrefix: either the prefix itself or the prefix followed
+// by "-" and a suffix, so that prefix "prod" does not claim "preprod-..." or
+// "prod2-...".
+func OwnsLaunchConfiguration(launchConfigurationPrefix string, launchConfigurationName string) bool {
+	return launchConfigurationName == launchConfigurationPrefix ||
+		strings.HasPrefix(launchConfigurationName, launchConfigurationPrefix+"-")
 }
 
-func GetLaunchConfigurations(sess *session.Session, launchConfigurationPrefix string) []*autoscaling.LaunchConfiguration {
+// DescribeLaunchConfigurations returns every launch configuration in the region.
+func DescribeLaunchConfigurations(ctx context.Context, sess *session.Session) ([]*autoscaling.LaunchConfiguration, error) {
 	autoscaling_svc := autoscaling.New(sess)
 
 	params := &autoscaling.DescribeLaunchConfigurationsInput{
 		MaxRecords: aws.Int64(100),
 	}
-	resp, err := autoscaling_svc.DescribeLaunchConfigurations(params)
+	var allLaunchConfigurations []*autoscaling.LaunchConfiguration
+	err := Retry(ctx, IsTransient, func() error {
+		allLaunchConfigurations = []*autoscaling.LaunchConfiguration{}
+		return autoscaling_svc.DescribeLaunchConfigurationsPagesWithContext(ctx, params,
+			func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+				allLaunchConfigurations = append(allLaunchConfigurations, page.LaunchConfigurations...)
+				return true
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe launchconfigurations: %w", err)
+	}
+	return allLaunchConfigurations, nil
+}
+
+// DescribeLaunchConfiguration returns the named launch configuration, or nil
+// if it does not exist.
+func DescribeLaunchConfiguration(ctx context.Context, sess *session.Session,
+	launchConfigurationName string) (*autoscaling.LaunchConfiguration, error) {
+
+	if len(launchConfigurationName) == 0 {
+		return nil, nil
+	}
+	autoscaling_svc := autoscaling.New(sess)
+
+	params := &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(launchConfigurationName)},
+	}
+	var resp *autoscaling.DescribeLaunchConfigurationsOutput
+	err := Retry(ctx, IsTransient, func() (err error) {
+		resp, err = autoscaling_svc.DescribeLaunchConfigurationsWithContext(ctx, params)
+		return err
+	})
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not describe launchconfiguration '%v': %w", launchConfigurationName, err)
+	}
+	if len(resp.LaunchConfigurations) == 0 {
+		return nil, nil
+	}
+	return resp.LaunchConfigurations[0], nil
+}
+
+func GetLaunchConfigurations(ctx context.Context, sess *session.Session,
+	launchConfigurationPrefix string) ([]*autoscaling.LaunchConfiguration, error) {
+	allLaunchConfigurations, err := DescribeLaunchConfigurations(ctx, sess)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("\nYou have '%v' total launchconfigurations\n", len(resp.LaunchConfigurations))
 	var launchConfigurations []*autoscaling.LaunchConfiguration = []*autoscaling.LaunchConfiguration{}
-	for _, lc := range resp.LaunchConfigurations {
-		if strings.Contains(*lc.LaunchConfigurationName, launchConfigurationPrefix) {
+	for _, lc := range allLaunchConfigurations {
+		if OwnsLaunchConfiguration(launchConfigurationPrefix, *lc.LaunchConfigurationName) {
 			launchConfigurations = append(launchConfigurations, lc)
 		}
 	}
-	fmt.Printf("\nYou have '%v' launchconfigurations prefixed by '%v'\n", len(launchConfigurations), launchConfigurationPrefix)
-	return launchConfigurations
+	slog.Debug("launch configurations", "total", len(allLaunchConfigurations),
+		"prefix", launchConfigurationPrefix, "prefixed", len(launchConfigurations))
+	return launchConfigurations, nil
+
+}
 
+// GetAttachedLaunchConfigurations maps the name of every launch configuration
+// attached to an autoscaling group in the region to the name of that group.
+func GetAttachedLaunchConfigurations(ctx context.Context, sess *session.Session) (map[string]string, error) {
+	autoscaling_svc := autoscaling.New(sess)
+
+	params := &autoscaling.DescribeAutoScalingGroupsInput{
+		MaxRecords: aws.Int64(100),
+	}
+	var attached map[string]string
+	err := Retry(ctx, IsTransient, func() error {
+		attached = map[string]string{}
+		return autoscaling_svc.DescribeAutoScalingGroupsPagesWithContext(ctx, params,
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, group := range page.AutoScalingGroups {
+					if group.LaunchConfigurationName != nil {
+						attached[*group.LaunchConfigurationName] = *group.AutoScalingGroupName
+					}
+				}
+				return true
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe autoscaling groups: %w", err)
+	}
+	return attached, nil
 }
 
 func DuplicateLaunchConfiguration(launchConfiguration *autoscaling.LaunchConfiguration) autoscaling.CreateLaunchConfigurationInput {
@@ -209,8 +411,24 @@ func DuplicateLaunchConfiguration(launchConfiguration *autoscaling.LaunchConfigu
 	}
 }
 
-func GetSpotPrices(sess *session.Session, instanceTypes []string,
-	regionNames []string, historicalHours time.Duration) map[string][]ec2.SpotPrice {
+// GetSpotPrices returns the spot price history of instanceTypes in every
+// availability zone of regionNames over the last historical duration.
+func GetSpotPrices(ctx context.Context, sess *session.Session, instanceTypes []string,
+	regionNames []string, historical time.Duration) (map[string][]ec2.SpotPrice, error) {
+
+	startTimes := map[string]time.Time{}
+	for _, instanceType := range instanceTypes {
+		startTimes[instanceType] = time.Now().Add(-historical)
+	}
+	return GetSpotPricesSince(ctx, sess, startTimes, regionNames)
+}
+
+// GetSpotPricesSince returns the spot price history of each instance type in
+// startTimes, in every availability zone of regionNames, since its start time.
+// Like DescribeSpotPriceHistory, the history of each zone begins with the price
+// in effect at the start time.
+func GetSpotPricesSince(ctx context.Context, sess *session.Session, startTimes map[string]time.Time,
+	regionNames []string) (map[string][]ec2.SpotPrice, error) {
 
 	ec2_svc := ec2.New(sess)
 
@@ -220,42 +438,99 @@ func GetSpotPrices(sess *session.Session, instanceTypes []string,
 		Filters: []*ec2.Filter{{
 			Name:   aws.String("region-name"),
 			Values: awsRegionNames}}}
-	zones, err := ec2_svc.DescribeAvailabilityZones(&req)
+	var zones *ec2.DescribeAvailabilityZonesOutput
+	err := Retry(ctx, IsTransient, func() (err error) {
+		zones, err = ec2_svc.DescribeAvailabilityZonesWithContext(ctx, &req)
+		return err
+	})
 
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not describe availability zones: %w", err)
 	}
 
 	availabilityZones := zones.AvailabilityZones
 
 	if len(availabilityZones) == 0 {
-		panic("You have no relevant AvailabilityZones...")
+		return nil, fmt.Errorf("You have no relevant AvailabilityZones in %v...", regionNames)
 	}
 
 	priceChan := make(chan SpotPriceContainer)
 	priceMap := make(map[string][]ec2.SpotPrice)
-	startTime := aws.Time(time.Now().Add(-historicalHours))
-	for _, instanceType := range instanceTypes {
+	for instanceType, startTime := range startTimes {
 		for _, zone := range availabilityZones {
-			go DescribeSpotPriceHistory(sess, []string{instanceType}, *zone.ZoneName, priceChan, startTime)
+			go DescribeSpotPriceHistory(ctx, sess, []string{instanceType}, *zone.ZoneName, priceChan, aws.Time(startTime))
 		}
-	}
-
-	for _, instanceType := range instanceTypes {
 		priceMap[instanceType] = make([]ec2.SpotPrice, 0)
 	}
-	fullCount := len(instanceTypes) * len(availabilityZones)
+	if len(startTimes) == 0 {
+		return priceMap, nil
+	}
+	fullCount := len(startTimes) * len(availabilityZones)
 	count := 0
+	var priceErr error
 	for {
 		select {
 		case priceContainer := <-priceChan:
-			for _, spotPrice := range priceContainer.Out.SpotPriceHistory {
-				priceMap[*spotPrice.InstanceType] = append(priceMap[*spotPrice.InstanceType], *spotPrice)
+			if priceContainer.Err != nil {
+				if priceErr == nil {
+					priceErr = fmt.Errorf("could not describe spot price history: %w", priceContainer.Err)
+				}
+			} else {
+				for _, spotPrice := range priceContainer.Out.SpotPriceHistory {
+					priceMap[*spotPrice.InstanceType] = append(priceMap[*spotPrice.InstanceType], *spotPrice)
+				}
 			}
 			count++
 			if fullCount == count {
-				return priceMap
+				return priceMap, priceErr
 			}
 		}
 	}
 }
+
+// TerminateInstanceInAutoScalingGroup terminates a single instance without
+// decrementing the group's desired capacity, so the autoscaling group launches
+// a replacement from its current launch configuration.
+func TerminateInstanceInAutoScalingGroup(ctx context.Context, sess *session.Session, instanceID string) error {
+	autoscaling_svc := autoscaling.New(sess)
+
+	params := &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String(instanceID),
+		ShouldDecrementDesiredCapacity: aws.Bool(false),
+	}
+	return Retry(ctx, IsThrottle, func() error {
+		_, err := autoscaling_svc.TerminateInstanceInAutoScalingGroupWithContext(ctx, params)
+		return err
+	})
+}
+
+func CreateLaunchConfiguration(ctx context.Context, sess *session.Session,
+	input *autoscaling.CreateLaunchConfigurationInput) error {
+	autoscaling_svc := autoscaling.New(sess)
+
+	return Retry(ctx, IsThrottle, func() error {
+		_, err := autoscaling_svc.CreateLaunchConfigurationWithContext(ctx, input)
+		return err
+	})
+}
+
+func UpdateAutoScalingGroup(ctx context.Context, sess *session.Session,
+	input *autoscaling.UpdateAutoScalingGroupInput) error {
+	autoscaling_svc := autoscaling.New(sess)
+
+	return Retry(ctx, IsThrottle, func() error {
+		_, err := autoscaling_svc.UpdateAutoScalingGroupWithContext(ctx, input)
+		return err
+	})
+}
+
+func DeleteLaunchConfiguration(ctx context.Context, sess *session.Session, launchConfigurationName string) error {
+	autoscaling_svc := autoscaling.New(sess)
+
+	return Retry(ctx, IsThrottle, func() error {
+		_, err := autoscaling_svc.DeleteLaunchConfigurationWithContext(ctx, &autoscaling.DeleteLaunchConfigurationInput{
+			LaunchConfigurationName: aws.String(launchConfigurationName),
+		})
+		return err
+	})
+}
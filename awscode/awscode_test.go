@@ -0,0 +1,23 @@
+package awscode
+
+import "testing"
+
+func TestOwnsLaunchConfiguration(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"prod", true},
+		{"prod-spot-r4.xlarge-0.11-1a2b3c4d", true},
+		{"prod-", true},
+		{"preprod-spot-r4.xlarge-0.11-1a2b3c4d", false},
+		{"prod2-spot-r4.xlarge-0.11-1a2b3c4d", false},
+		{"prodspot", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := OwnsLaunchConfiguration("prod", c.name); got != c.want {
+			t.Errorf("OwnsLaunchConfiguration(%q, %q) = %v, want %v", "prod", c.name, got, c.want)
+		}
+	}
+}
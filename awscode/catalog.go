@@ -0,0 +1,101 @@
+package awscode
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awspricing "github.com/aws/aws-sdk-go/service/pricing"
+)
+
+// pricingRegion is the region serving the AWS Price List API, which covers
+// every other region.
+const pricingRegion = "us-east-1"
+
+// DescribeInstanceType fetches the vCPUs and memory (in GB) of an instance type,
+// for types missing from the bundled catalog.
+func DescribeInstanceType(ctx context.Context, sess *session.Session, instanceType string) (float64, float64, error) {
+	ec2_svc := ec2.New(sess)
+	params := &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(instanceType)},
+	}
+	var resp *ec2.DescribeInstanceTypesOutput
+	err := Retry(ctx, IsTransient, func() (err error) {
+		resp, err = ec2_svc.DescribeInstanceTypesWithContext(ctx, params)
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not describe instance type '%v': %w", instanceType, err)
+	}
+	if len(resp.InstanceTypes) != 1 || resp.InstanceTypes[0].VCpuInfo == nil || resp.InstanceTypes[0].MemoryInfo == nil {
+		return 0, 0, fmt.Errorf("instance type '%v' is unknown to EC2", instanceType)
+	}
+	info := resp.InstanceTypes[0]
+	cpus := float64(aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+	memGB := float64(aws.Int64Value(info.MemoryInfo.SizeInMiB)) / 1024.0
+	return cpus, memGB, nil
+}
+
+// GetOnDemandPrice returns the hourly on-demand price in USD of a shared-tenancy
+// Linux instance of instanceType in regionName.
+func GetOnDemandPrice(ctx context.Context, sess *session.Session, regionName string, instanceType string) (float64, error) {
+	pricing_svc := awspricing.New(sess, aws.NewConfig().WithRegion(pricingRegion))
+	filter := func(field string, value string) *awspricing.Filter {
+		return &awspricing.Filter{
+			Type:  aws.String(awspricing.FilterTypeTermMatch),
+			Field: aws.String(field),
+			Value: aws.String(value)}
+	}
+	params := &awspricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []*awspricing.Filter{
+			filter("regionCode", regionName),
+			filter("instanceType", instanceType),
+			filter("operatingSystem", "Linux"),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("capacitystatus", "Used"),
+		},
+		MaxResults: aws.Int64(10),
+	}
+	var resp *awspricing.GetProductsOutput
+	err := Retry(ctx, IsTransient, func() (err error) {
+		resp, err = pricing_svc.GetProductsWithContext(ctx, params)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get the on-demand price of '%v': %w", instanceType, err)
+	}
+	for _, product := range resp.PriceList {
+		if price, found := onDemandUSD(product); found {
+			return price, nil
+		}
+	}
+	return 0, fmt.Errorf("no on-demand price found for '%v' in '%v'", instanceType, regionName)
+}
+
+// onDemandUSD digs the hourly USD price out of a Price List product, whose
+// shape is terms.OnDemand.<offer>.priceDimensions.<rate>.pricePerUnit.USD.
+func onDemandUSD(product aws.JSONValue) (float64, bool) {
+	child := func(value interface{}, key string) interface{} {
+		if object, ok := value.(map[string]interface{}); ok {
+			return object[key]
+		}
+		return nil
+	}
+	offers, _ := child(child(map[string]interface{}(product), "terms"), "OnDemand").(map[string]interface{})
+	for _, offer := range offers {
+		dimensions, _ := child(offer, "priceDimensions").(map[string]interface{})
+		for _, dimension := range dimensions {
+			usd, _ := child(child(dimension, "pricePerUnit"), "USD").(string)
+			price, err := strconv.ParseFloat(usd, 64)
+			if err == nil && price > 0 {
+				return price, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,410 @@
+package awscode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/davidboren/k8-spot-daemon/eventobject"
+	"github.com/davidboren/k8-spot-daemon/logging"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// ConfigVersion is the version of the config file schema understood by this
+// build.  Config files must declare it as `version: 1`.
+const ConfigVersion = 1
+
+// Kernels that weight a spot price point by its age when averaging prices.
+const (
+	KernelInverse     = "inverse"
+	KernelUniform     = "uniform"
+	KernelExponential = "exponential"
+)
+
+var WeightingKernels = []string{KernelInverse, KernelUniform, KernelExponential}
+
+// EnvPrefix is prepended to the upper snake-cased flag name to form the
+// environment variable that sets it, e.g. K8_SPOT_DAEMON_MAX_CV for --maxCV.
+const EnvPrefix = "K8_SPOT_DAEMON_"
+
+func DefaultSpotConfig() SpotConfig {
+	return SpotConfig{
+		AutoScalingGroupName:          "",
+		LaunchConfigurationPrefix:     "",
+		MaxAutoscalingNodes:           20,
+		HistoricalHours:               3,
+		WeightingKernel:               KernelInverse,
+		RegionName:                    "us-west-2",
+		MaxCV:                         0.05,
+		MinGB:                         30.0,
+		MaxDollarsPerGB:               0.01,
+		MaxDollarsPerCPU:              0.03,
+		MaxTotalDollarsPerHour:        12.0,
+		MinMarkupPercentage:           10,
+		MemoryBufferPercentage:        5,
+		MinPriceDifferencePercentage:  10,
+		UpdateIntervalSeconds:         300,
+		MinimumTurnoverSeconds:        1200,
+		SwitchingCostPerNode:          0.10,
+		AmortizationHours:             6,
+		RotateNodes:                   false,
+		RotationBatchSize:             1,
+		DrainTimeoutSeconds:           600,
+		ReadyTimeoutSeconds:           900,
+		InterruptionQueueURL:          "",
+		InterruptionWindowHours:       24,
+		InterruptionExclusionSeconds:  3600,
+		InterruptionPenaltyPercentage: 10,
+		InterruptionFrequencyFile:     "",
+		MaxInterruptionBucket:         4,
+		RejectUnknownFrequency:        false,
+		FrequencyPenaltyPercentage:    5,
+		WatchSpotPolicies:             false,
+		SpotPolicyNamespace:           "",
+		LeaderElect:                   false,
+		LeaderElectionNamespace:       "kube-system",
+		LeaderElectionName:            "k8-spot-daemon",
+		RegistryNamespace:             "kube-system",
+		RegistryName:                  "k8-spot-daemon-launch-configurations",
+		KeepLaunchConfigurations:      2,
+		ListenAddress:                 ":9090",
+		HeartbeatTimeoutSeconds:       1800,
+		EventObject:                   "",
+		NotificationsFile:             "",
+		PriceHistoryFile:              "",
+		PriceHistoryRetentionHours:    720,
+		RecordDirectory:               "",
+		RecordKeep:                    1000,
+		LogFormat:                     "text",
+		LogLevel:                      "info",
+	}
+}
+
+// EnvName returns the environment variable that sets the named flag.
+func EnvName(flagName string) string {
+	runes := []rune(flagName)
+	var envName strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				envName.WriteRune('_')
+			}
+		}
+		envName.WriteRune(unicode.ToUpper(r))
+	}
+	return EnvPrefix + envName.String()
+}
+
+// ReadConfigFile reads a YAML (or JSON) config file whose keys are the flag
+// names of the root command, e.g. `maxCV: 0.05`.
+func ReadConfigFile(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(contents, &values); err != nil {
+		return nil, fmt.Errorf("could not parse config file '%v': %v", path, err)
+	}
+
+	version, found := values["version"]
+	if !found {
+		return nil, fmt.Errorf("config file '%v' must declare 'version: %v'", path, ConfigVersion)
+	}
+	if version != ConfigVersion {
+		return nil, fmt.Errorf("config file '%v' has unsupported version '%v' (expected %v)", path, version, ConfigVersion)
+	}
+	delete(values, "version")
+	return values, nil
+}
+
+// ApplyConfigSources fills in every flag that was not set on the command line,
+// first from its K8_SPOT_DAEMON_* environment variable and then from the config
+// file, so that flags take precedence over the environment, the environment
+// over the file, and the file over the defaults.
+func ApplyConfigSources(cmd *cobra.Command, configPath string) error {
+	return applyConfigSources(cmd.PersistentFlags(), configPath)
+}
+
+func applyConfigSources(flags *pflag.FlagSet, configPath string) error {
+	fileValues := map[string]interface{}{}
+	if len(configPath) > 0 {
+		var err error
+		fileValues, err = ReadConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	unknownKeys := []string{}
+	for key := range fileValues {
+		if key == "config" || flags.Lookup(key) == nil {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return fmt.Errorf("config file '%v' has unknown keys: %v", configPath, strings.Join(unknownKeys, ", "))
+	}
+
+	var applyErr error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if applyErr != nil || flag.Changed || flag.Name == "config" {
+			return
+		}
+		if value, found := os.LookupEnv(EnvName(flag.Name)); found {
+			if err := flag.Value.Set(value); err != nil {
+				applyErr = fmt.Errorf("invalid value '%v' for %v: %v", value, EnvName(flag.Name), err)
+			}
+			return
+		}
+		if value, found := fileValues[flag.Name]; found {
+			switch value.(type) {
+			case map[interface{}]interface{}, []interface{}:
+				applyErr = fmt.Errorf("config key '%v' must be a single value", flag.Name)
+				return
+			}
+			if err := flag.Value.Set(fmt.Sprintf("%v", value)); err != nil {
+				applyErr = fmt.Errorf("invalid value '%v' for config key '%v': %v", value, flag.Name, err)
+			}
+		}
+	})
+	return applyErr
+}
+
+// Validate checks the configuration for missing, negative or contradictory
+// settings, reporting every problem found at once.
+func (c SpotConfig) Validate() error {
+	problems := []string{}
+	// With SpotPolicies each policy names its own autoscaling group, and the
+	// configuration SpotConfigForPolicy makes of it is validated separately.
+	if len(c.AutoScalingGroupName) == 0 && !c.WatchSpotPolicies {
+		problems = append(problems, "autoScalingGroupName (--autoScalingGroupName or -q) must be set")
+	}
+	if len(c.LaunchConfigurationPrefix) == 0 && !c.WatchSpotPolicies {
+		problems = append(problems, "launchConfigurationPrefix (--launchConfigurationPrefix or -l) must be set")
+	}
+	if len(c.RegionName) == 0 {
+		problems = append(problems, "regionName must be set")
+	}
+
+	nonNegative := map[string]float64{
+		"minMarkupPercentage":           c.MinMarkupPercentage,
+		"memoryBufferPercentage":        c.MemoryBufferPercentage,
+		"minPriceDifferencePercentage":  c.MinPriceDifferencePercentage,
+		"interruptionPenaltyPercentage": c.InterruptionPenaltyPercentage,
+		"frequencyPenaltyPercentage":    c.FrequencyPenaltyPercentage,
+		"minGB":                         c.MinGB,
+		"switchingCostPerNode":          c.SwitchingCostPerNode,
+		"amortizationHours":             c.AmortizationHours,
+		"minimumTurnoverSeconds":        c.MinimumTurnoverSeconds,
+		"interruptionExclusionSeconds":  c.InterruptionExclusionSeconds,
+		"drainTimeoutSeconds":           c.DrainTimeoutSeconds,
+		"readyTimeoutSeconds":           c.ReadyTimeoutSeconds,
+		"keepLaunchConfigurations":      float64(c.KeepLaunchConfigurations),
+		"recordKeep":                    float64(c.RecordKeep),
+	}
+	positive := map[string]float64{
+		"historicalHours":            c.HistoricalHours,
+		"maxCV":                      c.MaxCV,
+		"maxDollarsPerGB":            c.MaxDollarsPerGB,
+		"maxDollarsPerCPU":           c.MaxDollarsPerCPU,
+		"maxTotalDollarsPerHour":     c.MaxTotalDollarsPerHour,
+		"updateIntervalSeconds":      c.UpdateIntervalSeconds,
+		"interruptionWindowHours":    c.InterruptionWindowHours,
+		"maxAutoscalingNodes":        float64(c.MaxAutoscalingNodes),
+		"rotationBatchSize":          float64(c.RotationBatchSize),
+		"heartbeatTimeoutSeconds":    c.HeartbeatTimeoutSeconds,
+		"priceHistoryRetentionHours": c.PriceHistoryRetentionHours,
+	}
+	for _, name := range sortedKeys(nonNegative) {
+		if nonNegative[name] < 0 {
+			problems = append(problems, fmt.Sprintf("%v must not be negative (got %v)", name, nonNegative[name]))
+		}
+	}
+	for _, name := range sortedKeys(positive) {
+		if positive[name] <= 0 {
+			problems = append(problems, fmt.Sprintf("%v must be greater than zero (got %v)", name, positive[name]))
+		}
+	}
+
+	if c.LeaderElect && (len(c.LeaderElectionNamespace) == 0 || len(c.LeaderElectionName) == 0) {
+		problems = append(problems, "leaderElectionNamespace and leaderElectionName must be set with leaderElect")
+	}
+	if len(c.RegistryName) > 0 && len(c.RegistryNamespace) == 0 {
+		problems = append(problems, "registryNamespace must be set with registryName")
+	}
+	knownKernel := false
+	for _, kernel := range WeightingKernels {
+		knownKernel = knownKernel || kernel == c.WeightingKernel
+	}
+	if !knownKernel {
+		problems = append(problems, fmt.Sprintf("weightingKernel '%v' is not one of %v",
+			c.WeightingKernel, strings.Join(WeightingKernels, ", ")))
+	}
+	if err := logging.Check(c.LogFormat, c.LogLevel); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(c.EventObject) > 0 {
+		if _, err := eventobject.Parse(c.EventObject); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if c.RotateNodes && c.HeartbeatTimeoutSeconds <= math.Max(c.DrainTimeoutSeconds, c.ReadyTimeoutSeconds) {
+		problems = append(problems, fmt.Sprintf(
+			"heartbeatTimeoutSeconds (%v) must exceed drainTimeoutSeconds and readyTimeoutSeconds, or a rotation fails liveness",
+			c.HeartbeatTimeoutSeconds))
+	}
+	if len(c.PriceHistoryFile) > 0 && c.PriceHistoryRetentionHours < c.HistoricalHours {
+		problems = append(problems, fmt.Sprintf(
+			"priceHistoryRetentionHours (%v) must cover historicalHours (%v)",
+			c.PriceHistoryRetentionHours, c.HistoricalHours))
+	}
+	if c.MemoryBufferPercentage >= 100 {
+		problems = append(problems, fmt.Sprintf(
+			"memoryBufferPercentage (%v) must be below 100", c.MemoryBufferPercentage))
+	}
+	if c.SwitchingCostPerNode > 0 && c.AmortizationHours == 0 {
+		problems = append(problems, fmt.Sprintf(
+			"switchingCostPerNode (%v) can never be recovered with an amortizationHours of 0", c.SwitchingCostPerNode))
+	}
+	if c.MaxInterruptionBucket < 0 {
+		problems = append(problems, fmt.Sprintf(
+			"maxInterruptionBucket (%v) excludes every instance type with interruption frequency data", c.MaxInterruptionBucket))
+	}
+	if c.InterruptionExclusionSeconds > c.InterruptionWindowHours*3600 {
+		problems = append(problems, fmt.Sprintf(
+			"interruptionExclusionSeconds (%v) outlasts the interruptionWindowHours (%v) that interruptions are kept for",
+			c.InterruptionExclusionSeconds, c.InterruptionWindowHours))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n    %v", strings.Join(problems, "\n    "))
+	}
+	return nil
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cloneFlags copies flags with fresh values at their defaults, except for
+// those given on the command line, which keep their values.
+func cloneFlags(flags *pflag.FlagSet) (*pflag.FlagSet, error) {
+	clone := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	var cloneErr error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if cloneErr != nil {
+			return
+		}
+		switch flag.Value.Type() {
+		case "float64":
+			clone.Float64(flag.Name, 0, flag.Usage)
+		case "int":
+			clone.Int(flag.Name, 0, flag.Usage)
+		case "bool":
+			clone.Bool(flag.Name, false, flag.Usage)
+		case "string":
+			clone.String(flag.Name, "", flag.Usage)
+		default:
+			cloneErr = fmt.Errorf("flag %v has unsupported type %v", flag.Name, flag.Value.Type())
+			return
+		}
+		copied := clone.Lookup(flag.Name)
+		copied.DefValue = flag.DefValue
+		copied.Changed = flag.Changed
+		value := flag.DefValue
+		if flag.Changed {
+			value = flag.Value.String()
+		}
+		cloneErr = copied.Value.Set(value)
+	})
+	return clone, cloneErr
+}
+
+// ReloadSpotConfig rebuilds the configuration from scratch on a copy of cmd's
+// flags, so that the flags themselves are left as the daemon started with
+// them: every flag not given on the command line starts from its default
+// before the environment and config file are re-applied.  The result is
+// validated.
+func ReloadSpotConfig(cmd *cobra.Command, configPath string) (SpotConfig, error) {
+	flags, err := cloneFlags(cmd.PersistentFlags())
+	if err != nil {
+		return SpotConfig{}, err
+	}
+	if err := applyConfigSources(flags, configPath); err != nil {
+		return SpotConfig{}, err
+	}
+	spotConfig := GetSpotConfigFromFlags(flags)
+	return spotConfig, spotConfig.Validate()
+}
+
+// DiffSpotConfig lists the settings that differ between two configurations as
+// "Name: old -> new" lines.
+func DiffSpotConfig(oldConfig SpotConfig, newConfig SpotConfig) []string {
+	diff := []string{}
+	oldValue := reflect.ValueOf(oldConfig)
+	newValue := reflect.ValueOf(newConfig)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if oldValue.Field(i).Interface() != newValue.Field(i).Interface() {
+			diff = append(diff, fmt.Sprintf("%v: '%v' -> '%v'",
+				oldValue.Type().Field(i).Name, oldValue.Field(i).Interface(), newValue.Field(i).Interface()))
+		}
+	}
+	return diff
+}
+
+// WriteConfigFile writes a config file for ReadConfigFile holding every flag of
+// cmd that differs from its default, with the values in overrides in place of
+// the flags' own.
+func WriteConfigFile(cmd *cobra.Command, path string, overrides map[string]string) error {
+	values := yaml.MapSlice{{Key: "version", Value: ConfigVersion}}
+	var valueErr error
+	cmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		value, found := overrides[flag.Name]
+		if !found {
+			value = flag.Value.String()
+			if value == flag.DefValue || flag.Name == "config" {
+				return
+			}
+		}
+		var typed interface{} = value
+		var err error
+		switch flag.Value.Type() {
+		case "float64":
+			typed, err = strconv.ParseFloat(value, 64)
+		case "int":
+			typed, err = strconv.Atoi(value)
+		case "bool":
+			typed, err = strconv.ParseBool(value)
+		}
+		if err != nil && valueErr == nil {
+			valueErr = fmt.Errorf("invalid value '%v' for %v: %v", value, flag.Name, err)
+		}
+		values = append(values, yaml.MapItem{Key: flag.Name, Value: typed})
+	})
+	if valueErr != nil {
+		return valueErr
+	}
+	contents, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, contents, 0644)
+}
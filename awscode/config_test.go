@@ -0,0 +1,95 @@
+package awscode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"maxCV":                "K8_SPOT_DAEMON_MAX_CV",
+		"regionName":           "K8_SPOT_DAEMON_REGION_NAME",
+		"autoScalingGroupName": "K8_SPOT_DAEMON_AUTO_SCALING_GROUP_NAME",
+		"maxDollarsPerCPU":     "K8_SPOT_DAEMON_MAX_DOLLARS_PER_CPU",
+		"maxDollarsPerGB":      "K8_SPOT_DAEMON_MAX_DOLLARS_PER_GB",
+		"interruptionQueueURL": "K8_SPOT_DAEMON_INTERRUPTION_QUEUE_URL",
+		"sqsURLPrefix":         "K8_SPOT_DAEMON_SQS_URL_PREFIX",
+		"ec2Tags":              "K8_SPOT_DAEMON_EC2_TAGS",
+	}
+	for flagName, want := range cases {
+		if got := EnvName(flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name      string
+		configure func(*SpotConfig)
+		want      []string
+	}{
+		{"defaults", func(c *SpotConfig) {}, nil},
+		{"spot policies name their own groups", func(c *SpotConfig) {
+			c.AutoScalingGroupName = ""
+			c.LaunchConfigurationPrefix = ""
+			c.WatchSpotPolicies = true
+		}, nil},
+		{"missing group", func(c *SpotConfig) {
+			c.AutoScalingGroupName = ""
+			c.LaunchConfigurationPrefix = ""
+		}, []string{"autoScalingGroupName (--autoScalingGroupName or -q) must be set",
+			"launchConfigurationPrefix (--launchConfigurationPrefix or -l) must be set"}},
+		{"negative and zero values", func(c *SpotConfig) {
+			c.MinMarkupPercentage = -1
+			c.MaxCV = 0
+		}, []string{"minMarkupPercentage must not be negative (got -1)", "maxCV must be greater than zero (got 0)"}},
+		{"leader election without a lease", func(c *SpotConfig) {
+			c.LeaderElect = true
+			c.LeaderElectionName = ""
+		}, []string{"leaderElectionNamespace and leaderElectionName must be set with leaderElect"}},
+		{"unknown kernel", func(c *SpotConfig) { c.WeightingKernel = "gaussian" },
+			[]string{"weightingKernel 'gaussian' is not one of"}},
+		{"unknown log format", func(c *SpotConfig) { c.LogFormat = "xml" }, []string{"xml"}},
+		{"unparseable event object", func(c *SpotConfig) { c.EventObject = "nodes" }, []string{"nodes"}},
+		{"heartbeat shorter than a rotation", func(c *SpotConfig) {
+			c.RotateNodes = true
+			c.HeartbeatTimeoutSeconds = 600
+		}, []string{"heartbeatTimeoutSeconds (600) must exceed drainTimeoutSeconds and readyTimeoutSeconds"}},
+		{"retention shorter than history", func(c *SpotConfig) {
+			c.PriceHistoryFile = "prices.db"
+			c.PriceHistoryRetentionHours = 1
+		}, []string{"priceHistoryRetentionHours (1) must cover historicalHours (3)"}},
+		{"memory buffer", func(c *SpotConfig) { c.MemoryBufferPercentage = 100 },
+			[]string{"memoryBufferPercentage (100) must be below 100"}},
+		{"unrecoverable switching cost", func(c *SpotConfig) { c.AmortizationHours = 0 },
+			[]string{"switchingCostPerNode (0.1) can never be recovered"}},
+		{"negative interruption bucket", func(c *SpotConfig) { c.MaxInterruptionBucket = -1 },
+			[]string{"maxInterruptionBucket (-1) excludes every instance type"}},
+		{"exclusion outlasting the window", func(c *SpotConfig) { c.InterruptionExclusionSeconds = 86401 },
+			[]string{"interruptionExclusionSeconds (86401) outlasts the interruptionWindowHours (24)"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spotConfig := DefaultSpotConfig()
+			spotConfig.AutoScalingGroupName = "nodes"
+			spotConfig.LaunchConfigurationPrefix = "nodes-spot"
+			c.configure(&spotConfig)
+			err := spotConfig.Validate()
+			if len(c.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", c.want)
+			}
+			for _, problem := range c.want {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Validate() = %q, does not contain %q", err, problem)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package awscode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	SpotInterruptionDetailType        = "EC2 Spot Instance Interruption Warning"
+	RebalanceRecommendationDetailType = "EC2 Instance Rebalance Recommendation"
+)
+
+// InterruptionEvent is a spot interruption warning or rebalance recommendation
+// delivered by EventBridge to an SQS queue.  InstanceType is empty if the
+// instance could not be described.
+type InterruptionEvent struct {
+	DetailType   string
+	InstanceID   string
+	InstanceType string
+	Time         time.Time
+	// ReceiptHandle deletes the event's message; see DeleteInterruptionEvents.
+	ReceiptHandle string
+	// ReceiveCount is how many times the message has been received, this time
+	// included.
+	ReceiveCount int
+}
+
+type eventBridgeMessage struct {
+	DetailType string    `json:"detail-type"`
+	Time       time.Time `json:"time"`
+	Detail     struct {
+		InstanceID string `json:"instance-id"`
+	} `json:"detail"`
+}
+
+// ReceiveInterruptionEvents reads the currently visible messages from an
+// EventBridge-fed SQS queue and resolves the instance type of each spot
+// interruption or rebalance event.  Other messages are deleted as they are
+// read; the events' messages are left for DeleteInterruptionEvents once they
+// have been recorded, so that an event whose instance type could not be
+// resolved is received again after the queue's visibility timeout, until the
+// caller gives up on it.
+func ReceiveInterruptionEvents(ctx context.Context, sess *session.Session, queueURL string) ([]InterruptionEvent, error) {
+	sqs_svc := sqs.New(sess)
+
+	events := []InterruptionEvent{}
+	for {
+		resp, err := sqs_svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(0),
+			AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+		})
+		if err != nil {
+			return events, err
+		}
+		if len(resp.Messages) == 0 {
+			break
+		}
+		discarded := []string{}
+		for _, message := range resp.Messages {
+			var parsed eventBridgeMessage
+			if json.Unmarshal([]byte(aws.StringValue(message.Body)), &parsed) == nil &&
+				(parsed.DetailType == SpotInterruptionDetailType ||
+					parsed.DetailType == RebalanceRecommendationDetailType) &&
+				len(parsed.Detail.InstanceID) > 0 {
+				receiveCount, _ := strconv.Atoi(aws.StringValue(
+					message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+				events = append(events, InterruptionEvent{
+					DetailType:    parsed.DetailType,
+					InstanceID:    parsed.Detail.InstanceID,
+					Time:          parsed.Time,
+					ReceiptHandle: aws.StringValue(message.ReceiptHandle),
+					ReceiveCount:  receiveCount})
+				continue
+			}
+			discarded = append(discarded, aws.StringValue(message.ReceiptHandle))
+		}
+		if err := deleteMessages(ctx, sqs_svc, queueURL, discarded); err != nil {
+			return events, err
+		}
+	}
+	if err := resolveInstanceTypes(ctx, sess, events); err != nil {
+		return events, fmt.Errorf("could not describe interrupted instances: %w", err)
+	}
+	return events, nil
+}
+
+// DeleteInterruptionEvents deletes the messages of events that have been
+// recorded.
+func DeleteInterruptionEvents(ctx context.Context, sess *session.Session, queueURL string,
+	events []InterruptionEvent) error {
+
+	receiptHandles := []string{}
+	for _, event := range events {
+		receiptHandles = append(receiptHandles, event.ReceiptHandle)
+	}
+	return deleteMessages(ctx, sqs.New(sess), queueURL, receiptHandles)
+}
+
+// deleteMessages deletes messages from the queue ten at a time, the most a
+// batch can hold.
+func deleteMessages(ctx context.Context, sqs_svc *sqs.SQS, queueURL string, receiptHandles []string) error {
+	for start := 0; start < len(receiptHandles); start += 10 {
+		end := start + 10
+		if end > len(receiptHandles) {
+			end = len(receiptHandles)
+		}
+		entries := []*sqs.DeleteMessageBatchRequestEntry{}
+		for i, receiptHandle := range receiptHandles[start:end] {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: aws.String(receiptHandle)})
+		}
+		var resp *sqs.DeleteMessageBatchOutput
+		err := Retry(ctx, IsTransient, func() (err error) {
+			resp, err = sqs_svc.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+				QueueUrl: aws.String(queueURL),
+				Entries:  entries,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Failed) > 0 {
+			return fmt.Errorf("could not delete %v interruption queue messages: %v",
+				len(resp.Failed), aws.StringValue(resp.Failed[0].Message))
+		}
+	}
+	return nil
+}
+
+// describeInstancesBatch is how many instance ids are looked up in one
+// DescribeInstances filter.
+const describeInstancesBatch = 200
+
+// resolveInstanceTypes fills in the InstanceType of each event, describing
+// the instances together.  They are matched by filter rather than by id, so
+// that instances that have already been reclaimed and are no longer described
+// are left blank instead of failing the whole lookup.
+func resolveInstanceTypes(ctx context.Context, sess *session.Session, events []InterruptionEvent) error {
+	ec2_svc := ec2.New(sess)
+
+	seen := map[string]bool{}
+	instanceIDs := []*string{}
+	for _, event := range events {
+		if !seen[event.InstanceID] {
+			seen[event.InstanceID] = true
+			instanceIDs = append(instanceIDs, aws.String(event.InstanceID))
+		}
+	}
+	instanceTypes := map[string]string{}
+	for start := 0; start < len(instanceIDs); start += describeInstancesBatch {
+		end := start + describeInstancesBatch
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+		params := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: instanceIDs[start:end]}},
+		}
+		err := Retry(ctx, IsTransient, func() error {
+			return ec2_svc.DescribeInstancesPagesWithContext(ctx, params,
+				func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+					for _, reservation := range page.Reservations {
+						for _, instance := range reservation.Instances {
+							instanceTypes[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.InstanceType)
+						}
+					}
+					return true
+				})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i := range events {
+		events[i].InstanceType = instanceTypes[events[i].InstanceID]
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package awscode
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RetryAttempts bounds the attempts at a call failing with a transient AWS
+// error.
+const RetryAttempts = 5
+
+// retryBackoff is the wait before the first retry, doubling after each further
+// failed attempt.  It is a variable so that tests can shorten it.
+var retryBackoff = 500 * time.Millisecond
+
+// IsTransient reports whether err is worth retrying: throttling, timeouts,
+// connection failures and server-side errors.  Anything else, such as a
+// validation error or a missing resource, is permanent.
+func IsTransient(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	if request.IsErrorThrottle(awsErr) || request.IsErrorRetryable(awsErr) {
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	if errors.As(err, &requestFailure) && requestFailure.StatusCode() >= 500 {
+		return true
+	}
+	return false
+}
+
+// IsThrottle reports whether err is an AWS throttling error, which is known
+// not to have been applied and so is safe to retry even for a mutation.
+func IsThrottle(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && request.IsErrorThrottle(awsErr)
+}
+
+// Retry calls fn until it succeeds, fails with an error that shouldRetry
+// rejects, ctx is cancelled or RetryAttempts have been made.
+func Retry(ctx context.Context, shouldRetry func(error) bool, fn func() error) error {
+	backoff := retryBackoff
+	var err error
+	for attempt := 1; attempt <= RetryAttempts; attempt++ {
+		err = fn()
+		if err == nil || !shouldRetry(err) || attempt == RetryAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
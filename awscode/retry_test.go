@@ -0,0 +1,94 @@
+package awscode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var (
+	throttled   = awserr.NewRequestFailure(awserr.New("Throttling", "Rate exceeded", nil), 400, "1")
+	serverError = awserr.NewRequestFailure(awserr.New("InternalFailure", "internal error", nil), 500, "2")
+	timedOut    = awserr.New("RequestTimeout", "request timed out", nil)
+	invalid     = awserr.NewRequestFailure(awserr.New("ValidationError", "bad launch configuration", nil), 400, "3")
+	notFound    = awserr.NewRequestFailure(awserr.New("InvalidInstanceID.NotFound", "no such instance", nil), 400, "4")
+)
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		name          string
+		err           error
+		wantTransient bool
+		wantThrottle  bool
+	}{
+		{"nil", nil, false, false},
+		{"not an AWS error", errors.New("boom"), false, false},
+		{"throttled", throttled, true, true},
+		{"wrapped throttle", fmt.Errorf("could not describe groups: %w", throttled), true, true},
+		{"server error", serverError, true, false},
+		{"timed out", timedOut, true, false},
+		{"validation error", invalid, false, false},
+		{"missing resource", notFound, false, false},
+	}
+	for _, c := range cases {
+		if got := IsTransient(c.err); got != c.wantTransient {
+			t.Errorf("%v: IsTransient() = %v, want %v", c.name, got, c.wantTransient)
+		}
+		if got := IsThrottle(c.err); got != c.wantThrottle {
+			t.Errorf("%v: IsThrottle() = %v, want %v", c.name, got, c.wantThrottle)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	cases := []struct {
+		name        string
+		errs        []error
+		shouldRetry func(error) bool
+		wantCalls   int
+		wantErr     error
+	}{
+		{"success", []error{nil}, IsTransient, 1, nil},
+		{"transient then success", []error{throttled, serverError, nil}, IsTransient, 3, nil},
+		{"permanent", []error{invalid, nil}, IsTransient, 1, invalid},
+		{"transient then permanent", []error{timedOut, notFound, nil}, IsTransient, 2, notFound},
+		{"attempts run out", []error{throttled, throttled, throttled, throttled, throttled, nil},
+			IsTransient, RetryAttempts, throttled},
+		{"only throttles retried", []error{serverError, nil}, IsThrottle, 1, serverError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), c.shouldRetry, func() error {
+				calls++
+				return c.errs[calls-1]
+			})
+			if err != c.wantErr {
+				t.Errorf("Retry() = %v, want %v", err, c.wantErr)
+			}
+			if calls != c.wantCalls {
+				t.Errorf("%v calls, want %v", calls, c.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := Retry(ctx, IsTransient, func() error {
+		calls++
+		return throttled
+	})
+	if err != throttled || calls != 1 {
+		t.Errorf("Retry() = %v after %v calls, want the throttle after 1", err, calls)
+	}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/davidboren/k8-spot-daemon/history"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+	"github.com/spf13/cobra"
+)
+
+var backtestDemandFile string
+var backtestStart string
+var backtestEnd string
+var backtestInstanceType string
+
+func init() {
+	backtestCmd.Flags().StringVar(&backtestDemandFile, "demandFile", "",
+		"Set the CSV file of the cluster's demand over time, with 'time' and 'totalMemoryRequestedGB' columns and optionally 'maxMemoryRequestedGB'.")
+	backtestCmd.Flags().StringVar(&backtestStart, "start", "",
+		"Set the RFC 3339 time to start the backtest at (the first demand sample if empty).")
+	backtestCmd.Flags().StringVar(&backtestEnd, "end", "",
+		"Set the RFC 3339 time to end the backtest at (the last demand sample if empty).")
+	backtestCmd.Flags().StringVar(&backtestInstanceType, "instanceType", "",
+		"Set the instance type the autoscaling group starts on (the first decision's pick if empty).")
+	RootCmd.AddCommand(backtestCmd)
+}
+
+// loadBacktest reads the demand and the price history a backtest of
+// spotConfig needs, looking back lookback before its start.
+func loadBacktest(spotConfig awscode.SpotConfig, lookback time.Duration) (core.Backtest, error) {
+	if len(spotConfig.PriceHistoryFile) == 0 || len(backtestDemandFile) == 0 {
+		return core.Backtest{}, fmt.Errorf("a backtest needs a priceHistoryFile and a demandFile")
+	}
+	demand, err := core.ReadDemand(backtestDemandFile)
+	if err != nil {
+		return core.Backtest{}, err
+	}
+	start, end := demand[0].Time, demand[len(demand)-1].Time
+	if len(backtestStart) > 0 {
+		if start, err = time.Parse(time.RFC3339, backtestStart); err != nil {
+			return core.Backtest{}, err
+		}
+	}
+	if len(backtestEnd) > 0 {
+		if end, err = time.Parse(time.RFC3339, backtestEnd); err != nil {
+			return core.Backtest{}, err
+		}
+	}
+
+	details, err := pricing.ReadDetails()
+	if err != nil {
+		return core.Backtest{}, err
+	}
+	store, err := history.OpenReadOnly(spotConfig.PriceHistoryFile)
+	if err != nil {
+		return core.Backtest{}, err
+	}
+	defer store.Close()
+	backtest, err := core.LoadBacktest(store, spotConfig.RegionName, details, demand, start, end, lookback)
+	if err != nil {
+		return core.Backtest{}, err
+	}
+	if len(spotConfig.InterruptionFrequencyFile) > 0 {
+		backtest.Frequencies, err = pricing.ReadInterruptionFrequencies(spotConfig.InterruptionFrequencyFile, spotConfig.RegionName)
+		if err != nil {
+			return core.Backtest{}, err
+		}
+	}
+	backtest.InstanceType = backtestInstanceType
+	return backtest, nil
+}
+
+// getOfflineSpotConfig returns the configuration for a command that reads and
+// changes nothing in AWS, which needs no autoscaling group or prefix.
+func getOfflineSpotConfig() (awscode.SpotConfig, error) {
+	spotConfig := awscode.GetSpotConfigFromCommand(RootCmd)
+	if len(spotConfig.AutoScalingGroupName) == 0 {
+		spotConfig.AutoScalingGroupName = "backtest"
+	}
+	if len(spotConfig.LaunchConfigurationPrefix) == 0 {
+		spotConfig.LaunchConfigurationPrefix = "backtest"
+	}
+	return spotConfig, spotConfig.Validate()
+}
+
+var backtestCmd = &cobra.Command{
+	Use:   "backtest",
+	Short: "Replay recorded spot prices and demand through the decision logic",
+	Long:  `Replays the spot price history of the priceHistoryFile and the demand of the demandFile through the same instance type selection and bidding as run, with a simulated clock, and reports the total cost, the switches and the interruptions (the market price rising above the bid) against holding a single instance type throughout.  Nothing in AWS or Kubernetes is read or changed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spotConfig, err := getOfflineSpotConfig()
+		if err != nil {
+			return err
+		}
+		backtest, err := loadBacktest(spotConfig, time.Duration(spotConfig.HistoricalHours*float64(time.Hour)))
+		if err != nil {
+			return err
+		}
+		report, err := backtest.Run(spotConfig)
+		if err != nil {
+			return err
+		}
+		core.PrintBacktest(report)
+		return nil
+	}}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/spf13/cobra"
+)
+
+var gcRetentionHours float64
+var gcDryRun bool
+
+func init() {
+	gcCmd.Flags().Float64Var(&gcRetentionHours, "retentionHours", 24,
+		"Set how long an orphaned launch configuration is kept before it is deleted.")
+	gcCmd.Flags().BoolVar(&gcDryRun, "dryRun", false,
+		"Whether to only list what would be deleted.")
+	RootCmd.AddCommand(gcCmd)
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete orphaned launch configurations created by the daemon",
+	Long:  `Lists the launch configurations created by the daemon (recorded in the registry ConfigMap, or named for the launchConfigurationPrefix) and deletes those that no autoscaling group has attached, keeping the keepLaunchConfigurations newest of each owner for rollback and any younger than the retention.  With --dryRun or --monitor nothing is deleted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spotConfig := awscode.GetSpotConfigFromCommand(RootCmd)
+		monitor, _ := RootCmd.PersistentFlags().GetBool("monitor")
+
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(spotConfig.RegionName),
+		})
+		if err != nil {
+			return err
+		}
+		clientset, err := k8code.GetClientSet()
+		if err != nil && len(spotConfig.RegistryName) > 0 {
+			return err
+		}
+		return core.CollectGarbage(context.Background(), sess, clientset, spotConfig, core.GarbageCollectionPolicy{
+			Retention: time.Duration(gcRetentionHours * float64(time.Hour)),
+			Keep:      spotConfig.KeepLaunchConfigurations,
+			DryRun:    gcDryRun || monitor})
+	}}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/davidboren/k8-spot-daemon/logging"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the plan command.  An error exits with 1, as every command does.
+const (
+	planExitNoChange = 0
+	planExitError    = 1
+	planExitChange   = 2
+)
+
+var planOutput string
+
+func init() {
+	planCmd.Flags().StringVar(&planOutput, "output", "text", "Set the output format, 'text' or 'json'.")
+	RootCmd.AddCommand(planCmd)
+}
+
+var planCmd = &cobra.Command{
+	Use:           "plan",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	Short:         "Evaluate the autoscaling group once and print what run would change",
+	Long:          `Makes one full evaluation of the autoScalingGroupName, or of every SpotPolicy with --watchSpotPolicies, and prints the configuration before and after, the ranking of the candidate instance types and the estimated savings, without changing anything.  Exits with 0 if no change is recommended, 2 if one is and 1 on error.  Logs are written to standard error.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if planOutput != "text" && planOutput != "json" {
+			return fmt.Errorf("unknown output format '%v' (expected text or json)", planOutput)
+		}
+		// In json mode standard out only ever holds JSON, so errors are
+		// written as JSON too.
+		fail := func(err error) error {
+			if planOutput == "json" {
+				core.WritePlanJSON(os.Stdout, nil, err)
+				os.Exit(planExitError)
+			}
+			return err
+		}
+		spotConfig := awscode.GetSpotConfigFromCommand(RootCmd)
+		if err := spotConfig.Validate(); err != nil {
+			return fail(err)
+		}
+		if err := logging.SetupWriter(os.Stderr, spotConfig.LogFormat, spotConfig.LogLevel); err != nil {
+			return fail(err)
+		}
+
+		results, err := core.Plan(context.Background(), spotConfig)
+		if err != nil {
+			return fail(err)
+		}
+		if planOutput == "json" {
+			if err := core.WritePlanJSON(os.Stdout, results, nil); err != nil {
+				return fail(err)
+			}
+		} else {
+			core.PrintPlan(results)
+		}
+		if exitCode := planExitCode(results); exitCode != planExitNoChange {
+			os.Exit(exitCode)
+		}
+		return nil
+	}}
+
+// planExitCode returns the exit code for a plan: an error for any group
+// outweighs a change recommended for another.
+func planExitCode(results []core.PlanResult) int {
+	switch {
+	case core.PlanFailed(results):
+		return planExitError
+	case core.PlanChanges(results):
+		return planExitChange
+	}
+	return planExitNoChange
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/davidboren/k8-spot-daemon/core"
+)
+
+func TestPlanExitCode(t *testing.T) {
+	noChange := core.PlanResult{AutoScalingGroupName: "a", Decision: core.Decision{Outcome: core.DecisionNoChange}}
+	change := core.PlanResult{AutoScalingGroupName: "b", Decision: core.Decision{Outcome: core.DecisionSwitch}}
+	failed := core.PlanResult{AutoScalingGroupName: "c", Err: fmt.Errorf("no spot price history")}
+	cases := []struct {
+		name    string
+		results []core.PlanResult
+		want    int
+	}{
+		{"no groups", nil, planExitNoChange},
+		{"no change", []core.PlanResult{noChange}, planExitNoChange},
+		{"change", []core.PlanResult{noChange, change}, planExitChange},
+		{"error", []core.PlanResult{noChange, failed}, planExitError},
+		{"error outweighs change", []core.PlanResult{change, failed}, planExitError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := planExitCode(c.results); got != c.want {
+				t.Errorf("planExitCode = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
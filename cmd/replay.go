@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay snapshotFile",
+	Short: "Make the decision recorded in a snapshot again, offline",
+	Long:  `Feeds the autoscaling group, launch configurations, spot price history, interruptions and pod summary of a snapshot written with --record back through the decision logic, with the configuration and clock the daemon had, and prints the resulting decision next to the recorded one.  The PodDisruptionBudget check and the apply are not replayed, and nothing in AWS or Kubernetes is read or changed.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshot, err := core.ReadSnapshot(args[0])
+		if err != nil {
+			return err
+		}
+		decision, err := snapshot.Replay()
+		if err != nil {
+			return err
+		}
+		core.PrintReplay(snapshot, decision)
+		return nil
+	}}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [launchConfigurationName]",
+	Short: "Re-point the autoscaling group at an earlier launch configuration",
+	Long:  `Lists the launch configurations kept for the autoScalingGroupName's launchConfigurationPrefix and re-points the group at the given one, or at the most recent one it is not using if none is given.  With --monitor the rollback is only reported.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spotConfig := awscode.GetSpotConfigFromCommand(RootCmd)
+		if err := spotConfig.Validate(); err != nil {
+			return err
+		}
+		monitor, _ := RootCmd.PersistentFlags().GetBool("monitor")
+		launchConfigurationName := ""
+		if len(args) > 0 {
+			launchConfigurationName = args[0]
+		}
+
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(spotConfig.RegionName),
+		})
+		if err != nil {
+			return err
+		}
+		return core.RollbackAutoScalingGroup(context.Background(), sess, spotConfig, launchConfigurationName, monitor)
+	}}
@@ -5,11 +5,14 @@ import (
 	"os"
 
 	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/logging"
 	"github.com/spf13/cobra"
 )
 
 var spotConfig awscode.SpotConfig
+var configFile string
 var monitor bool
+var maxPodKills int
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -17,6 +20,12 @@ var RootCmd = &cobra.Command{
 	Short: "Monitor spot instance pricing and adjust your kubernetes cluster autoscaler accordingly.",
 	Long: `K8-Spot-Daemon is a CLI library for Go that allows for simple adjustment of your aws autoscaler
 in accordance with the needs of your kubernetes cluster.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := awscode.ApplyConfigSources(cmd.Root(), configFile); err != nil {
+			return err
+		}
+		return logging.Setup(spotConfig.LogFormat, spotConfig.LogLevel)
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -30,37 +39,26 @@ func Execute() {
 
 func init() {
 
-	var spotConfig awscode.SpotConfig = awscode.SpotConfig{
-		AutoScalingGroupName:         "",
-		LaunchConfigurationPrefix:    "",
-		MaxAutoscalingNodes:          20,
-		HistoricalHours:              3,
-		RegionName:                   "us-west-2",
-		MaxCV:                        0.05,
-		MinGB:                        30.0,
-		MaxDollarsPerGB:              0.01,
-		MaxDollarsPerCPU:             0.03,
-		MaxPodKills:                  20,
-		MaxTotalDollarsPerHour:       12.0,
-		MinMarkupPercentage:          10,
-		MemoryBufferPercentage:       5,
-		MinPriceDifferencePercentage: 10,
-		UpdateIntervalSeconds:        300,
-		MinimumTurnoverSeconds:       1200,
-	}
+	spotConfig = awscode.DefaultSpotConfig()
+
+	RootCmd.PersistentFlags().StringVar(
+		&configFile,
+		"config",
+		"",
+		"Set the path of a YAML or JSON config file. Flags take precedence over K8_SPOT_DAEMON_* environment variables, which take precedence over the file.")
 
 	RootCmd.PersistentFlags().StringVarP(
 		&spotConfig.AutoScalingGroupName,
 		"autoScalingGroupName",
 		"q",
-		"",
+		spotConfig.AutoScalingGroupName,
 		"Set your aws Autoscaling Group Name")
 
 	RootCmd.PersistentFlags().StringVarP(
 		&spotConfig.LaunchConfigurationPrefix,
 		"launchConfigurationPrefix",
 		"l",
-		"",
+		spotConfig.LaunchConfigurationPrefix,
 		"Set the prefix to use for all generated Launch Configurations")
 
 	RootCmd.PersistentFlags().IntVarP(
@@ -70,12 +68,19 @@ func init() {
 		spotConfig.MaxAutoscalingNodes,
 		"Set the maximum number of autoscaling nodes here. (Used for totalDollars/Hour calculation only)")
 
-	spotConfig.HistoricalHours = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.HistoricalHours,
 		"historicalHours",
 		"s",
 		spotConfig.HistoricalHours,
 		"Set the hours over which spot instance price data should be averaged (in a weighted fashion)")
 
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.WeightingKernel,
+		"weightingKernel",
+		spotConfig.WeightingKernel,
+		"Set how price points are weighted by age when averaged: 'inverse' (1/(0.2 + hours ago)), 'uniform' or 'exponential' (halving every quarter of historicalHours).")
+
 	RootCmd.PersistentFlags().StringVarP(
 		&spotConfig.RegionName,
 		"regionName",
@@ -83,73 +88,278 @@ func init() {
 		spotConfig.RegionName,
 		"Set the Region in which to monitor spot pricing data")
 
-	spotConfig.MaxCV = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MaxCV,
 		"maxCV",
 		"c",
 		spotConfig.MaxCV,
 		"Set the Maximum coefficient of variation (of spotprice within the hour-window) allowable for an instance type to be considered for a switch.")
 
-	spotConfig.MinGB = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MinGB,
 		"minGB",
 		"y",
 		spotConfig.MinGB,
 		"Set the Minimum GB memory necessary for an instance type to be considered for a switch.")
 
-	spotConfig.MaxDollarsPerGB = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MaxDollarsPerGB,
 		"maxDollarsPerGB",
 		"g",
 		spotConfig.MaxDollarsPerGB,
 		"Set the Maximum hourly Dollars per GB of memory allowable for an instance type to be considered for a switch.")
 
-	spotConfig.MaxDollarsPerCPU = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MaxDollarsPerCPU,
 		"maxDollarsPerCPU",
 		"p",
 		spotConfig.MaxDollarsPerCPU,
 		"Set the Maximum hourly Dollars per CPU allowable for an instance type to be considered for a switch.")
 
 	RootCmd.PersistentFlags().IntVarP(
-		&spotConfig.MaxPodKills,
+		&maxPodKills,
 		"maxPodKills",
 		"k",
-		spotConfig.MaxPodKills,
+		20,
 		"Set the Maximum number of running pods that we are allowed to kill with an autoscaler instance-type switch.")
+	RootCmd.PersistentFlags().MarkDeprecated(
+		"maxPodKills",
+		"instance-type switches are now gated on the PodDisruptionBudgets covering the affected pods")
 
-	spotConfig.MaxTotalDollarsPerHour = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MaxTotalDollarsPerHour,
 		"maxTotalDollarsPerHour",
 		"t",
 		spotConfig.MaxTotalDollarsPerHour,
 		"Set the Maximum dollars per hour that we can spend on the autoscaler (takes into account maxAutoscalingNodes)")
 
-	spotConfig.MinMarkupPercentage = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MinMarkupPercentage,
 		"minMarkupPercentage",
 		"r",
 		spotConfig.MinMarkupPercentage,
 		"Set the Minimum markup percentage (over the current averaged spotprice) allowed when choosing a bid price")
 
-	spotConfig.MemoryBufferPercentage = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MemoryBufferPercentage,
 		"memoryBufferPercentage",
 		"b",
 		spotConfig.MemoryBufferPercentage,
 		"Set the percentage of memory reserved for the kubernetes system on each machine.")
 
-	spotConfig.MinPriceDifferencePercentage = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MinPriceDifferencePercentage,
 		"minPriceDifferencePercentage",
 		"d",
 		spotConfig.MinPriceDifferencePercentage,
 		"Set the minimum bid price difference necessary to make an alteration to the bid price.")
 
-	spotConfig.UpdateIntervalSeconds = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.UpdateIntervalSeconds,
 		"updateIntervalSeconds",
 		"u",
 		spotConfig.UpdateIntervalSeconds,
 		"Set the seconds to sleep between each spot price check.")
 
-	spotConfig.MinimumTurnoverSeconds = *RootCmd.PersistentFlags().Float64P(
+	RootCmd.PersistentFlags().Float64VarP(
+		&spotConfig.MinimumTurnoverSeconds,
 		"minimumTurnoverSeconds",
 		"v",
 		spotConfig.MinimumTurnoverSeconds,
 		"Set the mandatory wait time between re-configuring your AutoScalingGroup")
 
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.SwitchingCostPerNode,
+		"switchingCostPerNode",
+		spotConfig.SwitchingCostPerNode,
+		"Set the estimated dollar cost of turning over a single node (pod restarts, image pulls, partially used billing).")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.AmortizationHours,
+		"amortizationHours",
+		spotConfig.AmortizationHours,
+		"Set the hours over which expected savings must exceed the switching cost before an instance-type switch is made.")
+
+	RootCmd.PersistentFlags().BoolVar(
+		&spotConfig.RotateNodes,
+		"rotateNodes",
+		spotConfig.RotateNodes,
+		"Whether nodes still on the previous launch configuration should be cordoned, drained and replaced after a switch.")
+
+	RootCmd.PersistentFlags().IntVar(
+		&spotConfig.RotationBatchSize,
+		"rotationBatchSize",
+		spotConfig.RotationBatchSize,
+		"Set the number of nodes replaced at a time during a rotation.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.DrainTimeoutSeconds,
+		"drainTimeoutSeconds",
+		spotConfig.DrainTimeoutSeconds,
+		"Set the maximum seconds to wait for a node to drain during a rotation.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.ReadyTimeoutSeconds,
+		"readyTimeoutSeconds",
+		spotConfig.ReadyTimeoutSeconds,
+		"Set the maximum seconds to wait for replacement nodes to become Ready during a rotation.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.InterruptionQueueURL,
+		"interruptionQueueURL",
+		spotConfig.InterruptionQueueURL,
+		"Set the SQS queue URL receiving EventBridge spot interruption and rebalance recommendation events.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.InterruptionWindowHours,
+		"interruptionWindowHours",
+		spotConfig.InterruptionWindowHours,
+		"Set the hours over which interruptions are counted towards an instance type's interruption rate.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.InterruptionExclusionSeconds,
+		"interruptionExclusionSeconds",
+		spotConfig.InterruptionExclusionSeconds,
+		"Set the seconds for which a reclaimed instance type is excluded from consideration for a switch.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.InterruptionPenaltyPercentage,
+		"interruptionPenaltyPercentage",
+		spotConfig.InterruptionPenaltyPercentage,
+		"Set the percentage added to an instance type's hourly cost, for each interruption within the window, when ranking types.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.InterruptionFrequencyFile,
+		"interruptionFrequencyFile",
+		spotConfig.InterruptionFrequencyFile,
+		"Set the path of a Spot Advisor JSON file giving the interruption-frequency bucket of each instance type.")
+
+	RootCmd.PersistentFlags().IntVar(
+		&spotConfig.MaxInterruptionBucket,
+		"maxInterruptionBucket",
+		spotConfig.MaxInterruptionBucket,
+		"Set the highest interruption-frequency bucket (0 is '<5%') allowable for an instance type to be considered for a switch.  Types missing from the frequency file pass unless rejectUnknownFrequency is set.")
+
+	RootCmd.PersistentFlags().BoolVar(
+		&spotConfig.RejectUnknownFrequency,
+		"rejectUnknownFrequency",
+		spotConfig.RejectUnknownFrequency,
+		"Reject instance types missing from the interruptionFrequencyFile instead of letting them pass maxInterruptionBucket.")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.FrequencyPenaltyPercentage,
+		"frequencyPenaltyPercentage",
+		spotConfig.FrequencyPenaltyPercentage,
+		"Set the percentage added to an instance type's hourly cost, per interruption-frequency bucket, when ranking types.")
+
+	RootCmd.PersistentFlags().BoolVar(
+		&spotConfig.WatchSpotPolicies,
+		"watchSpotPolicies",
+		spotConfig.WatchSpotPolicies,
+		"Whether to manage every autoscaling group described by a SpotPolicy object instead of the single group given by flags.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.SpotPolicyNamespace,
+		"spotPolicyNamespace",
+		spotConfig.SpotPolicyNamespace,
+		"Set the namespace whose SpotPolicies are managed (all namespaces if empty).")
+
+	RootCmd.PersistentFlags().BoolVar(
+		&spotConfig.LeaderElect,
+		"leaderElect",
+		spotConfig.LeaderElect,
+		"Whether replicas should elect a leader through a ConfigMap, so that only the leader updates the autoscaler.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.LeaderElectionNamespace,
+		"leaderElectionNamespace",
+		spotConfig.LeaderElectionNamespace,
+		"Set the namespace of the leader election ConfigMap.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.LeaderElectionName,
+		"leaderElectionName",
+		spotConfig.LeaderElectionName,
+		"Set the name of the leader election ConfigMap.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.RegistryNamespace,
+		"registryNamespace",
+		spotConfig.RegistryNamespace,
+		"Set the namespace of the ConfigMap recording the launch configurations the daemon created.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.RegistryName,
+		"registryName",
+		spotConfig.RegistryName,
+		"Set the name of the ConfigMap recording the launch configurations the daemon created (disabled if empty).")
+
+	RootCmd.PersistentFlags().IntVar(
+		&spotConfig.KeepLaunchConfigurations,
+		"keepLaunchConfigurations",
+		spotConfig.KeepLaunchConfigurations,
+		"Set how many previous launch configurations are kept after a switch, to roll back to.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.ListenAddress,
+		"listenAddress",
+		spotConfig.ListenAddress,
+		"Set the address serving /metrics, /healthz, /readyz and /status while running (disabled if empty).")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.HeartbeatTimeoutSeconds,
+		"heartbeatTimeoutSeconds",
+		spotConfig.HeartbeatTimeoutSeconds,
+		"Set how many seconds the loop may go without a heartbeat, past its next scheduled check, before /healthz fails.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.EventObject,
+		"eventObject",
+		spotConfig.EventObject,
+		"Set the Kind/namespace/name of the object, such as the daemon's Deployment, to record Kubernetes Events on (disabled if empty).  SpotPolicy decisions are recorded on the SpotPolicy.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.NotificationsFile,
+		"notificationsFile",
+		spotConfig.NotificationsFile,
+		"Set the YAML file listing the webhooks to notify of decisions and repeated errors (disabled if empty).")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.PriceHistoryFile,
+		"priceHistoryFile",
+		spotConfig.PriceHistoryFile,
+		"Set the file, ideally on a persistent volume, to keep spot price history in so that only new price points are fetched (disabled if empty).")
+
+	RootCmd.PersistentFlags().Float64Var(
+		&spotConfig.PriceHistoryRetentionHours,
+		"priceHistoryRetentionHours",
+		spotConfig.PriceHistoryRetentionHours,
+		"Set how many hours of spot price history the priceHistoryFile keeps.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.RecordDirectory,
+		"record",
+		spotConfig.RecordDirectory,
+		"Set the directory to write a JSON snapshot of each evaluation's inputs to, for the replay command (disabled if empty).")
+
+	RootCmd.PersistentFlags().IntVar(
+		&spotConfig.RecordKeep,
+		"recordKeep",
+		spotConfig.RecordKeep,
+		"Set how many snapshots the record directory keeps, deleting the oldest (0 keeps them all).")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.LogFormat,
+		"logFormat",
+		spotConfig.LogFormat,
+		"Set the log format, 'text' or 'json'.")
+
+	RootCmd.PersistentFlags().StringVar(
+		&spotConfig.LogLevel,
+		"logLevel",
+		spotConfig.LogLevel,
+		"Set the minimum log level: 'debug', 'info', 'warn' or 'error'.")
+
 	RootCmd.PersistentFlags().BoolVarP(
 		&monitor,
 		"monitor",
@@ -1,6 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/davidboren/k8-spot-daemon/awscode"
 	"github.com/davidboren/k8-spot-daemon/core"
 	"github.com/spf13/cobra"
@@ -14,17 +20,29 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Repeatedly pull spot instance pricing and adjust autoscaler if monitor flag is not set",
 	Long:  `Runs a loop monitoring the current instance pricing and, if the monitor flag is not set, adjusts the autoScalingGroup accordingly.  If monitor is set to true, then it reports to standard out the autoscaler adjustments that it WOULD have made, had it been actually running`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		spotConfig := awscode.GetSpotConfigFromCommand(RootCmd)
-
-		if len(spotConfig.AutoScalingGroupName) == 0 {
-			panic("You must set an AutoScalingGroupName (--autoScalingGroupName or -q) to use this daemon")
+		if err := spotConfig.Validate(); err != nil {
+			return err
 		}
 
-		if len(spotConfig.AutoScalingGroupName) == 0 {
-			panic("You must set an LaunchConfigurationPrefix (--launchConfigurationPrefix or -l) to use this daemon")
+		var configWatcher *core.ConfigWatcher
+		if len(configFile) > 0 {
+			configWatcher = core.NewConfigWatcher(configFile, func() (awscode.SpotConfig, error) {
+				return awscode.ReloadSpotConfig(RootCmd, configFile)
+			})
 		}
 
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			received := <-signals
+			slog.Info("received signal, finishing the current iteration", "signal", received.String())
+			cancel()
+		}()
+
 		monitor, _ := RootCmd.PersistentFlags().GetBool("monitor")
-		core.RunDaemon(monitor, spotConfig)
+		return core.RunDaemon(ctx, monitor, spotConfig, configWatcher)
 	}}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/core"
+	"github.com/spf13/cobra"
+)
+
+var tuneSpace core.TuneSpace
+var tunePenalties core.TunePenalties
+var tuneSamples int
+var tuneSeed int64
+var tuneConfigOutput string
+
+func init() {
+	tuneCmd.Flags().StringVar(&backtestDemandFile, "demandFile", "",
+		"Set the CSV file of the cluster's demand over time, as for backtest.")
+	tuneCmd.Flags().StringVar(&backtestStart, "start", "",
+		"Set the RFC 3339 time to start each backtest at (the first demand sample if empty).")
+	tuneCmd.Flags().StringVar(&backtestEnd, "end", "",
+		"Set the RFC 3339 time to end each backtest at (the last demand sample if empty).")
+	tuneCmd.Flags().StringVar(&backtestInstanceType, "instanceType", "",
+		"Set the instance type the autoscaling group starts on (the first decision's pick if empty).")
+	tuneCmd.Flags().Float64SliceVar(&tuneSpace.MaxCV, "maxCVValues", []float64{0.02, 0.05, 0.1, 0.2},
+		"Set the maxCV values to try.")
+	tuneCmd.Flags().Float64SliceVar(&tuneSpace.MinMarkupPercentage, "minMarkupPercentageValues", []float64{5, 10, 20},
+		"Set the minMarkupPercentage values to try.")
+	tuneCmd.Flags().Float64SliceVar(&tuneSpace.MinPriceDifferencePercentage, "minPriceDifferencePercentageValues",
+		[]float64{5, 10, 20}, "Set the minPriceDifferencePercentage values to try.")
+	tuneCmd.Flags().Float64SliceVar(&tuneSpace.HistoricalHours, "historicalHoursValues", []float64{1, 3, 6, 12},
+		"Set the historicalHours values to try.")
+	tuneCmd.Flags().StringSliceVar(&tuneSpace.WeightingKernel, "weightingKernels", awscode.WeightingKernels,
+		"Set the weightingKernel values to try.")
+	tuneCmd.Flags().IntVar(&tuneSamples, "samples", 0,
+		"Set how many configurations to try at random instead of every combination (0 for the full grid).")
+	tuneCmd.Flags().Int64Var(&tuneSeed, "seed", 1,
+		"Set the seed of the random choice of configurations.")
+	tuneCmd.Flags().Float64Var(&tunePenalties.Interruption, "interruptionPenalty", 1,
+		"Set the dollars added to a configuration's score for every interruption.")
+	tuneCmd.Flags().Float64Var(&tunePenalties.Turnover, "turnoverPenalty", 0,
+		"Set the dollars added to a configuration's score for every switch, on top of switchingCostPerNode.")
+	tuneCmd.Flags().StringVar(&tuneConfigOutput, "configOutput", "tuned.yaml",
+		"Set the config file to write the best scoring configuration to (none if empty).")
+	RootCmd.AddCommand(tuneCmd)
+}
+
+var tuneCmd = &cobra.Command{
+	Use:   "tune",
+	Short: "Search settings by backtesting each combination",
+	Long:  `Backtests every combination, or a random sample, of the given maxCV, minMarkupPercentage, minPriceDifferencePercentage, historicalHours and weightingKernel values.  Configurations are ranked by total cost plus penalties for interruptions and switches.  The Pareto frontier on total cost, interruptions and switches is printed, and the best scoring configuration is written to --configOutput for use with --config.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spotConfig, err := getOfflineSpotConfig()
+		if err != nil {
+			return err
+		}
+		if len(tuneSpace.MaxCV) == 0 || len(tuneSpace.MinMarkupPercentage) == 0 ||
+			len(tuneSpace.MinPriceDifferencePercentage) == 0 || len(tuneSpace.HistoricalHours) == 0 ||
+			len(tuneSpace.WeightingKernel) == 0 {
+			return fmt.Errorf("every setting needs at least one value to try")
+		}
+		lookback := 0.0
+		for _, hours := range tuneSpace.HistoricalHours {
+			if hours > lookback {
+				lookback = hours
+			}
+		}
+		backtest, err := loadBacktest(spotConfig, time.Duration(lookback*float64(time.Hour)))
+		if err != nil {
+			return err
+		}
+
+		trials := core.Tune(backtest, spotConfig, tuneSpace, tuneSamples, tuneSeed, tunePenalties)
+		core.PrintTune(trials, tunePenalties)
+		if len(trials) == 0 || trials[0].Err != nil || len(tuneConfigOutput) == 0 {
+			return nil
+		}
+		if err := awscode.WriteConfigFile(RootCmd, tuneConfigOutput, trials[0].Settings()); err != nil {
+			return err
+		}
+		fmt.Printf("\nWrote the best scoring configuration to '%v'.\n", tuneConfigOutput)
+		return nil
+	}}
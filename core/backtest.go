@@ -0,0 +1,385 @@
+package core
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/history"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// DemandSample is the cluster's pod summary from Time until the next sample.
+type DemandSample struct {
+	Time    time.Time
+	Summary map[string]float64
+}
+
+// ReadDemand reads a demand time series from a CSV file.  Its header names a
+// "time" column of RFC 3339 timestamps and the pod summary keys the other
+// columns hold, of which totalMemoryRequestedGB is required and
+// maxMemoryRequestedGB sizes the largest pod.
+func ReadDemand(path string) ([]DemandSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse demand file '%v': %w", path, err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("demand file '%v' has no samples", path)
+	}
+	header := rows[0]
+	timeColumn := -1
+	for i, name := range header {
+		if name == "time" {
+			timeColumn = i
+		}
+	}
+	if timeColumn < 0 || !contains(header, "totalMemoryRequestedGB") {
+		return nil, fmt.Errorf("demand file '%v' needs 'time' and 'totalMemoryRequestedGB' columns", path)
+	}
+
+	demand := []DemandSample{}
+	for line, row := range rows[1:] {
+		sample := DemandSample{Summary: map[string]float64{}}
+		for i, value := range row {
+			if i == timeColumn {
+				sample.Time, err = time.Parse(time.RFC3339, value)
+			} else {
+				sample.Summary[header[i]], err = strconv.ParseFloat(value, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("demand file '%v' line %v: %w", path, line+2, err)
+			}
+		}
+		demand = append(demand, sample)
+	}
+	sort.SliceStable(demand, func(i, j int) bool { return demand[i].Time.Before(demand[j].Time) })
+	return demand, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, each := range values {
+		if each == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Backtest holds the history a backtest replays: the spot prices of every
+// catalog instance type and the cluster's demand.
+type Backtest struct {
+	Prices      map[string]history.Series
+	Details     map[string]pricing.InstanceDetails
+	Frequencies map[string]pricing.InterruptionBucket
+	Demand      []DemandSample
+	Start       time.Time
+	End         time.Time
+	// InstanceType is the type the group starts on, at the bid the daemon
+	// would place at Start; if empty it starts on the first decision's pick.
+	InstanceType string
+	// SkipBaselines leaves the single instance type baselines out of reports.
+	SkipBaselines bool
+}
+
+// LoadBacktest reads the price history of the catalog's instance types in
+// regionName from store, from lookback before start until end.
+func LoadBacktest(store *history.Store, regionName string, details map[string]pricing.InstanceDetails,
+	demand []DemandSample, start time.Time, end time.Time, lookback time.Duration) (Backtest, error) {
+
+	prices := map[string]history.Series{}
+	for instanceType := range details {
+		points, err := store.Query(regionName, instanceType, start.Add(-lookback), end)
+		if err != nil {
+			return Backtest{}, err
+		}
+		if len(points) > 0 {
+			prices[instanceType] = history.NewSeries(points)
+		}
+	}
+	if len(prices) == 0 {
+		return Backtest{}, fmt.Errorf("the price history has no points for %v between %v and %v",
+			regionName, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	return Backtest{Prices: prices, Details: details, Demand: demand, Start: start, End: end}, nil
+}
+
+// BacktestResult is what running the group one way over the backtest cost.
+// Cost is paid at the market price in effect, not the bid, and an
+// interruption is counted whenever the market price in a zone rises above the
+// bid.
+type BacktestResult struct {
+	Name          string
+	Cost          float64
+	SwitchingCost float64
+	NodeHours     float64
+	Switches      int
+	BidChanges    int
+	Interruptions int
+	// Changes are the decisions that updated the group, without candidates.
+	Changes []Decision
+}
+
+// TotalCost is the cost of the nodes and of turning them over.
+func (r BacktestResult) TotalCost() float64 {
+	return r.Cost + r.SwitchingCost
+}
+
+// BacktestReport compares the daemon's decisions over a backtest with holding
+// a single instance type throughout: the one the group starts on and, if it
+// differs, the cheapest in hindsight of those eligible at the start.
+type BacktestReport struct {
+	Start       time.Time
+	End         time.Time
+	Evaluations int
+	Daemon      BacktestResult
+	Baselines   []BacktestResult
+}
+
+// demandAt returns the pod summary in effect at t, or the first one before
+// the series starts.
+func (b Backtest) demandAt(t time.Time) map[string]float64 {
+	i := sort.Search(len(b.Demand), func(i int) bool { return b.Demand[i].Time.After(t) })
+	if i > 0 {
+		i--
+	}
+	return b.Demand[i].Summary
+}
+
+// priceList prices every instance type as DescribePricing would have at now.
+func (b Backtest) priceList(spotConfig awscode.SpotConfig, tracker *pricing.InterruptionTracker,
+	now time.Time) []pricing.FullSummary {
+
+	since := now.Add(-time.Duration(spotConfig.HistoricalHours * float64(time.Hour)))
+	priceMap := map[string][]ec2.SpotPrice{}
+	for instanceType, series := range b.Prices {
+		priceMap[instanceType] = series.Window(since, now)
+	}
+	priceList := pricing.SummarizePrices(priceMap, b.Details, now, pricing.GetTimeWeight(spotConfig))
+	pricing.ApplyInterruptions(priceList, tracker,
+		time.Second*time.Duration(spotConfig.InterruptionExclusionSeconds), now)
+	pricing.ApplyInterruptionFrequencies(priceList, b.Frequencies)
+	sort.Stable(pricing.ByPricePerGB(priceList))
+	return priceList
+}
+
+// holding is an instance type held at a bid, whose cost and interruptions
+// accrue as the simulated clock advances.
+type holding struct {
+	instanceType string
+	bid          float64
+	above        map[string]bool
+}
+
+func newHolding(instanceType string, bid float64) *holding {
+	return &holding{instanceType: instanceType, bid: bid, above: map[string]bool{}}
+}
+
+// hold runs nodes of the held type from from until until, adding to result
+// what they cost at the market price, averaged over the zones, and returning
+// when the market price in a zone rose above the bid.
+func (b Backtest) hold(h *holding, nodes int, from time.Time, until time.Time, result *BacktestResult) []time.Time {
+	series := b.Prices[h.instanceType]
+	interruptions := []time.Time{}
+	times := append([]time.Time{from}, series.Changes(from, until)...)
+	for i, start := range times {
+		end := until
+		if i+1 < len(times) {
+			end = times[i+1]
+		}
+		prices := series.At(start)
+		sum := 0.0
+		for _, zone := range series.Zones() {
+			price, found := prices[zone]
+			if !found {
+				continue
+			}
+			sum += price
+			if price > h.bid && !h.above[zone] {
+				interruptions = append(interruptions, start)
+			}
+			h.above[zone] = price > h.bid
+		}
+		if len(prices) > 0 {
+			hours := end.Sub(start).Hours()
+			result.Cost += float64(nodes) * sum / float64(len(prices)) * hours
+			result.NodeHours += float64(nodes) * hours
+		}
+	}
+	result.Interruptions += len(interruptions)
+	return interruptions
+}
+
+// nodesFor returns how many nodes of instanceType the demand calls for.
+func (b Backtest) nodesFor(spotConfig awscode.SpotConfig, instanceType string, podSummary map[string]float64) int {
+	details := b.Details[instanceType]
+	summary := pricing.FullSummary{Name: details.Name, Cpus: details.Cpus, Mem: details.Mem}
+	return int(math.Min(float64(getNodesNeeded(summary, podSummary)), float64(spotConfig.MaxAutoscalingNodes)))
+}
+
+func findSummary(priceList []pricing.FullSummary, instanceType string) (pricing.FullSummary, bool) {
+	for _, instanceSummary := range priceList {
+		if instanceSummary.Name == instanceType {
+			return instanceSummary, true
+		}
+	}
+	return pricing.FullSummary{}, false
+}
+
+// Run replays the backtest through Decide with a simulated clock, evaluating
+// every UpdateIntervalSeconds, or MinimumTurnoverSeconds after an update, as
+// the daemon does.  Demand is sampled at each evaluation, and a zone's market
+// price rising above the bid is recorded as an interruption of the type.
+func (b Backtest) Run(spotConfig awscode.SpotConfig) (BacktestReport, error) {
+	if len(b.Demand) == 0 {
+		return BacktestReport{}, fmt.Errorf("a backtest needs demand samples")
+	}
+	if !b.Start.Before(b.End) {
+		return BacktestReport{}, fmt.Errorf("a backtest needs to start before it ends")
+	}
+	report := BacktestReport{Start: b.Start, End: b.End, Daemon: BacktestResult{Name: "k8-spot-daemon"}}
+	tracker := pricing.NewInterruptionTracker(time.Duration(spotConfig.InterruptionWindowHours * float64(time.Hour)))
+
+	// The group starts at the bid the daemon would place at the start.
+	priceList := b.priceList(spotConfig, tracker, b.Start)
+	podSummary := b.demandAt(b.Start)
+	maxMemoryRequired := (1 + spotConfig.MemoryBufferPercentage*0.01) * podSummary["maxMemoryRequestedGB"]
+	initialType, initialBid, _, found, candidates := getBestFilteredType(
+		"", 0, spotConfig, priceList, maxMemoryRequired, spotConfig.MaxAutoscalingNodes, podSummary)
+	if len(b.InstanceType) > 0 {
+		summary, priced := findSummary(priceList, b.InstanceType)
+		if !priced {
+			return BacktestReport{}, fmt.Errorf("instance type '%v' has no price history at %v",
+				b.InstanceType, b.Start.Format(time.RFC3339))
+		}
+		initialType, initialBid, found = b.InstanceType, getAdjustedSpotPrice(summary, spotConfig), true
+	}
+	if !found {
+		return BacktestReport{}, fmt.Errorf("no instance type satisfies the configured constraints at %v",
+			b.Start.Format(time.RFC3339))
+	}
+
+	state := GroupState{AutoScalingGroupName: "backtest", InstanceType: initialType,
+		Bid: strconv.FormatFloat(initialBid, 'f', 2, 64), Price: initialBid}
+	current := newHolding(initialType, initialBid)
+	instanceID := 0
+	for now := b.Start; now.Before(b.End); {
+		if now != b.Start {
+			priceList = b.priceList(spotConfig, tracker, now)
+			podSummary = b.demandAt(now)
+		}
+		state.Summary, found = findSummary(priceList, state.InstanceType)
+		if !found {
+			details := b.Details[state.InstanceType]
+			state.Summary = pricing.FullSummary{Name: details.Name, Cpus: details.Cpus, Mem: details.Mem}
+		}
+		state.Nodes = b.nodesFor(spotConfig, state.InstanceType, podSummary)
+
+		decision := Decide(spotConfig, state, priceList, podSummary, now)
+		report.Evaluations++
+		interval := time.Second * time.Duration(spotConfig.UpdateIntervalSeconds)
+		if decision.Updated() {
+			if decision.Outcome == DecisionBidChange {
+				report.Daemon.BidChanges++
+			} else {
+				report.Daemon.Switches++
+				report.Daemon.SwitchingCost += decision.SwitchingCost.TotalCost
+			}
+			decision.Candidates = nil
+			report.Daemon.Changes = append(report.Daemon.Changes, decision)
+			state.InstanceType = decision.NewInstanceType
+			state.Bid = strconv.FormatFloat(decision.NewSpotPrice, 'f', 2, 64)
+			state.Price = decision.NewSpotPrice
+			current = newHolding(decision.NewInstanceType, decision.NewSpotPrice)
+			interval = time.Second * time.Duration(spotConfig.MinimumTurnoverSeconds)
+		}
+
+		next := now.Add(interval)
+		if next.After(b.End) {
+			next = b.End
+		}
+		nodes := b.nodesFor(spotConfig, state.InstanceType, podSummary)
+		for _, interrupted := range b.hold(current, nodes, now, next, &report.Daemon) {
+			instanceID++
+			tracker.Record(pricing.Interruption{
+				InstanceID:   fmt.Sprintf("backtest-%v", instanceID),
+				InstanceType: state.InstanceType,
+				Time:         interrupted})
+		}
+		now = next
+	}
+
+	if b.SkipBaselines {
+		return report, nil
+	}
+	report.Baselines = append(report.Baselines, b.static(spotConfig, "static "+initialType, initialType, initialBid))
+	var best *BacktestResult
+	for _, candidate := range candidates {
+		if len(candidate.Rejection) > 0 {
+			continue
+		}
+		result := b.static(spotConfig, "best static "+candidate.InstanceType, candidate.InstanceType, candidate.SpotPrice)
+		if best == nil || result.TotalCost() < best.TotalCost() {
+			best = &result
+		}
+	}
+	if best != nil && best.Name != "best static "+initialType {
+		report.Baselines = append(report.Baselines, *best)
+	}
+	return report, nil
+}
+
+// static holds instanceType at bid throughout, sized to the demand every
+// UpdateIntervalSeconds.
+func (b Backtest) static(spotConfig awscode.SpotConfig, name string, instanceType string, bid float64) BacktestResult {
+	result := BacktestResult{Name: name}
+	h := newHolding(instanceType, bid)
+	interval := time.Second * time.Duration(spotConfig.UpdateIntervalSeconds)
+	for now := b.Start; now.Before(b.End); now = now.Add(interval) {
+		next := now.Add(interval)
+		if next.After(b.End) {
+			next = b.End
+		}
+		b.hold(h, b.nodesFor(spotConfig, instanceType, b.demandAt(now)), now, next, &result)
+	}
+	return result
+}
+
+func printBacktestResult(result BacktestResult, daemon BacktestResult) {
+	fmt.Printf("  %-28v || Cost: $%9.2f | Switching: $%7.2f | Total: $%9.2f | Node hours: %8.1f | Switches: %4v | Bid changes: %4v | Interruptions: %4v",
+		result.Name, result.Cost, result.SwitchingCost, result.TotalCost(), result.NodeHours,
+		result.Switches, result.BidChanges, result.Interruptions)
+	if result.Name != daemon.Name {
+		fmt.Printf(" | Daemon saves: $%9.2f", result.TotalCost()-daemon.TotalCost())
+	}
+	fmt.Printf("\n")
+}
+
+// PrintBacktest writes a backtest report to standard out.
+func PrintBacktest(report BacktestReport) {
+	fmt.Printf("\nBacktest from %v to %v, %v evaluations:\n", report.Start.Format(time.RFC3339),
+		report.End.Format(time.RFC3339), report.Evaluations)
+	printBacktestResult(report.Daemon, report.Daemon)
+	for _, baseline := range report.Baselines {
+		printBacktestResult(baseline, report.Daemon)
+	}
+	if len(report.Daemon.Changes) == 0 {
+		return
+	}
+	fmt.Printf("\nChanges:\n")
+	for _, change := range report.Daemon.Changes {
+		fmt.Printf("  %v || %-15v | %v (%v) -> %v (%v) | %v\n", change.Time.Format(time.RFC3339), change.Outcome,
+			change.OriginalInstanceType, change.OriginalSpotPrice, change.NewInstanceType, change.NewSpotPrice, change.Reason)
+	}
+}
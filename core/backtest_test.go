@@ -0,0 +1,158 @@
+package core
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/history"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func TestReadDemand(t *testing.T) {
+	cases := []struct {
+		name    string
+		csv     string
+		want    []DemandSample
+		wantErr string
+	}{
+		{"sorted by time", "totalMemoryRequestedGB,time,maxMemoryRequestedGB\n" +
+			"90,2024-01-01T01:00:00Z,8\n60,2024-01-01T00:00:00Z,4\n", []DemandSample{
+			{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]float64{"totalMemoryRequestedGB": 60, "maxMemoryRequestedGB": 4}},
+			{time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), map[string]float64{"totalMemoryRequestedGB": 90, "maxMemoryRequestedGB": 8}}}, ""},
+		{"no samples", "time,totalMemoryRequestedGB\n", nil, "has no samples"},
+		{"no total memory", "time,maxMemoryRequestedGB\n2024-01-01T00:00:00Z,4\n", nil,
+			"needs 'time' and 'totalMemoryRequestedGB' columns"},
+		{"bad time", "time,totalMemoryRequestedGB\nyesterday,60\n", nil, "line 2"},
+		{"bad number", "time,totalMemoryRequestedGB\n2024-01-01T00:00:00Z,60\n2024-01-01T01:00:00Z,lots\n", nil, "line 3"},
+		{"ragged rows", "time,totalMemoryRequestedGB\n2024-01-01T00:00:00Z\n", nil, "could not parse demand file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "demand.csv")
+			if err := os.WriteFile(path, []byte(c.csv), 0600); err != nil {
+				t.Fatal(err)
+			}
+			demand, err := ReadDemand(path)
+			if len(c.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("ReadDemand() error = %v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(demand, c.want) {
+				t.Errorf("ReadDemand() = %v, want %v", demand, c.want)
+			}
+		})
+	}
+}
+
+var backtestStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestBacktest returns six hours of two instance types and the
+// configuration to run them with.
+func newTestBacktest() (Backtest, awscode.SpotConfig) {
+	start := backtestStart
+	at := func(hours float64) *time.Time {
+		return aws.Time(start.Add(time.Duration(hours * float64(time.Hour))))
+	}
+	point := func(hours float64, price string) ec2.SpotPrice {
+		return ec2.SpotPrice{AvailabilityZone: aws.String("us-west-2a"), SpotPrice: aws.String(price), Timestamp: at(hours)}
+	}
+	spotConfig := awscode.DefaultSpotConfig()
+	spotConfig.HistoricalHours = 1
+	spotConfig.UpdateIntervalSeconds = 3600
+	spotConfig.MinimumTurnoverSeconds = 3600
+	// r5.xlarge is too expensive per CPU until its price drops at 1.5h, and is
+	// reclaimed when its price rises above the bid at 4.5h.
+	backtest := Backtest{
+		Prices: map[string]history.Series{
+			"r4.xlarge": history.NewSeries([]ec2.SpotPrice{point(-2, "0.10")}),
+			"r5.xlarge": history.NewSeries([]ec2.SpotPrice{point(-2, "0.15"), point(1.5, "0.05"), point(4.5, "0.20")}),
+		},
+		Details: map[string]pricing.InstanceDetails{
+			"r4.xlarge": {Name: "r4.xlarge", Mem: 30.5, Cpus: 4},
+			"r5.xlarge": {Name: "r5.xlarge", Mem: 32, Cpus: 4},
+		},
+		Demand: []DemandSample{{Time: start, Summary: map[string]float64{"totalMemoryRequestedGB": 60}}},
+		Start:  start,
+		End:    *at(6),
+	}
+	return backtest, spotConfig
+}
+
+func TestBacktestRun(t *testing.T) {
+	start := backtestStart
+	backtest, spotConfig := newTestBacktest()
+
+	report, err := backtest.Run(spotConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evaluations != 6 {
+		t.Errorf("Evaluations = %v, want 6", report.Evaluations)
+	}
+	changes := []string{}
+	for _, change := range report.Daemon.Changes {
+		changes = append(changes, change.Time.Sub(start).String()+" "+change.Outcome+" "+change.NewInstanceType)
+	}
+	wantChanges := []string{"3h0m0s switch r5.xlarge", "5h0m0s switch r4.xlarge"}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("Changes = %v, want %v", changes, wantChanges)
+	}
+	if reason := report.Daemon.Changes[1].Reason; reason != "'r5.xlarge' was recently reclaimed" {
+		t.Errorf("Reason = %q", reason)
+	}
+
+	results := map[string]BacktestResult{"daemon": report.Daemon}
+	for _, baseline := range report.Baselines {
+		results[baseline.Name] = baseline
+	}
+	want := map[string]BacktestResult{
+		// Two nodes of r4.xlarge for 4h at 0.10 and of r5.xlarge for 1.5h at
+		// 0.05 and 0.5h at 0.20.
+		"daemon":           {Cost: 1.15, SwitchingCost: 0.4, NodeHours: 12, Switches: 2, Interruptions: 1},
+		"static r4.xlarge": {Cost: 1.2, NodeHours: 12},
+	}
+	if len(results) != len(want) {
+		t.Errorf("results = %v, want %v", results, want)
+	}
+	for name, w := range want {
+		got := results[name]
+		if math.Abs(got.Cost-w.Cost) > 1e-9 || math.Abs(got.SwitchingCost-w.SwitchingCost) > 1e-9 ||
+			math.Abs(got.NodeHours-w.NodeHours) > 1e-9 || got.Switches != w.Switches ||
+			got.BidChanges != w.BidChanges || got.Interruptions != w.Interruptions {
+			t.Errorf("%v = %+v, want %+v", name, got, w)
+		}
+	}
+
+	failures := []struct {
+		name      string
+		configure func(*Backtest, *awscode.SpotConfig)
+		wantErr   string
+	}{
+		{"no demand", func(b *Backtest, c *awscode.SpotConfig) { b.Demand = nil }, "needs demand samples"},
+		{"ends before it starts", func(b *Backtest, c *awscode.SpotConfig) { b.End = b.Start }, "start before it ends"},
+		{"unpriced starting type", func(b *Backtest, c *awscode.SpotConfig) { b.InstanceType = "m5.large" },
+			"'m5.large' has no price history"},
+		{"no eligible type", func(b *Backtest, c *awscode.SpotConfig) { c.MinGB = 64 },
+			"no instance type satisfies the configured constraints"},
+	}
+	for _, f := range failures {
+		b, c := backtest, spotConfig
+		f.configure(&b, &c)
+		if _, err := b.Run(c); err == nil || !strings.Contains(err.Error(), f.wantErr) {
+			t.Errorf("%v: Run() error = %v, want %q", f.name, err, f.wantErr)
+		}
+	}
+}
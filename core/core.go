@@ -1,10 +1,14 @@
 package core
 
 import (
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -14,7 +18,10 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/history"
 	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/davidboren/k8-spot-daemon/metrics"
+	"github.com/davidboren/k8-spot-daemon/notify"
 	"github.com/davidboren/k8-spot-daemon/pricing"
 )
 
@@ -38,146 +45,384 @@ func getDollarsPerHour(instanceSummary pricing.FullSummary, nodesNeeded int, max
 	return math.Min(float64(nodesNeeded), float64(maxNodes)) * currentSpotPrice
 }
 
-func checkOriginalMemoryAndPrice(priceList []pricing.FullSummary,
-	spotConfig awscode.SpotConfig, podSummary map[string]float64, originalInstanceType string,
-	originalSpotPrice float64, maxMemoryRequired float64) (bool, float64) {
+// SwitchingCost models the one-off cost of turning over the autoscaling group's
+// nodes onto a new instance type, amortised over spotConfig.AmortizationHours.
+type SwitchingCost struct {
+	NodesAffected  int
+	TotalCost      float64
+	HourlySavings  float64
+	HorizonSavings float64
+	BreakEvenHours float64
+}
+
+// Covered reports whether the savings expected over the amortisation horizon
+// exceed the cost of turning the nodes over.
+func (s SwitchingCost) Covered() bool {
+	return s.TotalCost <= 0 || s.HorizonSavings > s.TotalCost
+}
+
+func getSwitchingCost(nodesAffected int, spotConfig awscode.SpotConfig,
+	originalDollarsPerHour float64, newDollarsPerHour float64) SwitchingCost {
+
+	totalCost := float64(nodesAffected) * spotConfig.SwitchingCostPerNode
+	hourlySavings := originalDollarsPerHour - newDollarsPerHour
+	breakEvenHours := math.Inf(1)
+	if hourlySavings > 0 {
+		breakEvenHours = totalCost / hourlySavings
+	}
+	return SwitchingCost{
+		NodesAffected:  nodesAffected,
+		TotalCost:      totalCost,
+		HourlySavings:  hourlySavings,
+		HorizonSavings: hourlySavings * spotConfig.AmortizationHours,
+		BreakEvenHours: breakEvenHours}
+}
+
+func logSwitchingCost(switchingCost SwitchingCost, spotConfig awscode.SpotConfig) {
+	attrs := []any{
+		"nodesAffected", switchingCost.NodesAffected,
+		"cost", switchingCost.TotalCost,
+		"amortizationHours", spotConfig.AmortizationHours,
+		"horizonSavings", switchingCost.HorizonSavings}
+	// A switch that saves nothing never breaks even, which JSON cannot encode.
+	if !math.IsInf(switchingCost.BreakEvenHours, 1) {
+		attrs = append(attrs, "breakEvenHours", switchingCost.BreakEvenHours)
+	}
+	slog.Info("switching cost", attrs...)
+}
+
+// getOriginalPrice returns the price the autoscaling group currently pays per
+// node: the spot bid of its launch configuration or, for an on-demand launch
+// configuration, the on-demand price of its instance type.
+func getOriginalPrice(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	launchConfiguration *autoscaling.LaunchConfiguration) (float64, bool, error) {
+
+	if launchConfiguration.SpotPrice == nil || len(*launchConfiguration.SpotPrice) == 0 {
+		onDemandPrice, err := awscode.GetOnDemandPrice(ctx, sess, spotConfig.RegionName, *launchConfiguration.InstanceType)
+		return onDemandPrice, true, err
+	}
+	spotPrice, err := strconv.ParseFloat(*launchConfiguration.SpotPrice, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("launchconfiguration '%v' has an unparsable SpotPrice: %w",
+			*launchConfiguration.LaunchConfigurationName, err)
+	}
+	return spotPrice, false, nil
+}
+
+func describeBid(launchConfiguration *autoscaling.LaunchConfiguration) string {
+	if launchConfiguration.SpotPrice == nil || len(*launchConfiguration.SpotPrice) == 0 {
+		return "on-demand"
+	}
+	return *launchConfiguration.SpotPrice
+}
 
-	originalDollarsPerHour := 0.0
-	foundOriginal := false
-	scaleMemory := false
+// getOriginalSummary sizes the current instance type.  A type without spot
+// price history, or missing from the catalog altogether, is looked up on demand
+// so the group can still be evaluated.
+func getOriginalSummary(ctx context.Context, sess *session.Session, priceList []pricing.FullSummary,
+	originalInstanceType string) (pricing.FullSummary, error) {
 
 	for _, instanceSummary := range priceList {
 		if instanceSummary.Name == originalInstanceType {
-			foundOriginal = true
-			nodesNeeded := getNodesNeeded(instanceSummary, podSummary)
-			originalDollarsPerHour = getDollarsPerHour(
-				instanceSummary, nodesNeeded, spotConfig.MaxAutoscalingNodes, originalSpotPrice)
-			if instanceSummary.Mem < maxMemoryRequired {
-				scaleMemory = true
-			}
-			break
+			return instanceSummary, nil
 		}
 	}
-	if !foundOriginal {
-		panic("Could not identify original instanceType in our pricing list...")
+	slog.Info("current instance type has no spot price history", "instanceType", originalInstanceType)
+	details, err := pricing.GetInstanceDetails(ctx, sess, originalInstanceType)
+	if err != nil {
+		return pricing.FullSummary{}, err
 	}
-	return scaleMemory, originalDollarsPerHour
+	return pricing.FullSummary{Name: details.Name, Cpus: details.Cpus, Mem: details.Mem}, nil
+}
 
+// getRejection returns why an instance type fails the configured constraints,
+// or "" if it satisfies them.
+func getRejection(instanceSummary pricing.FullSummary, spotConfig awscode.SpotConfig,
+	maxMemoryRequired float64, maxNodes int) string {
+
+	switch {
+	case instanceSummary.RecentlyInterrupted:
+		return "recently interrupted"
+	case instanceSummary.InterruptionBucket > spotConfig.MaxInterruptionBucket:
+		return "above maxInterruptionBucket"
+	// Without a frequency file every type is unknown, so unknown types are
+	// only rejected when there is one to be missing from.
+	case instanceSummary.InterruptionBucket == pricing.UnknownInterruptionBucket &&
+		spotConfig.RejectUnknownFrequency && len(spotConfig.InterruptionFrequencyFile) > 0:
+		return "unknown interruption frequency"
+	case instanceSummary.Mem < spotConfig.MinGB:
+		return "below minGB"
+	case instanceSummary.Mem < maxMemoryRequired:
+		return "too little memory for the largest pod"
+	case float64(maxNodes)*instanceSummary.Price >= spotConfig.MaxTotalDollarsPerHour:
+		return "above maxTotalDollarsPerHour"
+	case instanceSummary.PricePerGB >= spotConfig.MaxDollarsPerGB:
+		return "above maxDollarsPerGB"
+	case instanceSummary.PricePerCPU >= spotConfig.MaxDollarsPerCPU:
+		return "above maxDollarsPerCPU"
+	case instanceSummary.CoefVar >= spotConfig.MaxCV:
+		return "above maxCV"
+	}
+	return ""
 }
 
 func getBestFilteredType(originalInstanceType string, originalSpotPrice float64, spotConfig awscode.SpotConfig,
 	priceList []pricing.FullSummary, maxMemoryRequired float64, maxNodes int,
-	podSummary map[string]float64) (string, float64, float64, bool) {
+	podSummary map[string]float64) (string, float64, float64, bool, []Candidate) {
 
 	newInstanceType := originalInstanceType
 	newSpotPrice := originalSpotPrice
 	minActualDollarsPerHour := spotConfig.MaxTotalDollarsPerHour
+	minPenalizedDollarsPerHour := spotConfig.MaxTotalDollarsPerHour
 	anySatisfyConstraints := false
+	candidates := []Candidate{}
 	for _, instanceSummary := range priceList {
-		maxTotalDollarsPerHour := float64(maxNodes) * instanceSummary.Price
 		nodesNeeded := getNodesNeeded(instanceSummary, podSummary)
 		currentSpotPrice := getAdjustedSpotPrice(instanceSummary, spotConfig)
 		actualDollarsPerHour := getDollarsPerHour(
 			instanceSummary, nodesNeeded, spotConfig.MaxAutoscalingNodes, currentSpotPrice)
-		if instanceSummary.Mem >= spotConfig.MinGB {
-			if instanceSummary.Mem >= maxMemoryRequired {
-				if maxTotalDollarsPerHour < spotConfig.MaxTotalDollarsPerHour {
-					if instanceSummary.PricePerGB < spotConfig.MaxDollarsPerGB {
-						if instanceSummary.PricePerCPU < spotConfig.MaxDollarsPerCPU {
-							if instanceSummary.CoefVar < spotConfig.MaxCV {
-								if actualDollarsPerHour < minActualDollarsPerHour {
-									minActualDollarsPerHour = actualDollarsPerHour
-									newInstanceType = instanceSummary.Name
-									newSpotPrice = currentSpotPrice
-									anySatisfyConstraints = true
-								}
-							}
-						}
-					}
-				}
-			}
+		penalizedDollarsPerHour := actualDollarsPerHour * getInterruptionPenalty(instanceSummary, spotConfig)
+		rejection := getRejection(instanceSummary, spotConfig, maxMemoryRequired, maxNodes)
+		candidates = append(candidates, Candidate{
+			InstanceType:            instanceSummary.Name,
+			Mem:                     instanceSummary.Mem,
+			Cpus:                    instanceSummary.Cpus,
+			CoefVar:                 instanceSummary.CoefVar,
+			SpotPrice:               currentSpotPrice,
+			DollarsPerHour:          actualDollarsPerHour,
+			PenalizedDollarsPerHour: penalizedDollarsPerHour,
+			Rejection:               rejection})
+		if len(rejection) == 0 && penalizedDollarsPerHour < minPenalizedDollarsPerHour {
+			minPenalizedDollarsPerHour = penalizedDollarsPerHour
+			minActualDollarsPerHour = actualDollarsPerHour
+			newInstanceType = instanceSummary.Name
+			newSpotPrice = currentSpotPrice
+			anySatisfyConstraints = true
 		}
 	}
-	return newInstanceType, newSpotPrice, minActualDollarsPerHour, anySatisfyConstraints
+	return newInstanceType, newSpotPrice, minActualDollarsPerHour, anySatisfyConstraints, candidates
+}
+
+// GetNewLaunchConfigurationName names a launch configuration after its prefix,
+// instance type and spot price, followed by a short hash of its full settings,
+// e.g. "k8-workers-spot-r4.xlarge-0.07-1a2b3c4d".  The same settings always get
+// the same name.
+func GetNewLaunchConfigurationName(prefix string, input autoscaling.CreateLaunchConfigurationInput) string {
+	input.LaunchConfigurationName = nil
+	return fmt.Sprintf("%v-%v-%v-%v", prefix, aws.StringValue(input.InstanceType),
+		aws.StringValue(input.SpotPrice), hash(input.String())[:8])
 }
 
-func GetNewLaunchConfigurationName(prefix string) string {
-	return prefix + "-" + fmt.Sprintf("%v", hash(time.Now().String()))
+// IsGeneratedLaunchConfigurationName reports whether name was generated by the
+// daemon for prefix, either by GetNewLaunchConfigurationName or by the earlier
+// naming scheme of the prefix and an md5 hash.
+func IsGeneratedLaunchConfigurationName(prefix string, name string) bool {
+	generated := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) +
+		`-([a-z0-9-]+\.[a-z0-9]+-[0-9]+\.[0-9]+-[0-9a-f]{8}|[0-9a-f]{32})$`)
+	return generated.MatchString(name)
 }
 
-func UpdateLaunchConfiguration(autoscalingGroup *autoscaling.Group, launchConfiguration *autoscaling.LaunchConfiguration,
+// applyTimeout bounds an apply; see applyContext.
+const applyTimeout = 2 * time.Minute
+
+// applyLaunchConfigurations enables the calls that create, attach and delete
+// launch configurations.  Until it is set every apply is only logged, as in
+// monitor mode.
+const applyLaunchConfigurations = false
+
+// UpdateLaunchConfiguration points the autoscaling group at a launch
+// configuration for newInstanceType and newSpotPrice, creating it unless it
+// survives from an earlier switch, and deletes the daemon's older launch
+// configurations beyond KeepLaunchConfigurations.  It returns the launch
+// configuration's name and whether the group was repointed at it.
+func UpdateLaunchConfiguration(ctx context.Context, sess *session.Session, autoscalingGroup *autoscaling.Group, launchConfiguration *autoscaling.LaunchConfiguration,
 	allLaunchConfigurations []*autoscaling.LaunchConfiguration, spotConfig awscode.SpotConfig,
-	minActualDollarsPerHour float64, newSpotPrice float64, newInstanceType string, monitor bool) {
+	minActualDollarsPerHour float64, newSpotPrice float64, newInstanceType string,
+	switchingCost SwitchingCost, registry *Registry, monitor bool) (string, bool, error) {
 
-	newSpotPriceString := strconv.FormatFloat(newSpotPrice, 'f', 2, 64)
-	fmt.Printf("\nOriginal Configuration:\n        InstanceType: '%v'\n        SpotPrice: '%v'\n",
-		*launchConfiguration.InstanceType,
-		*launchConfiguration.SpotPrice)
-	fmt.Printf("New Configuration:\n        InstanceType: '%v'\n        SpotPrice: '%v'\n",
-		newInstanceType,
-		newSpotPriceString)
-	fmt.Printf("Total $ per Hour: '%v'\n",
-		minActualDollarsPerHour)
+	monitor = monitor || !applyLaunchConfigurations
 
-	newLaunchConfigurationName := GetNewLaunchConfigurationName(spotConfig.LaunchConfigurationPrefix)
+	newSpotPriceString := strconv.FormatFloat(newSpotPrice, 'f', 2, 64)
+	slog.Info("updating launch configuration",
+		"autoScalingGroup", *autoscalingGroup.AutoScalingGroupName,
+		"originalInstanceType", *launchConfiguration.InstanceType,
+		"originalSpotPrice", describeBid(launchConfiguration),
+		"newInstanceType", newInstanceType,
+		"newSpotPrice", newSpotPriceString,
+		"dollarsPerHour", minActualDollarsPerHour,
+		"monitor", monitor)
+	if newInstanceType != *launchConfiguration.InstanceType {
+		logSwitchingCost(switchingCost, spotConfig)
+	}
 
 	createLaunchConfigurationInput := awscode.DuplicateLaunchConfiguration(launchConfiguration)
 	createLaunchConfigurationInput.SetSpotPrice(newSpotPriceString)
 	createLaunchConfigurationInput.SetInstanceType(newInstanceType)
+	newLaunchConfigurationName := GetNewLaunchConfigurationName(spotConfig.LaunchConfigurationPrefix, createLaunchConfigurationInput)
 	createLaunchConfigurationInput.SetLaunchConfigurationName(newLaunchConfigurationName)
 
+	// A launch configuration with identical settings may survive from an
+	// earlier switch, in which case it is reused rather than created again.
+	exists := false
+	for _, lc := range allLaunchConfigurations {
+		if *lc.LaunchConfigurationName == newLaunchConfigurationName {
+			exists = true
+		}
+	}
+
 	updateAutoScalingGroupInput := autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName:    autoscalingGroup.AutoScalingGroupName,
 		LaunchConfigurationName: &newLaunchConfigurationName}
-	creation_term := "would"
-	if !monitor {
-		creation_term = "will"
+
+	applyCtx, cancelApply := applyContext(ctx)
+	defer cancelApply()
+
+	if exists {
+		slog.Info("reusing launch configuration", "launchConfiguration", newLaunchConfigurationName, "monitor", monitor)
 	} else {
-		fmt.Printf("Monitoring only...\n")
+		slog.Info("creating launch configuration", "launchConfiguration", newLaunchConfigurationName,
+			"imageId", aws.StringValue(createLaunchConfigurationInput.ImageId), "monitor", monitor)
+		slog.Debug("launch configuration input", "launchConfiguration", newLaunchConfigurationName,
+			"input", createLaunchConfigurationInput.String())
 	}
 
-	fmt.Printf("Launchconfiguration '%v' %v be created with input: \n%v\n",
-		*createLaunchConfigurationInput.LaunchConfigurationName, creation_term, createLaunchConfigurationInput)
+	if !monitor && !exists {
+		create_lc_err := awscode.CreateLaunchConfiguration(applyCtx, sess, &createLaunchConfigurationInput)
+		if create_lc_err != nil {
+			return "", false, fmt.Errorf("could not create launchconfiguration '%v': %w", newLaunchConfigurationName, create_lc_err)
+		}
+		record_err := registry.Record(LaunchConfigurationRecord{
+			Name:                 newLaunchConfigurationName,
+			Owner:                getOwner(spotConfig),
+			AutoScalingGroupName: *autoscalingGroup.AutoScalingGroupName,
+			InstanceType:         newInstanceType,
+			SpotPrice:            newSpotPriceString,
+			Created:              time.Now()})
+		if record_err != nil {
+			slog.Warn("could not record launch configuration", "launchConfiguration", newLaunchConfigurationName, "error", record_err)
+		}
+	}
 
+	slog.Info("updating autoscaling group", "autoScalingGroup", *autoscalingGroup.AutoScalingGroupName,
+		"launchConfiguration", newLaunchConfigurationName, "monitor", monitor)
 	if !monitor {
-		// autoscaling_svc := autoscaling.New(sess)
-		// _, create_lc_err := autoscaling_svc.CreateLaunchConfiguration(&createLaunchConfigurationInput)
-		// if create_lc_err != nil {
-		// 	panic(create_lc_err)
-		// }
+		update_asg_err := awscode.UpdateAutoScalingGroup(applyCtx, sess, &updateAutoScalingGroupInput)
+		if update_asg_err != nil && !exists {
+			slog.Warn("rolling back launch configuration", "launchConfiguration", newLaunchConfigurationName)
+			if delete_lc_err := awscode.DeleteLaunchConfiguration(applyCtx, sess, newLaunchConfigurationName); delete_lc_err != nil {
+				slog.Error("could not roll back launch configuration", "launchConfiguration", newLaunchConfigurationName, "error", delete_lc_err)
+			} else if forget_err := registry.Forget(newLaunchConfigurationName); forget_err != nil {
+				slog.Warn("could not forget launch configuration", "launchConfiguration", newLaunchConfigurationName, "error", forget_err)
+			}
+		}
+		if update_asg_err != nil {
+			return "", false, fmt.Errorf("could not update autoscalinggroup '%v': %w",
+				*autoscalingGroup.AutoScalingGroupName, update_asg_err)
+		}
 	}
 
-	fmt.Printf("AutoScalingGroup '%v' %v be updated with input: \n%v\n",
-		*autoscalingGroup.AutoScalingGroupName, creation_term, updateAutoScalingGroupInput)
-	if !monitor {
-		// _, update_asg_err := autoscaling_svc.UpdateAutoScalingGroup(&updateAutoScalingGroupInput)
-		// if update_asg_err != nil {
-		// 	panic(update_asg_err)
-		// }
+	// Never delete a launch configuration some autoscaling group still uses,
+	// whether it is ours or another group sharing the prefix.
+	attached, attached_err := awscode.GetAttachedLaunchConfigurations(applyCtx, sess)
+	if attached_err != nil {
+		slog.Warn("not deleting old launch configurations", "error", attached_err)
+		return newLaunchConfigurationName, !monitor, nil
+	}
+	if monitor && attached[*launchConfiguration.LaunchConfigurationName] == *autoscalingGroup.AutoScalingGroupName {
+		// Nothing was attached, but show what would happen once it had been.
+		delete(attached, *launchConfiguration.LaunchConfigurationName)
 	}
 
+	// Only launch configurations this daemon or SpotPolicy created are ever
+	// deleted.  If the registry cannot be read only generated names count.
+	records, records_err := registry.Records()
+	if records_err != nil {
+		slog.Warn("deleting only launch configurations with generated names", "error", records_err)
+		records = map[string]LaunchConfigurationRecord{}
+	}
+	// A rollback must always find the launch configuration it returns to,
+	// whatever KeepLaunchConfigurations says: the one a turnover moves the
+	// group off, and those of the switches still in the registry.
+	targets := rollbackTargets(records)
+	if newInstanceType != *launchConfiguration.InstanceType || aws.StringValue(launchConfiguration.SpotPrice) == "" {
+		targets[*launchConfiguration.LaunchConfigurationName] = true
+	}
+	previousLaunchConfigurations := []*autoscaling.LaunchConfiguration{}
 	for _, lc := range allLaunchConfigurations {
-		if newLaunchConfigurationName != *lc.LaunchConfigurationName {
-			deleteLaunchConfigurationInput := autoscaling.DeleteLaunchConfigurationInput{
-				LaunchConfigurationName: lc.LaunchConfigurationName}
-			fmt.Printf("Launchconfiguration '%v' %v be deleted with input: \n%v\n",
-				*lc.LaunchConfigurationName, creation_term, deleteLaunchConfigurationInput)
-			if !monitor {
-				// _, delete_lc_err := autoscaling_svc.DeleteLaunchConfiguration(&deleteLaunchConfigurationInput)
-				// if delete_lc_err != nil {
-				// 	panic(delete_lc_err)
-				// }
+		if newLaunchConfigurationName == *lc.LaunchConfigurationName {
+			continue
+		}
+		if targets[*lc.LaunchConfigurationName] {
+			slog.Info("keeping launch configuration a switch may roll back to",
+				"launchConfiguration", *lc.LaunchConfigurationName)
+			continue
+		}
+		if groupName, found := attached[*lc.LaunchConfigurationName]; found {
+			slog.Info("keeping attached launch configuration", "launchConfiguration", *lc.LaunchConfigurationName,
+				"attachedTo", groupName)
+			continue
+		}
+		if !isOwnedBy(*lc.LaunchConfigurationName, records, spotConfig) {
+			slog.Debug("keeping launch configuration the daemon did not create",
+				"launchConfiguration", *lc.LaunchConfigurationName)
+			continue
+		}
+		previousLaunchConfigurations = append(previousLaunchConfigurations, lc)
+	}
+	sortByRecency(previousLaunchConfigurations, *launchConfiguration.LaunchConfigurationName)
+
+	for i, lc := range previousLaunchConfigurations {
+		if i < spotConfig.KeepLaunchConfigurations {
+			slog.Info("keeping launch configuration for rollback", "launchConfiguration", *lc.LaunchConfigurationName)
+			continue
+		}
+		slog.Info("deleting launch configuration", "launchConfiguration", *lc.LaunchConfigurationName, "monitor", monitor)
+		if !monitor {
+			delete_lc_err := awscode.DeleteLaunchConfiguration(applyCtx, sess, *lc.LaunchConfigurationName)
+			if delete_lc_err != nil {
+				slog.Warn("could not delete launch configuration", "launchConfiguration", *lc.LaunchConfigurationName, "error", delete_lc_err)
+			} else if forget_err := registry.Forget(*lc.LaunchConfigurationName); forget_err != nil {
+				slog.Warn("could not forget launch configuration", "launchConfiguration", *lc.LaunchConfigurationName, "error", forget_err)
 			}
 		}
 	}
+	return newLaunchConfigurationName, !monitor, nil
 }
 
-func CheckAndUpdate(sess *session.Session, spotConfig awscode.SpotConfig,
-	priceList []pricing.FullSummary, podSummary map[string]float64,
-	clientset *kubernetes.Clientset, monitor bool) bool {
+// GroupState is what a decision needs to know about an autoscaling group: the
+// launch configuration it runs, the price it pays per node and how many nodes
+// a turnover would replace.
+type GroupState struct {
+	AutoScalingGroupName    string
+	LaunchConfigurationName string
+	InstanceType            string
+	// Bid is the launch configuration's spot price as written, empty if it
+	// is on-demand, and Price the bid or on-demand price per node.
+	Bid      string
+	Price    float64
+	OnDemand bool
+	// Summary sizes InstanceType, with its price history if it has any.
+	Summary pricing.FullSummary
+	Nodes   int
+
+	autoScalingGroup     *autoscaling.Group
+	launchConfiguration  *autoscaling.LaunchConfiguration
+	launchConfigurations []*autoscaling.LaunchConfiguration
+}
+
+// GetGroupState reads the state of spotConfig.AutoScalingGroupName from AWS.
+func GetGroupState(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	priceList []pricing.FullSummary) (GroupState, error) {
+
 	autoScalingGroupName := spotConfig.AutoScalingGroupName
-	autoScalingGroup := awscode.GetAutoscaler(sess, autoScalingGroupName)
-	allLaunchConfigurations := awscode.GetLaunchConfigurations(sess, spotConfig.LaunchConfigurationPrefix)
+	autoScalingGroup, err := awscode.GetAutoscaler(ctx, sess, autoScalingGroupName)
+	if err != nil {
+		return GroupState{}, err
+	}
+	allLaunchConfigurations, err := awscode.GetLaunchConfigurations(ctx, sess, spotConfig.LaunchConfigurationPrefix)
+	if err != nil {
+		return GroupState{}, err
+	}
 	var launchConfiguration *autoscaling.LaunchConfiguration
 	for _, lc := range allLaunchConfigurations {
 		if *lc.LaunchConfigurationName == *autoScalingGroup.LaunchConfigurationName {
@@ -186,70 +431,411 @@ func CheckAndUpdate(sess *session.Session, spotConfig awscode.SpotConfig,
 	}
 
 	if launchConfiguration == nil {
-		panic("Could not identify launchConfiguration currently in use by autoscaler...")
+		return GroupState{}, fmt.Errorf("could not identify launchconfiguration '%v' in use by autoscalinggroup '%v'",
+			aws.StringValue(autoScalingGroup.LaunchConfigurationName), autoScalingGroupName)
 	}
 
+	originalSpotPrice, onDemand, err := getOriginalPrice(ctx, sess, spotConfig, launchConfiguration)
+	if err != nil {
+		return GroupState{}, err
+	}
+	originalSummary, err := getOriginalSummary(ctx, sess, priceList, *launchConfiguration.InstanceType)
+	if err != nil {
+		return GroupState{}, err
+	}
+	return GroupState{
+		AutoScalingGroupName:    autoScalingGroupName,
+		LaunchConfigurationName: *launchConfiguration.LaunchConfigurationName,
+		InstanceType:            *launchConfiguration.InstanceType,
+		Bid:                     aws.StringValue(launchConfiguration.SpotPrice),
+		Price:                   originalSpotPrice,
+		OnDemand:                onDemand,
+		Summary:                 originalSummary,
+		Nodes:                   len(autoScalingGroup.Instances),
+		autoScalingGroup:        autoScalingGroup,
+		launchConfiguration:     launchConfiguration,
+		launchConfigurations:    allLaunchConfigurations}, nil
+}
+
+// Decide chooses, at now, the instance type and bid for a group in state given
+// the priced instance types and the cluster's demand.  It reads nothing and
+// changes nothing, so a decision can be made again offline from the same
+// inputs; an outcome that updates the group is what CheckAndUpdate applies.
+func Decide(spotConfig awscode.SpotConfig, state GroupState, priceList []pricing.FullSummary,
+	podSummary map[string]float64, now time.Time) Decision {
+
 	maxMemoryRequired := (1 + spotConfig.MemoryBufferPercentage*0.01) * podSummary["maxMemoryRequestedGB"]
 
-	originalInstanceType := *launchConfiguration.InstanceType
-	originalSpotPrice, price_err := strconv.ParseFloat(*launchConfiguration.SpotPrice, 64)
-	if price_err != nil {
-		panic(price_err)
-	}
-	scaleMemory, originalDollarsPerHour := checkOriginalMemoryAndPrice(priceList, spotConfig,
-		podSummary, originalInstanceType, originalSpotPrice, maxMemoryRequired)
+	originalInstanceType := state.InstanceType
+	nodesNeeded := getNodesNeeded(state.Summary, podSummary)
+	originalDollarsPerHour := getDollarsPerHour(state.Summary, nodesNeeded, spotConfig.MaxAutoscalingNodes, state.Price)
+	scaleMemory := state.Summary.Mem < maxMemoryRequired
+	originalInterrupted := isRecentlyInterrupted(priceList, originalInstanceType)
 
-	newInstanceType, newSpotPrice, minActualDollarsPerHour, anySatisfyConstraints := getBestFilteredType(
-		originalInstanceType, originalSpotPrice, spotConfig, priceList, maxMemoryRequired,
+	newInstanceType, newSpotPrice, minActualDollarsPerHour, anySatisfyConstraints, candidates := getBestFilteredType(
+		originalInstanceType, state.Price, spotConfig, priceList, maxMemoryRequired,
 		spotConfig.MaxAutoscalingNodes, podSummary)
 
 	minDollarsPerHourDifference := (0.01 * spotConfig.MinPriceDifferencePercentage) * originalDollarsPerHour
 	passesDollarDifference := math.Abs(minActualDollarsPerHour-originalDollarsPerHour) > minDollarsPerHourDifference
 
-	spotPriceChanged := fmt.Sprintf("%v", newSpotPrice) != *launchConfiguration.SpotPrice
-	instanceChanged := *launchConfiguration.InstanceType != newInstanceType
+	spotPriceChanged := state.OnDemand || fmt.Sprintf("%v", newSpotPrice) != state.Bid
+	instanceChanged := originalInstanceType != newInstanceType
 	configChanged := spotPriceChanged || instanceChanged
+	// Converting to spot only pays off if spot is cheaper than the on-demand
+	// nodes it replaces, even on the same instance type.
+	turnover := instanceChanged || state.OnDemand
+
+	// A bid change on the same instance type leaves running nodes alone, so only
+	// a turnover of the nodes has to pay for itself over the amortisation horizon.
+	switchingCost := getSwitchingCost(state.Nodes, spotConfig, originalDollarsPerHour, minActualDollarsPerHour)
+	coversSwitchingCost := !turnover || switchingCost.Covered()
+
+	decision := Decision{
+		Time:                            now,
+		AutoScalingGroupName:            state.AutoScalingGroupName,
+		Outcome:                         DecisionNoChange,
+		OriginalLaunchConfigurationName: state.LaunchConfigurationName,
+		OriginalInstanceType:            originalInstanceType,
+		OriginalSpotPrice:               state.Price,
+		OriginalOnDemand:                state.OnDemand,
+		OriginalDollarsPerHour:          originalDollarsPerHour,
+		NewInstanceType:                 newInstanceType,
+		NewSpotPrice:                    newSpotPrice,
+		NewDollarsPerHour:               minActualDollarsPerHour,
+		SwitchingCost:                   switchingCost,
+		Demand:                          podSummary,
+		Candidates:                      candidates}
+
+	mustSwitch := scaleMemory || originalInterrupted
+	if !anySatisfyConstraints {
+		decision.Reason = "no instance type satisfies the configured constraints"
+		if _, rejected := splitCandidates(candidates); len(rejected) > 0 {
+			decision.Reason += " (" + summarizeRejections(rejected) + ")"
+		}
+		return decision
+	}
+	if state.OnDemand && minActualDollarsPerHour >= originalDollarsPerHour {
+		decision.Reason = "no spot instance type is cheaper than the current on-demand instances"
+		return decision
+	}
+	if !mustSwitch && !(passesDollarDifference && configChanged) {
+		decision.Reason = "price difference is below minPriceDifferencePercentage"
+		return decision
+	}
+	if !mustSwitch && !coversSwitchingCost {
+		decision.Outcome = DecisionBlocked
+		decision.Reason = fmt.Sprintf("savings of %.2f over %v hours do not cover the switching cost of %.2f",
+			switchingCost.HorizonSavings, spotConfig.AmortizationHours, switchingCost.TotalCost)
+		return decision
+	}
+
+	decision.Outcome = DecisionBidChange
+	decision.Reason = "bid price moved by more than minPriceDifferencePercentage"
+	if instanceChanged {
+		decision.Outcome = DecisionSwitch
+		decision.Reason = fmt.Sprintf("'%v' is cheaper than '%v' by more than minPriceDifferencePercentage",
+			newInstanceType, originalInstanceType)
+	}
+	if state.OnDemand {
+		decision.Outcome = DecisionConvert
+		decision.Reason = fmt.Sprintf("spot '%v' is cheaper than on-demand '%v'", newInstanceType, originalInstanceType)
+	}
+	if originalInterrupted {
+		decision.Reason = fmt.Sprintf("'%v' was recently reclaimed", originalInstanceType)
+	}
+	if scaleMemory {
+		decision.Reason = fmt.Sprintf("'%v' has too little memory for the largest pod", originalInstanceType)
+	}
+	return decision
+}
+
+// evaluate decides what to do with spotConfig's autoscaling group and, for a
+// turnover, checks that the PodDisruptionBudgets of its nodes allow it.  It
+// changes nothing.
+func evaluate(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	priceList []pricing.FullSummary, podSummary map[string]float64,
+	clientset *kubernetes.Clientset, snapshot *Snapshot, monitor bool) (GroupState, Decision, error) {
+
+	state, err := GetGroupState(ctx, sess, spotConfig, priceList)
+	if err != nil {
+		return state, Decision{}, err
+	}
+	snapshot.setGroup(state)
+	if state.OnDemand {
+		slog.Info("current launch configuration is on-demand, evaluating conversion to spot",
+			"autoScalingGroup", state.AutoScalingGroupName, "onDemandPrice", state.Price)
+	}
+	if isRecentlyInterrupted(priceList, state.InstanceType) {
+		slog.Info("current instance type was recently reclaimed", "instanceType", state.InstanceType)
+	}
+
+	decision := Decide(spotConfig, state, priceList, podSummary, time.Now())
+	decision.Monitor = monitor
+	if decision.Outcome == DecisionBlocked {
+		logSwitchingCost(decision.SwitchingCost, spotConfig)
+	}
+	if turnsOverNodes(decision) {
+		report, err := checkDisruptionBudgets(clientset, getInstanceIDs(state.autoScalingGroup))
+		if err != nil {
+			return state, decision, err
+		}
+		decision = blockOnDisruptionBudgets(decision, report)
+	}
+	return state, decision, nil
+}
 
-	if anySatisfyConstraints && (scaleMemory || (passesDollarDifference && configChanged)) {
-		UpdateLaunchConfiguration(autoScalingGroup, launchConfiguration, allLaunchConfigurations, spotConfig,
-			minActualDollarsPerHour, newSpotPrice, newInstanceType, monitor)
+func CheckAndUpdate(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	priceList []pricing.FullSummary, podSummary map[string]float64,
+	clientset *kubernetes.Clientset, snapshot *Snapshot, monitor bool) (Decision, error) {
+
+	state, decision, err := evaluate(ctx, sess, spotConfig, priceList, podSummary, clientset, snapshot, monitor)
+	if err != nil || !decision.Updated() {
+		return decision, err
+	}
+
+	newLaunchConfigurationName, repointed, err := UpdateLaunchConfiguration(ctx, sess, state.autoScalingGroup, state.launchConfiguration,
+		state.launchConfigurations, spotConfig, decision.NewDollarsPerHour, decision.NewSpotPrice,
+		decision.NewInstanceType, decision.SwitchingCost, NewRegistry(clientset, spotConfig), monitor)
+	if err != nil {
+		decision.Outcome = DecisionApplyFailed
+		decision.Reason = err.Error()
+		return decision, err
+	}
+	decision.NewLaunchConfigurationName = newLaunchConfigurationName
+	// Nodes are only rotated once the group is known to launch replacements
+	// from the new launch configuration; in monitor mode the rotation is
+	// only logged.
+	if decision.Outcome != DecisionBidChange && spotConfig.RotateNodes && (monitor || repointed) {
+		if err := RotateNodes(ctx, sess, clientset, spotConfig, newLaunchConfigurationName, monitor); err != nil {
+			slog.Warn("node rotation stopped", "autoScalingGroup", state.AutoScalingGroupName, "error", err)
+		}
+	}
+	return decision, nil
+}
+
+// sleepContext waits for d, returning false early if ctx is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
 		return true
 	}
-	return false
-}
-
-func RunDaemon(monitor bool, spotConfig awscode.SpotConfig) {
-	for {
-		updated := false
-		fmt.Printf("Checking prices at %v\n", time.Now())
-		clientset := k8code.GetClientSet()
-		sess := session.Must(session.NewSession(&aws.Config{
-			Region: aws.String(spotConfig.RegionName),
-		}))
-
-		podSummary := k8code.SummarizePods(clientset)
-		fmt.Printf("Kubernetes Usage:\n")
-		fmt.Printf(
-			"    Total Memory Requested: %12.3f GB || Total Memory in Use: %12.3f GB || Max Memory: %8.3f GB || Num Pods: %v\n",
-			podSummary["totalMemoryRequestedGB"],
-			podSummary["totalMemoryUsedGB"],
-			podSummary["maxMemoryUsedGB"],
-			int(podSummary["totalRunningPods"]))
-		fmt.Printf("")
-
-		if int(podSummary["totalRunningPods"]) < spotConfig.MaxPodKills {
-			priceList := pricing.DescribePricing(sess, spotConfig)
-			updated = CheckAndUpdate(sess, spotConfig, priceList, podSummary, clientset, monitor)
-		} else {
-			fmt.Printf("Too many active pods (%v) to turn over cluster...\n", int(podSummary["totalRunningPods"]))
+}
+
+// retryIntervalSeconds is how long the daemon waits before retrying an
+// iteration that failed with a transient error.
+const retryIntervalSeconds = 30
+
+// isTransient reports whether an iteration failed for a reason that is likely
+// to go away on its own, such as AWS throttling or a Kubernetes API timeout.
+func isTransient(err error) bool {
+	return awscode.IsTransient(err) || k8code.IsTransient(err)
+}
+
+// runIteration performs a single evaluation of every autoscaling group the
+// daemon manages and reports whether any of them was updated.  A standby
+// replica neither consumes the interruption queue nor reports its decisions
+// through Events, notifications or SpotPolicy status, which are the leader's.
+func runIteration(ctx context.Context, spotConfig awscode.SpotConfig, interruptionTracker *pricing.InterruptionTracker,
+	lastPolicyTurnover map[string]time.Time, switchWatcher *SwitchWatcher, events *DecisionEvents,
+	notifier *notify.Notifier, priceHistory *history.Store, recorder *Recorder, monitor bool, standby bool) (bool, error) {
+
+	if standby {
+		events, notifier = nil, nil
+	}
+
+	clientset, err := k8code.GetClientSet()
+	if err != nil {
+		daemonStatus.recordCheck(checkKubernetes, err)
+		return false, err
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(spotConfig.RegionName),
+	})
+	if err != nil {
+		daemonStatus.recordCheck(checkAWS, err)
+		return false, err
+	}
+	metrics.InstrumentSession(sess)
+
+	podSummary, err := k8code.SummarizePods(clientset)
+	daemonStatus.recordCheck(checkKubernetes, err)
+	if err != nil {
+		return false, err
+	}
+	metrics.RecordPods(podSummary)
+	slog.Debug("kubernetes usage",
+		"totalMemoryRequestedGB", podSummary["totalMemoryRequestedGB"],
+		"totalMemoryUsedGB", podSummary["totalMemoryUsedGB"],
+		"maxMemoryRequestedGB", podSummary["maxMemoryRequestedGB"],
+		"maxMemoryUsedGB", podSummary["maxMemoryUsedGB"],
+		"totalRunningPods", int(podSummary["totalRunningPods"]))
+
+	CollectInterruptions(ctx, sess, clientset, spotConfig, interruptionTracker, !standby)
+	registry := NewRegistry(clientset, spotConfig)
+	rollbacks, err := switchWatcher.Check(ctx, sess, clientset, spotConfig, registry, monitor)
+	if err != nil {
+		// The groups that could be checked were; the rest are checked again
+		// next time, and evaluation goes on regardless.
+		slog.Warn("could not check every pending switch", "error", err)
+		daemonStatus.recordError(err)
+	}
+	rolledBack := false
+	for _, rollback := range rollbacks {
+		var rollbackErr error
+		if rollback.Outcome == DecisionApplyFailed {
+			rollbackErr = errors.New(rollback.Reason)
+		}
+		recordDecision(rollback)
+		events.Record(rollback, rollbackErr)
+		notifyDecision(notifier, getOwner(spotConfig), rollback, rollbackErr)
+		rolledBack = rolledBack || rollback.Updated()
+	}
+	if len(rollbacks) > 0 {
+		// A rollback that failed is tried again before anything else changes.
+		return rolledBack, nil
+	}
+	if spotConfig.WatchSpotPolicies {
+		return RunSpotPolicies(ctx, sess, clientset, spotConfig, interruptionTracker, podSummary,
+			lastPolicyTurnover, switchWatcher, events, notifier, priceHistory, recorder, monitor, standby)
+	}
+	inputs, err := pricing.GetInputs(ctx, sess, spotConfig, interruptionTracker, priceHistory)
+	daemonStatus.recordCheck(checkAWS, err)
+	if err != nil {
+		return false, err
+	}
+	allPrices, err := pricing.DescribePricing(spotConfig, inputs)
+	if err != nil {
+		daemonStatus.recordCheck(checkPricing, err)
+		return false, err
+	}
+	metrics.RecordPricing(allPrices)
+	daemonStatus.recordPricing(allPrices)
+	priceList := switchWatcher.ExcludeFailedTypes(spotConfig.AutoScalingGroupName, allPrices)
+	snapshot := recorder.Start(spotConfig, inputs, allPrices, priceList, podSummary, monitor)
+	decision, err := CheckAndUpdate(ctx, sess, spotConfig, priceList, podSummary, clientset, snapshot, monitor)
+	recorder.Save(snapshot, decision, err)
+	events.Record(decision, err)
+	notifyDecision(notifier, getOwner(spotConfig), decision, err)
+	// A decision that failed to apply is still recorded; one that failed
+	// before deciding anything has no outcome to record.
+	if len(decision.Outcome) > 0 {
+		recordDecision(decision)
+	}
+	if err != nil {
+		return false, err
+	}
+	switchWatcher.Watch(decision, registry, monitor)
+	return decision.Updated(), nil
+}
+
+// OpenPriceHistory opens spotConfig.PriceHistoryFile, if it is set.
+func OpenPriceHistory(spotConfig awscode.SpotConfig) (*history.Store, error) {
+	if len(spotConfig.PriceHistoryFile) == 0 {
+		return nil, nil
+	}
+	return history.Open(spotConfig.PriceHistoryFile,
+		time.Duration(spotConfig.PriceHistoryRetentionHours*float64(time.Hour)))
+}
+
+// RunDaemon loops until ctx is cancelled.  Cancellation interrupts sleeps and
+// AWS reads, but an apply that has already started runs to completion.  With
+// leader election, losing leadership cancels the iteration in progress,
+// including its apply, and leadership is only released once the loop has
+// finished.  A failed iteration is reported and retried after
+// retryIntervalSeconds if the error was transient, or after the usual update
+// interval otherwise.
+func RunDaemon(ctx context.Context, monitor bool, spotConfig awscode.SpotConfig, configWatcher *ConfigWatcher) error {
+	interruptionTracker := pricing.NewInterruptionTracker(
+		time.Duration(spotConfig.InterruptionWindowHours * float64(time.Hour)))
+	lastPolicyTurnover := map[string]time.Time{}
+	switchWatcher := NewSwitchWatcher()
+	daemonStatus.startIteration(spotConfig, monitor)
+	metrics.Serve(ctx, spotConfig.ListenAddress, daemonStatus.Handlers())
+	leadership, err := StartLeaderElection(spotConfig)
+	if err != nil {
+		return err
+	}
+	defer leadership.Stop()
+	events, err := NewDecisionEvents(spotConfig)
+	if err != nil {
+		return err
+	}
+	defer events.Shutdown()
+	notifier, err := NewNotifier(spotConfig)
+	if err != nil {
+		return err
+	}
+	defer notifier.Close()
+	failures := &failureNotifier{notifier: notifier}
+	priceHistory, err := OpenPriceHistory(spotConfig)
+	if err != nil {
+		return err
+	}
+	defer priceHistory.Close()
+	recorder, err := NewRecorder(spotConfig)
+	if err != nil {
+		return err
+	}
+	daemonMonitor := monitor
+	for ctx.Err() == nil {
+		iterationCtx, endIteration, leading := leadership.Scope(ctx)
+		standby := !leading
+		monitor := daemonMonitor || standby
+		if standby {
+			slog.Info("replica is on standby, monitoring only", "identity", leadership.Identity)
+		}
+		if configWatcher != nil {
+			if reloaded, changed := configWatcher.Check(spotConfig); changed {
+				spotConfig = reloaded
+				interruptionTracker.Window = time.Duration(spotConfig.InterruptionWindowHours * float64(time.Hour))
+				if priceHistory != nil {
+					priceHistory.Retention = time.Duration(spotConfig.PriceHistoryRetentionHours * float64(time.Hour))
+				}
+				if recorder != nil {
+					recorder.Keep = spotConfig.RecordKeep
+				}
+			}
+		}
+
+		daemonStatus.startIteration(spotConfig, monitor)
+		updated, err := runIteration(iterationCtx, spotConfig, interruptionTracker, lastPolicyTurnover,
+			switchWatcher, events, notifier, priceHistory, recorder, monitor, standby)
+		lostLeadership := iterationCtx.Err() != nil
+		endIteration()
+		if ctx.Err() != nil {
+			break
+		}
+		if lostLeadership {
+			slog.Warn("replica lost leadership during the iteration, abandoning it", "identity", leadership.Identity)
+			continue
+		}
+		if !standby {
+			failures.record(err, monitor)
+		}
+		if err != nil {
+			daemonStatus.recordError(err)
+			if isTransient(err) {
+				slog.Warn("iteration failed with a transient error", "error", err, "retryInSeconds", retryIntervalSeconds)
+				daemonStatus.sleep(time.Second * retryIntervalSeconds)
+				sleepContext(ctx, time.Second*retryIntervalSeconds)
+				continue
+			}
+			slog.Error("iteration failed", "error", err)
 		}
 
 		if updated {
-			fmt.Printf("AutoScalingGroup was updated.  Sleeping for '%v' seconds.\n", int(spotConfig.MinimumTurnoverSeconds))
-			time.Sleep(time.Second * time.Duration(spotConfig.MinimumTurnoverSeconds))
+			slog.Info("autoscaling group was updated", "sleepSeconds", int(spotConfig.MinimumTurnoverSeconds))
+			daemonStatus.sleep(time.Second * time.Duration(spotConfig.MinimumTurnoverSeconds))
+			sleepContext(ctx, time.Second*time.Duration(spotConfig.MinimumTurnoverSeconds))
 		} else {
-			fmt.Printf("AutoScalingGroup was not updated.  Sleeping for '%v' seconds.\n", int(spotConfig.UpdateIntervalSeconds))
-			time.Sleep(time.Second * time.Duration(spotConfig.UpdateIntervalSeconds))
+			slog.Info("autoscaling group was not updated", "sleepSeconds", int(spotConfig.UpdateIntervalSeconds))
+			daemonStatus.sleep(time.Second * time.Duration(spotConfig.UpdateIntervalSeconds))
+			sleepContext(ctx, time.Second*time.Duration(spotConfig.UpdateIntervalSeconds))
 		}
 	}
+	slog.Info("shutting down")
+	return nil
 }
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func summary(name string, mem float64, cpus float64, price float64) pricing.FullSummary {
+	return pricing.FullSummary{Name: name, Price: price, Cpus: cpus, Mem: mem,
+		PricePerCPU: price / cpus, PricePerGB: price / mem}
+}
+
+func TestDecide(t *testing.T) {
+	spotConfig := awscode.DefaultSpotConfig()
+	r4 := summary("r4.xlarge", 30.5, 4, 0.10)
+	r5 := summary("r5.xlarge", 32, 4, 0.06)
+	r4Large := summary("r4.2xlarge", 61, 8, 0.20)
+	r4Bid := getAdjustedSpotPrice(r4, spotConfig)
+	onR4 := GroupState{AutoScalingGroupName: "nodes", InstanceType: "r4.xlarge",
+		Bid: fmt.Sprintf("%v", r4Bid), Price: r4Bid, Summary: r4, Nodes: 3}
+	onDemandR4 := GroupState{AutoScalingGroupName: "nodes", InstanceType: "r4.xlarge",
+		Price: 0.266, OnDemand: true, Summary: r4, Nodes: 3}
+	interrupted := r4
+	interrupted.RecentlyInterrupted = true
+	unknown := r5
+	unknown.InterruptionBucket = pricing.UnknownInterruptionBucket
+
+	cases := []struct {
+		name         string
+		configure    func(*awscode.SpotConfig)
+		state        GroupState
+		priceList    []pricing.FullSummary
+		maxMemoryGB  float64
+		wantOutcome  string
+		wantType     string
+		wantInReason string
+	}{
+		{"cheaper type", nil, onR4, []pricing.FullSummary{r4, r5}, 10,
+			DecisionSwitch, "r5.xlarge", "'r5.xlarge' is cheaper than 'r4.xlarge'"},
+		{"same type and bid", nil, onR4, []pricing.FullSummary{r4}, 10,
+			DecisionNoChange, "r4.xlarge", "below minPriceDifferencePercentage"},
+		{"bid moved", nil, GroupState{AutoScalingGroupName: "nodes", InstanceType: "r4.xlarge",
+			Bid: "0.2", Price: 0.2, Summary: r4, Nodes: 3}, []pricing.FullSummary{r4}, 10,
+			DecisionBidChange, "r4.xlarge", "bid price moved"},
+		{"switching cost not covered", func(c *awscode.SpotConfig) { c.SwitchingCostPerNode = 1 },
+			onR4, []pricing.FullSummary{r4, r5}, 10,
+			DecisionBlocked, "r5.xlarge", "do not cover the switching cost"},
+		{"on-demand to cheaper spot", nil, onDemandR4, []pricing.FullSummary{r4, r5}, 10,
+			DecisionConvert, "r5.xlarge", "spot 'r5.xlarge' is cheaper than on-demand 'r4.xlarge'"},
+		{"on-demand cheaper than spot", nil, GroupState{AutoScalingGroupName: "nodes", InstanceType: "r4.xlarge",
+			Price: 0.05, OnDemand: true, Summary: r4, Nodes: 3}, []pricing.FullSummary{r4, r5}, 10,
+			DecisionNoChange, "r5.xlarge", "no spot instance type is cheaper"},
+		{"no eligible type", func(c *awscode.SpotConfig) { c.MinGB = 64 },
+			onR4, []pricing.FullSummary{r4, r5}, 10,
+			DecisionNoChange, "r4.xlarge", "no instance type satisfies the configured constraints (2 below minGB)"},
+		{"largest pod does not fit", func(c *awscode.SpotConfig) { c.SwitchingCostPerNode = 1 },
+			onR4, []pricing.FullSummary{r4, r5, r4Large}, 40,
+			DecisionSwitch, "r4.2xlarge", "'r4.xlarge' has too little memory for the largest pod"},
+		{"recently reclaimed", nil, onR4, []pricing.FullSummary{interrupted, r4Large}, 10,
+			DecisionSwitch, "r4.2xlarge", "'r4.xlarge' was recently reclaimed"},
+		{"unknown frequency allowed", func(c *awscode.SpotConfig) { c.InterruptionFrequencyFile = "advisor.json" },
+			onR4, []pricing.FullSummary{r4, unknown}, 10,
+			DecisionSwitch, "r5.xlarge", "cheaper"},
+		{"unknown frequency rejected", func(c *awscode.SpotConfig) {
+			c.InterruptionFrequencyFile = "advisor.json"
+			c.RejectUnknownFrequency = true
+		}, onR4, []pricing.FullSummary{r4, unknown}, 10,
+			DecisionNoChange, "r4.xlarge", "below minPriceDifferencePercentage"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := spotConfig
+			if c.configure != nil {
+				c.configure(&config)
+			}
+			demand := map[string]float64{"totalMemoryRequestedGB": 90, "maxMemoryRequestedGB": c.maxMemoryGB}
+			now := time.Unix(1700000000, 0)
+			decision := Decide(config, c.state, c.priceList, demand, now)
+			if decision.Outcome != c.wantOutcome {
+				t.Errorf("outcome = %q, want %q (%v)", decision.Outcome, c.wantOutcome, decision.Reason)
+			}
+			if decision.NewInstanceType != c.wantType {
+				t.Errorf("new instance type = %q, want %q", decision.NewInstanceType, c.wantType)
+			}
+			if !strings.Contains(decision.Reason, c.wantInReason) {
+				t.Errorf("reason %q does not contain %q", decision.Reason, c.wantInReason)
+			}
+			if !decision.Time.Equal(now) || len(decision.Candidates) != len(c.priceList) {
+				t.Errorf("decision at %v with %v candidates, want %v with %v",
+					decision.Time, len(decision.Candidates), now, len(c.priceList))
+			}
+		})
+	}
+}
+
+func TestGetRejection(t *testing.T) {
+	known := summary("r4.xlarge", 30.5, 4, 0.10)
+	known.InterruptionBucket = 1
+	unknown := known
+	unknown.InterruptionBucket = pricing.UnknownInterruptionBucket
+	frequent := known
+	frequent.InterruptionBucket = 4
+	cases := []struct {
+		name      string
+		configure func(*awscode.SpotConfig)
+		summary   pricing.FullSummary
+		want      string
+	}{
+		{"known frequency", nil, known, ""},
+		{"unknown frequency allowed", func(c *awscode.SpotConfig) { c.InterruptionFrequencyFile = "advisor.json" },
+			unknown, ""},
+		{"unknown frequency rejected", func(c *awscode.SpotConfig) {
+			c.InterruptionFrequencyFile = "advisor.json"
+			c.RejectUnknownFrequency = true
+		}, unknown, "unknown interruption frequency"},
+		{"known frequency with rejection", func(c *awscode.SpotConfig) {
+			c.InterruptionFrequencyFile = "advisor.json"
+			c.RejectUnknownFrequency = true
+		}, known, ""},
+		{"rejection without a frequency file", func(c *awscode.SpotConfig) { c.RejectUnknownFrequency = true },
+			unknown, ""},
+		{"frequent", func(c *awscode.SpotConfig) { c.MaxInterruptionBucket = 3 }, frequent,
+			"above maxInterruptionBucket"},
+		{"largest pod", func(c *awscode.SpotConfig) { c.MinGB = 8 }, summary("r4.large", 15.25, 2, 0.05),
+			"too little memory for the largest pod"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spotConfig := awscode.DefaultSpotConfig()
+			if c.configure != nil {
+				c.configure(&spotConfig)
+			}
+			if got := getRejection(c.summary, spotConfig, 20, 3); got != c.want {
+				t.Errorf("getRejection() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGetSwitchingCost(t *testing.T) {
+	cases := []struct {
+		name          string
+		costPerNode   float64
+		original      float64
+		new           float64
+		wantCost      float64
+		wantHorizon   float64
+		wantBreakEven float64
+		wantCovered   bool
+	}{
+		{"savings cover the cost", 0.1, 1.0, 0.9, 0.3, 0.6, 3, true},
+		{"savings too small", 0.1, 1.0, 0.98, 0.3, 0.12, 15, false},
+		{"no savings never break even", 0.1, 1.0, 1.2, 0.3, -1.2, math.Inf(1), false},
+		{"free switch", 0, 1.0, 1.2, 0, -1.2, math.Inf(1), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spotConfig := awscode.DefaultSpotConfig()
+			spotConfig.SwitchingCostPerNode = c.costPerNode
+			spotConfig.AmortizationHours = 6
+			switchingCost := getSwitchingCost(3, spotConfig, c.original, c.new)
+			if switchingCost.NodesAffected != 3 || !closeTo(switchingCost.TotalCost, c.wantCost) ||
+				!closeTo(switchingCost.HorizonSavings, c.wantHorizon) {
+				t.Errorf("switching cost = %+v, want cost %v and horizon savings %v",
+					switchingCost, c.wantCost, c.wantHorizon)
+			}
+			if math.IsInf(c.wantBreakEven, 1) != math.IsInf(switchingCost.BreakEvenHours, 1) ||
+				(!math.IsInf(c.wantBreakEven, 1) && !closeTo(switchingCost.BreakEvenHours, c.wantBreakEven)) {
+				t.Errorf("break-even hours = %v, want %v", switchingCost.BreakEvenHours, c.wantBreakEven)
+			}
+			if got := switchingCost.Covered(); got != c.wantCovered {
+				t.Errorf("Covered() = %v, want %v", got, c.wantCovered)
+			}
+		})
+	}
+}
+
+func TestIsGeneratedLaunchConfigurationName(t *testing.T) {
+	input := autoscaling.CreateLaunchConfigurationInput{
+		InstanceType: aws.String("r4.xlarge"), SpotPrice: aws.String("0.11"), ImageId: aws.String("ami-1")}
+	generated := GetNewLaunchConfigurationName("nodes-spot", input)
+	renamed := input
+	renamed.LaunchConfigurationName = aws.String("anything")
+	if GetNewLaunchConfigurationName("nodes-spot", renamed) != generated {
+		t.Errorf("the name of a launch configuration depends on its previous name")
+	}
+	changed := input
+	changed.ImageId = aws.String("ami-2")
+	if GetNewLaunchConfigurationName("nodes-spot", changed) == generated {
+		t.Errorf("launch configurations with different settings share the name %q", generated)
+	}
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{generated, true},
+		{"nodes-spot-0123456789abcdef0123456789abcdef", true},
+		{"nodes-spot-r4.xlarge-0.11-1A2B3C4D", false},
+		{"nodes-spot-r4.xlarge-0.11", false},
+		{"nodes-spot", false},
+		{"nodes-spot-manual", false},
+		{"other-nodes-spot-r4.xlarge-0.11-1a2b3c4d", false},
+		{"nodesxspot-r4.xlarge-0.11-1a2b3c4d", false},
+	}
+	for _, c := range cases {
+		if got := IsGeneratedLaunchConfigurationName("nodes-spot", c.name); got != c.want {
+			t.Errorf("IsGeneratedLaunchConfigurationName(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
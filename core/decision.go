@@ -0,0 +1,245 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/metrics"
+)
+
+// Possible outcomes of a CheckAndUpdate evaluation.
+const (
+	DecisionNoChange  = "no-change"
+	DecisionSwitch    = "switch"
+	DecisionBidChange = "bid-change"
+	DecisionBlocked   = "blocked"
+	DecisionConvert   = "convert-to-spot"
+	// DecisionApplyFailed is a switch, bid change or conversion that could not
+	// be applied to the autoscaling group.
+	DecisionApplyFailed = "apply-failed"
+	// DecisionRolledBack moves the group back to the launch configuration it
+	// ran before a switch whose nodes did not become Ready.
+	DecisionRolledBack = "rolled-back"
+)
+
+// Decision records what a single CheckAndUpdate evaluation saw, what it chose
+// to do and why.
+type Decision struct {
+	Time                            time.Time
+	AutoScalingGroupName            string
+	Outcome                         string
+	Reason                          string
+	OriginalLaunchConfigurationName string
+	OriginalInstanceType            string
+	OriginalSpotPrice               float64
+	OriginalOnDemand                bool
+	OriginalDollarsPerHour          float64
+	NewLaunchConfigurationName      string
+	NewInstanceType                 string
+	NewSpotPrice                    float64
+	NewDollarsPerHour               float64
+	SwitchingCost                   SwitchingCost
+	Monitor                         bool
+
+	// Demand and Candidates are the inputs the decision was made from: the
+	// cluster's pod summary and every instance type that was considered.
+	Demand     map[string]float64
+	Candidates []Candidate
+}
+
+// Candidate is an instance type considered by a decision, with the reason it
+// was rejected if it fails the configured constraints.
+type Candidate struct {
+	InstanceType            string  `json:"instanceType"`
+	Mem                     float64 `json:"memGB"`
+	Cpus                    float64 `json:"cpus"`
+	CoefVar                 float64 `json:"coefVar"`
+	SpotPrice               float64 `json:"spotPrice"`
+	DollarsPerHour          float64 `json:"dollarsPerHour"`
+	PenalizedDollarsPerHour float64 `json:"penalizedDollarsPerHour"`
+	Rejection               string  `json:"rejection,omitempty"`
+}
+
+// decisionLogCandidates bounds how many of the cheapest eligible candidates a
+// decision record lists; the rejected ones are only counted by reason.
+const decisionLogCandidates = 10
+
+// Updated reports whether the decision changed the autoscaling group.
+func (d Decision) Updated() bool {
+	return d.Outcome == DecisionSwitch || d.Outcome == DecisionBidChange || d.Outcome == DecisionConvert ||
+		d.Outcome == DecisionRolledBack
+}
+
+// OnDemand reports whether the group is still on on-demand instances after the
+// decision, in which case CurrentSpotPrice is the on-demand price.
+func (d Decision) OnDemand() bool {
+	return d.OriginalOnDemand && !d.Updated()
+}
+
+// TurnedOver reports whether the decision moved the group onto a new instance
+// type or from on-demand to spot, so that its nodes are replaced.
+func (d Decision) TurnedOver() bool {
+	return !d.Monitor && (d.Outcome == DecisionSwitch || d.Outcome == DecisionConvert)
+}
+
+// CurrentInstanceType returns the instance type the group runs after the decision.
+func (d Decision) CurrentInstanceType() string {
+	if d.Updated() {
+		return d.NewInstanceType
+	}
+	return d.OriginalInstanceType
+}
+
+// CurrentSpotPrice returns the bid the group uses after the decision.
+func (d Decision) CurrentSpotPrice() float64 {
+	if d.Updated() {
+		return d.NewSpotPrice
+	}
+	return d.OriginalSpotPrice
+}
+
+// EstimatedDollarsPerHour returns the hourly cost of the group after the decision.
+func (d Decision) EstimatedDollarsPerHour() float64 {
+	if d.Updated() {
+		return d.NewDollarsPerHour
+	}
+	return d.OriginalDollarsPerHour
+}
+
+// splitCandidates separates the eligible candidates from the rejected ones,
+// which are counted by rejection.
+func splitCandidates(candidates []Candidate) ([]Candidate, map[string]int) {
+	eligible := []Candidate{}
+	rejected := map[string]int{}
+	for _, candidate := range candidates {
+		if len(candidate.Rejection) > 0 {
+			rejected[candidate.Rejection]++
+		} else {
+			eligible = append(eligible, candidate)
+		}
+	}
+	return eligible, rejected
+}
+
+// summarizeRejections lists rejection counts, most common first, such as
+// "12 above maxTotalDollarsPerHour, 3 below minGB".
+func summarizeRejections(rejected map[string]int) string {
+	rejections := []string{}
+	for rejection := range rejected {
+		rejections = append(rejections, rejection)
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		if rejected[rejections[i]] != rejected[rejections[j]] {
+			return rejected[rejections[i]] > rejected[rejections[j]]
+		}
+		return rejections[i] < rejections[j]
+	})
+	summary := []string{}
+	for _, rejection := range rejections {
+		summary = append(summary, fmt.Sprintf("%v %v", rejected[rejection], rejection))
+	}
+	return strings.Join(summary, ", ")
+}
+
+// recordDecision exports a decision as metrics, on /status and as a single
+// structured "decision" log record holding its inputs, the action taken and
+// the reason.
+func recordDecision(d Decision) {
+	metrics.RecordDecision(metrics.Decision{
+		AutoScalingGroupName:    d.AutoScalingGroupName,
+		Outcome:                 d.Outcome,
+		CurrentInstanceType:     d.CurrentInstanceType(),
+		CurrentBid:              d.CurrentSpotPrice(),
+		RecommendedInstanceType: d.NewInstanceType,
+		RecommendedBid:          d.NewSpotPrice,
+		EstimatedDollarsPerHour: d.EstimatedDollarsPerHour()})
+	daemonStatus.recordDecision(d)
+
+	eligible, rejected := splitCandidates(d.Candidates)
+	sort.Slice(eligible, func(i, j int) bool {
+		return eligible[i].PenalizedDollarsPerHour < eligible[j].PenalizedDollarsPerHour
+	})
+	if len(eligible) > decisionLogCandidates {
+		eligible = eligible[:decisionLogCandidates]
+	}
+
+	slog.Info("decision",
+		"autoScalingGroup", d.AutoScalingGroupName,
+		"outcome", d.Outcome,
+		"reason", d.Reason,
+		"monitor", d.Monitor,
+		slog.Group("original",
+			"launchConfiguration", d.OriginalLaunchConfigurationName,
+			"instanceType", d.OriginalInstanceType,
+			"spotPrice", d.OriginalSpotPrice,
+			"onDemand", d.OriginalOnDemand,
+			"dollarsPerHour", d.OriginalDollarsPerHour),
+		slog.Group("new",
+			"launchConfiguration", d.NewLaunchConfigurationName,
+			"instanceType", d.NewInstanceType,
+			"spotPrice", d.NewSpotPrice,
+			"dollarsPerHour", d.NewDollarsPerHour),
+		slog.Group("switchingCost",
+			"nodesAffected", d.SwitchingCost.NodesAffected,
+			"cost", d.SwitchingCost.TotalCost,
+			"horizonSavings", d.SwitchingCost.HorizonSavings),
+		"demand", d.Demand,
+		"candidates", eligible,
+		"rejected", rejected)
+}
+
+// PrintDecision writes a decision to standard out: the group before and after
+// it, what it saves and the cheapest eligible candidates it chose from.
+func PrintDecision(d Decision) {
+	monitor := ""
+	if d.Monitor {
+		monitor = " (monitor only)"
+	}
+	fmt.Printf("\nAutoscaling group '%v' at %v: %v%v\n", d.AutoScalingGroupName, d.Time.Format(time.RFC3339),
+		d.Outcome, monitor)
+	fmt.Printf("  %v\n", d.Reason)
+	originalBid := strconv.FormatFloat(d.OriginalSpotPrice, 'f', -1, 64)
+	if d.OriginalOnDemand {
+		originalBid = "on-demand " + originalBid
+	}
+	fmt.Printf("  Before || LaunchConfiguration: %v | InstanceType: %12v | SpotPrice: %6v | $/hour: %8.3f\n",
+		d.OriginalLaunchConfigurationName, d.OriginalInstanceType, originalBid, d.OriginalDollarsPerHour)
+	if d.Updated() {
+		newLaunchConfigurationName := d.NewLaunchConfigurationName
+		if len(newLaunchConfigurationName) == 0 {
+			newLaunchConfigurationName = "(new)"
+		}
+		fmt.Printf("  After  || LaunchConfiguration: %v | InstanceType: %12v | SpotPrice: %6v | $/hour: %8.3f\n",
+			newLaunchConfigurationName, d.NewInstanceType, d.NewSpotPrice, d.NewDollarsPerHour)
+	}
+	if len(d.NewInstanceType) > 0 {
+		fmt.Printf("  Savings || $/hour: %.3f | Over amortisation horizon: $%.2f | Switching cost: $%.2f for %v nodes\n",
+			d.SwitchingCost.HourlySavings, d.SwitchingCost.HorizonSavings, d.SwitchingCost.TotalCost,
+			d.SwitchingCost.NodesAffected)
+	}
+
+	eligible, rejected := splitCandidates(d.Candidates)
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].PenalizedDollarsPerHour < eligible[j].PenalizedDollarsPerHour
+	})
+	if len(eligible) > decisionLogCandidates {
+		eligible = eligible[:decisionLogCandidates]
+	}
+	if len(eligible) > 0 {
+		fmt.Printf("\nCheapest eligible candidates:\n")
+		fmt.Printf("  %4v %12v || %7v %5v %6v %9v %9v %9v\n", "Rank", "InstanceType", "MemGB", "CPUs", "CV",
+			"SpotPrice", "$/hour", "Penalized")
+	}
+	for rank, candidate := range eligible {
+		fmt.Printf("  %4v %12v || %7v %5v %6.3f %9.4f %9.3f %9.3f\n", rank+1, candidate.InstanceType, candidate.Mem,
+			candidate.Cpus, candidate.CoefVar, candidate.SpotPrice, candidate.DollarsPerHour,
+			candidate.PenalizedDollarsPerHour)
+	}
+	if len(rejected) > 0 {
+		fmt.Printf("  Rejected: %v\n", summarizeRejections(rejected))
+	}
+}
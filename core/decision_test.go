@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCandidates(t *testing.T) {
+	candidates := []Candidate{
+		{InstanceType: "r5.xlarge"},
+		{InstanceType: "m5.large", Rejection: "below minGB"},
+		{InstanceType: "x1.32xlarge", Rejection: "above maxTotalDollarsPerHour"},
+		{InstanceType: "r4.xlarge"},
+		{InstanceType: "c5.large", Rejection: "below minGB"},
+	}
+	eligible, rejected := splitCandidates(candidates)
+	names := []string{}
+	for _, candidate := range eligible {
+		names = append(names, candidate.InstanceType)
+	}
+	if want := []string{"r5.xlarge", "r4.xlarge"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("eligible = %v, want %v", names, want)
+	}
+	if want := map[string]int{"below minGB": 2, "above maxTotalDollarsPerHour": 1}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("rejected = %v, want %v", rejected, want)
+	}
+}
+
+func TestSummarizeRejections(t *testing.T) {
+	cases := []struct {
+		name     string
+		rejected map[string]int
+		want     string
+	}{
+		{"none", map[string]int{}, ""},
+		{"most common first", map[string]int{"below minGB": 3, "above maxTotalDollarsPerHour": 12},
+			"12 above maxTotalDollarsPerHour, 3 below minGB"},
+		{"ties by name", map[string]int{"below minGB": 2, "below minCpus": 2, "recently interrupted": 1},
+			"2 below minCpus, 2 below minGB, 1 recently interrupted"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := summarizeRejections(c.rejected); got != c.want {
+				t.Errorf("summarizeRejections = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecisionCurrentState(t *testing.T) {
+	original := Decision{
+		OriginalInstanceType:   "r4.xlarge",
+		OriginalSpotPrice:      0.3,
+		OriginalOnDemand:       true,
+		OriginalDollarsPerHour: 3.0,
+		NewInstanceType:        "r5.xlarge",
+		NewSpotPrice:           0.2,
+		NewDollarsPerHour:      2.0,
+	}
+	cases := []struct {
+		outcome        string
+		monitor        bool
+		wantUpdated    bool
+		wantTurnedOver bool
+	}{
+		{DecisionNoChange, false, false, false},
+		{DecisionBlocked, false, false, false},
+		{DecisionApplyFailed, false, false, false},
+		{DecisionBidChange, false, true, false},
+		{DecisionSwitch, false, true, true},
+		{DecisionConvert, false, true, true},
+		{DecisionRolledBack, false, true, false},
+		{DecisionSwitch, true, true, false},
+	}
+	for _, c := range cases {
+		d := original
+		d.Outcome = c.outcome
+		d.Monitor = c.monitor
+		if got := d.Updated(); got != c.wantUpdated {
+			t.Errorf("%v (monitor %v): Updated() = %v, want %v", c.outcome, c.monitor, got, c.wantUpdated)
+		}
+		if got := d.TurnedOver(); got != c.wantTurnedOver {
+			t.Errorf("%v (monitor %v): TurnedOver() = %v, want %v", c.outcome, c.monitor, got, c.wantTurnedOver)
+		}
+		if got := d.OnDemand(); got != !c.wantUpdated {
+			t.Errorf("%v (monitor %v): OnDemand() = %v, want %v", c.outcome, c.monitor, got, !c.wantUpdated)
+		}
+		wantType, wantPrice, wantDollars := "r4.xlarge", 0.3, 3.0
+		if c.wantUpdated {
+			wantType, wantPrice, wantDollars = "r5.xlarge", 0.2, 2.0
+		}
+		if d.CurrentInstanceType() != wantType || d.CurrentSpotPrice() != wantPrice ||
+			d.EstimatedDollarsPerHour() != wantDollars {
+			t.Errorf("%v (monitor %v): current = %v %v %v, want %v %v %v", c.outcome, c.monitor,
+				d.CurrentInstanceType(), d.CurrentSpotPrice(), d.EstimatedDollarsPerHour(),
+				wantType, wantPrice, wantDollars)
+		}
+	}
+}
+
+func TestRecordDecisionLogsOneRecord(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	candidates := []Candidate{{InstanceType: "m5.large", Rejection: "below minGB", PenalizedDollarsPerHour: 0.5}}
+	for i := 0; i < decisionLogCandidates+2; i++ {
+		candidates = append(candidates, Candidate{InstanceType: "r5.xlarge",
+			PenalizedDollarsPerHour: float64(decisionLogCandidates + 2 - i)})
+	}
+	recordDecision(Decision{
+		AutoScalingGroupName: "workers",
+		Outcome:              DecisionSwitch,
+		Reason:               "'r5.xlarge' is cheaper than 'r4.xlarge'",
+		OriginalInstanceType: "r4.xlarge",
+		NewInstanceType:      "r5.xlarge",
+		Demand:               map[string]float64{"maxPodMemory": 4},
+		Candidates:           candidates,
+	})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("got %v log records, want 1:\n%s", len(lines), buf.String())
+	}
+	var record struct {
+		Msg              string
+		AutoScalingGroup string
+		Outcome          string
+		Reason           string
+		Original         struct{ InstanceType string }
+		New              struct{ InstanceType string }
+		Candidates       []Candidate
+		Rejected         map[string]int
+	}
+	if err := json.Unmarshal(lines[0], &record); err != nil {
+		t.Fatalf("decision record is not JSON: %v", err)
+	}
+	if record.Msg != "decision" || record.AutoScalingGroup != "workers" || record.Outcome != DecisionSwitch ||
+		record.Reason == "" || record.Original.InstanceType != "r4.xlarge" || record.New.InstanceType != "r5.xlarge" {
+		t.Errorf("decision record = %+v", record)
+	}
+	if len(record.Candidates) != decisionLogCandidates {
+		t.Fatalf("listed %v candidates, want %v", len(record.Candidates), decisionLogCandidates)
+	}
+	for i := 1; i < len(record.Candidates); i++ {
+		if record.Candidates[i].PenalizedDollarsPerHour < record.Candidates[i-1].PenalizedDollarsPerHour {
+			t.Errorf("candidates not cheapest first: %+v", record.Candidates)
+			break
+		}
+	}
+	if record.Candidates[0].PenalizedDollarsPerHour != 1 {
+		t.Errorf("cheapest candidate costs %v, want 1", record.Candidates[0].PenalizedDollarsPerHour)
+	}
+	if want := map[string]int{"below minGB": 1}; !reflect.DeepEqual(record.Rejected, want) {
+		t.Errorf("rejected = %v, want %v", record.Rejected, want)
+	}
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+func getInstanceIDs(autoscalingGroup *autoscaling.Group) []string {
+	instanceIDs := []string{}
+	for _, instance := range autoscalingGroup.Instances {
+		instanceIDs = append(instanceIDs, *instance.InstanceId)
+	}
+	return instanceIDs
+}
+
+// checkDisruptionBudgets reports on the PodDisruptionBudgets covering the pods
+// running on the nodes backing instanceIDs.
+func checkDisruptionBudgets(clientset *kubernetes.Clientset, instanceIDs []string) (k8code.DisruptionReport, error) {
+	nodeNamesByInstanceID, err := k8code.GetNodeNamesByInstanceID(clientset)
+	if err != nil {
+		return k8code.DisruptionReport{}, err
+	}
+	nodeNames := []string{}
+	for _, instanceID := range instanceIDs {
+		if nodeName, found := nodeNamesByInstanceID[instanceID]; found {
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+	return k8code.CheckDisruptionBudgets(clientset, nodeNames)
+}
+
+// turnsOverNodes reports whether applying decision replaces the group's
+// nodes, which a bid change on the same instance type does not.
+func turnsOverNodes(decision Decision) bool {
+	return decision.Updated() && decision.Outcome != DecisionBidChange
+}
+
+// blockOnDisruptionBudgets blocks decision if a PodDisruptionBudget covering
+// the pods on the group's nodes currently allows no disruptions.
+func blockOnDisruptionBudgets(decision Decision, report k8code.DisruptionReport) Decision {
+	if blocking := report.Blocking(); len(blocking) > 0 {
+		logDisruptionReport(report)
+		decision.Outcome = DecisionBlocked
+		decision.Reason = fmt.Sprintf("PodDisruptionBudget '%v' allows no disruptions", blocking[0].Name)
+	}
+	return decision
+}
+
+func logDisruptionReport(report k8code.DisruptionReport) {
+	for _, budget := range report.Budgets {
+		slog.Info("disruption budget", "podDisruptionBudget", budget.Name,
+			"affectedPods", budget.AffectedPods, "disruptionsAllowed", budget.DisruptionsAllowed)
+	}
+	slog.Info("disruption budgets", "affectedPods", report.AffectedPods, "evictablePods", report.EvictablePods)
+}
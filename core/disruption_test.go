@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+func TestTurnsOverNodes(t *testing.T) {
+	cases := map[string]bool{
+		DecisionSwitch:      true,
+		DecisionConvert:     true,
+		DecisionRolledBack:  true,
+		DecisionBidChange:   false,
+		DecisionNoChange:    false,
+		DecisionBlocked:     false,
+		DecisionApplyFailed: false,
+	}
+	for outcome, want := range cases {
+		if got := turnsOverNodes(Decision{Outcome: outcome}); got != want {
+			t.Errorf("turnsOverNodes(%v) = %v, want %v", outcome, got, want)
+		}
+	}
+}
+
+func TestBlockOnDisruptionBudgets(t *testing.T) {
+	switchDecision := Decision{Outcome: DecisionSwitch, Reason: "'r5.xlarge' is cheaper than 'r4.xlarge'"}
+	cases := []struct {
+		name        string
+		budgets     []k8code.BudgetCheck
+		wantOutcome string
+		wantReason  string
+	}{
+		{"no budgets", nil, DecisionSwitch, switchDecision.Reason},
+		{"budgets allow disruptions", []k8code.BudgetCheck{{Name: "web", AffectedPods: 3, DisruptionsAllowed: 1}},
+			DecisionSwitch, switchDecision.Reason},
+		{"budget covers no affected pods", []k8code.BudgetCheck{{Name: "db", AffectedPods: 0, DisruptionsAllowed: 0}},
+			DecisionSwitch, switchDecision.Reason},
+		{"budget allows no disruptions", []k8code.BudgetCheck{
+			{Name: "web", AffectedPods: 3, DisruptionsAllowed: 1},
+			{Name: "db", AffectedPods: 1, DisruptionsAllowed: 0}},
+			DecisionBlocked, "PodDisruptionBudget 'db' allows no disruptions"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			report := k8code.DisruptionReport{Budgets: c.budgets}
+			decision := blockOnDisruptionBudgets(switchDecision, report)
+			if decision.Outcome != c.wantOutcome || decision.Reason != c.wantReason {
+				t.Errorf("decision = %v (%v), want %v (%v)", decision.Outcome, decision.Reason,
+					c.wantOutcome, c.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/eventobject"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+// Reasons of the Kubernetes Events recorded for decisions.
+const (
+	EventInstanceTypeSwitched   = "InstanceTypeSwitched"
+	EventConvertedToSpot        = "ConvertedToSpot"
+	EventBidChanged             = "BidChanged"
+	EventTurnoverBlocked        = "TurnoverBlocked"
+	EventNoEligibleInstanceType = "NoEligibleInstanceType"
+	EventApplyFailed            = "ApplyFailed"
+	EventRolledBack             = "RolledBack"
+)
+
+// DecisionEvents records Kubernetes Events for the decisions an operator should
+// know about: switches, conversions and bid changes, blocked turnovers, groups
+// that no instance type fits and failed applies.  The daemon's decisions are
+// recorded on the configured EventObject and a SpotPolicy's on the policy
+// itself.  A nil DecisionEvents records nothing.
+type DecisionEvents struct {
+	recorder *k8code.EventRecorder
+	object   k8code.EventObject
+}
+
+// NewDecisionEvents starts recording Events if spotConfig.EventObject is set.
+func NewDecisionEvents(spotConfig awscode.SpotConfig) (*DecisionEvents, error) {
+	if len(spotConfig.EventObject) == 0 {
+		return nil, nil
+	}
+	object, err := eventobject.Parse(spotConfig.EventObject)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := k8code.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	object, err = k8code.ResolveEventObject(clientset, object)
+	if err != nil {
+		return nil, fmt.Errorf("could not find event object '%v': %w", spotConfig.EventObject, err)
+	}
+	return &DecisionEvents{recorder: k8code.NewEventRecorder(clientset), object: object}, nil
+}
+
+// Shutdown writes the Events still queued, waiting a few seconds at most, and
+// stops recording Events.
+func (e *DecisionEvents) Shutdown() {
+	if e == nil {
+		return
+	}
+	e.recorder.Shutdown()
+}
+
+// Record records an Event for one of the daemon's own decisions.
+func (e *DecisionEvents) Record(d Decision, err error) {
+	if e == nil {
+		return
+	}
+	e.record(e.object, d, err)
+}
+
+// RecordPolicy records an Event for a SpotPolicy's decision on the policy.
+func (e *DecisionEvents) RecordPolicy(policy k8code.SpotPolicy, d Decision, err error) {
+	if e == nil {
+		return
+	}
+	e.record(k8code.SpotPolicyEventObject(policy), d, err)
+}
+
+func (e *DecisionEvents) record(object k8code.EventObject, d Decision, err error) {
+	reason, warning, message := getDecisionEvent(d, err)
+	if len(reason) == 0 {
+		return
+	}
+	if d.Monitor {
+		message = "monitor only, not applied: " + message
+	}
+	e.recorder.Record(object, warning, reason, message)
+}
+
+// getDecisionEvent returns the reason, type and message of the Event for a
+// decision, or an empty reason if it is not worth one.
+func getDecisionEvent(d Decision, err error) (string, bool, string) {
+	group := d.AutoScalingGroupName
+	if err != nil {
+		if d.Outcome != DecisionApplyFailed {
+			return "", false, ""
+		}
+		return EventApplyFailed, true, fmt.Sprintf("could not move autoscaling group '%v' to %v: %v",
+			group, d.NewInstanceType, err)
+	}
+	switch d.Outcome {
+	case DecisionSwitch:
+		return EventInstanceTypeSwitched, false, fmt.Sprintf("autoscaling group '%v' switched from %v to %v at $%.3f/hour: %v",
+			group, d.OriginalInstanceType, d.NewInstanceType, d.NewDollarsPerHour, d.Reason)
+	case DecisionConvert:
+		return EventConvertedToSpot, false, fmt.Sprintf("autoscaling group '%v' converted from on-demand %v to spot %v at $%.3f/hour",
+			group, d.OriginalInstanceType, d.NewInstanceType, d.NewDollarsPerHour)
+	case DecisionBidChange:
+		return EventBidChanged, false, fmt.Sprintf("autoscaling group '%v' bid on %v changed from %v to %v",
+			group, d.NewInstanceType, d.OriginalSpotPrice, d.NewSpotPrice)
+	case DecisionRolledBack:
+		return EventRolledBack, true, fmt.Sprintf("autoscaling group '%v' rolled back from %v to %v: %v",
+			group, d.OriginalInstanceType, d.NewInstanceType, d.Reason)
+	case DecisionBlocked:
+		return EventTurnoverBlocked, true, fmt.Sprintf("autoscaling group '%v' was not moved to %v: %v",
+			group, d.NewInstanceType, d.Reason)
+	case DecisionNoChange:
+		if eligible, _ := splitCandidates(d.Candidates); len(d.Candidates) > 0 && len(eligible) == 0 {
+			return EventNoEligibleInstanceType, true, fmt.Sprintf("autoscaling group '%v' stays on %v: %v",
+				group, d.OriginalInstanceType, d.Reason)
+		}
+	}
+	return "", false, ""
+}
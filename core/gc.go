@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+)
+
+// GarbageCollectionPolicy decides which orphaned launch configurations are
+// removed: the Keep newest of each owner are always kept, and the rest only
+// once they are older than Retention.
+type GarbageCollectionPolicy struct {
+	Retention time.Duration
+	Keep      int
+	DryRun    bool
+}
+
+// getDaemonOwned returns the launch configurations the daemon created, by
+// owner: those in the registry and those whose name was generated for
+// spotConfig.LaunchConfigurationPrefix.
+func getDaemonOwned(allLaunchConfigurations []*autoscaling.LaunchConfiguration,
+	records map[string]LaunchConfigurationRecord, spotConfig awscode.SpotConfig) map[string][]*autoscaling.LaunchConfiguration {
+
+	owned := map[string][]*autoscaling.LaunchConfiguration{}
+	for _, lc := range allLaunchConfigurations {
+		if record, found := records[*lc.LaunchConfigurationName]; found {
+			owned[record.Owner] = append(owned[record.Owner], lc)
+		} else if len(spotConfig.LaunchConfigurationPrefix) > 0 &&
+			IsGeneratedLaunchConfigurationName(spotConfig.LaunchConfigurationPrefix, *lc.LaunchConfigurationName) {
+			owner := getOwner(spotConfig)
+			owned[owner] = append(owned[owner], lc)
+		}
+	}
+	return owned
+}
+
+// isOwnedBy reports whether spotConfig's daemon or SpotPolicy created the named
+// launch configuration.  A registry record decides; without one the name must
+// have been generated for spotConfig.LaunchConfigurationPrefix, which leaves
+// out hand-made launch configurations sharing the prefix.
+func isOwnedBy(name string, records map[string]LaunchConfigurationRecord, spotConfig awscode.SpotConfig) bool {
+	if record, found := records[name]; found {
+		return record.Owner == getOwner(spotConfig)
+	}
+	return len(spotConfig.LaunchConfigurationPrefix) > 0 &&
+		IsGeneratedLaunchConfigurationName(spotConfig.LaunchConfigurationPrefix, name)
+}
+
+// CollectGarbage removes the daemon-owned launch configurations that no
+// autoscaling group has attached, subject to policy, and prunes registry
+// entries whose launch configuration no longer exists.
+func CollectGarbage(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset,
+	spotConfig awscode.SpotConfig, policy GarbageCollectionPolicy) error {
+
+	registry := NewRegistry(clientset, spotConfig)
+	records, err := registry.Records()
+	if err != nil {
+		return err
+	}
+	allLaunchConfigurations, err := awscode.DescribeLaunchConfigurations(ctx, sess)
+	if err != nil {
+		return err
+	}
+	attached, err := awscode.GetAttachedLaunchConfigurations(ctx, sess)
+	if err != nil {
+		return err
+	}
+
+	deletion_term := "would"
+	if !policy.DryRun {
+		deletion_term = "will"
+	} else {
+		fmt.Printf("Dry run only...\n")
+	}
+
+	owned := getDaemonOwned(allLaunchConfigurations, records, spotConfig)
+	owners := []string{}
+	for owner := range owned {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	targets := rollbackTargets(records)
+	now := time.Now()
+	deleted := []string{}
+	for _, owner := range owners {
+		launchConfigurations := owned[owner]
+		sort.Slice(launchConfigurations, func(i, j int) bool {
+			return aws.TimeValue(launchConfigurations[i].CreatedTime).After(aws.TimeValue(launchConfigurations[j].CreatedTime))
+		})
+		fmt.Printf("\nOwner '%v':\n", owner)
+		kept := 0
+		for _, lc := range launchConfigurations {
+			name := *lc.LaunchConfigurationName
+			age := now.Sub(aws.TimeValue(lc.CreatedTime))
+			if groupName, found := attached[name]; found {
+				fmt.Printf("    %v || keep: attached to autoscalinggroup '%v'\n", name, groupName)
+				continue
+			}
+			if targets[name] {
+				fmt.Printf("    %v || keep: named by a pending or failed switch\n", name)
+				continue
+			}
+			if kept < policy.Keep {
+				kept++
+				fmt.Printf("    %v || keep: one of the %v newest orphans\n", name, policy.Keep)
+				continue
+			}
+			if age < policy.Retention {
+				fmt.Printf("    %v || keep: created %v ago, within retention\n", name, age.Round(time.Minute))
+				continue
+			}
+			fmt.Printf("    %v || orphaned for %v, %v be deleted\n", name, age.Round(time.Minute), deletion_term)
+			if policy.DryRun {
+				continue
+			}
+			if err := awscode.DeleteLaunchConfiguration(ctx, sess, name); err != nil {
+				fmt.Printf("Could not delete launchconfiguration '%v': %v\n", name, err)
+				continue
+			}
+			deleted = append(deleted, name)
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, lc := range allLaunchConfigurations {
+		existing[*lc.LaunchConfigurationName] = true
+	}
+	for name := range records {
+		if !existing[name] {
+			fmt.Printf("\nRegistry entry '%v' has no launchconfiguration and %v be removed\n", name, deletion_term)
+			deleted = append(deleted, name)
+		}
+	}
+	if policy.DryRun || len(deleted) == 0 {
+		return nil
+	}
+	return registry.Forget(deleted...)
+}
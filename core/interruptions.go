@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// CollectInterruptions records the interruption and rebalance signals found on
+// the cluster's nodes and, if one is configured and readQueue is set, in the
+// EventBridge queue.  Reading the queue consumes its messages, so only one
+// replica may read it.
+func CollectInterruptions(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset, spotConfig awscode.SpotConfig,
+	tracker *pricing.InterruptionTracker, readQueue bool) {
+
+	interruptions := []pricing.Interruption{}
+
+	nodeInterruptions, err := k8code.GetNodeInterruptions(clientset)
+	if err != nil {
+		slog.Warn("could not read node interruption signals", "error", err)
+	}
+	for _, nodeInterruption := range nodeInterruptions {
+		interruptions = append(interruptions, pricing.Interruption{
+			InstanceID:   nodeInterruption.InstanceID,
+			InstanceType: nodeInterruption.InstanceType,
+			Rebalance:    nodeInterruption.Rebalance,
+			Time:         nodeInterruption.Time})
+	}
+
+	// Queue events are deleted once recorded; those whose instance type is
+	// unknown stay queued, to be retried after the visibility timeout, until
+	// they are abandoned.
+	recordedEvents := []awscode.InterruptionEvent{}
+	abandoned := 0
+	if readQueue && len(spotConfig.InterruptionQueueURL) > 0 {
+		events, err := awscode.ReceiveInterruptionEvents(ctx, sess, spotConfig.InterruptionQueueURL)
+		if err != nil {
+			slog.Warn("could not read interruption queue", "queueUrl", spotConfig.InterruptionQueueURL, "error", err)
+		}
+		unresolved := 0
+		now := time.Now()
+		for _, event := range events {
+			if len(event.InstanceType) == 0 {
+				if isAbandonedInterruptionEvent(event, spotConfig, now) {
+					recordedEvents = append(recordedEvents, event)
+					abandoned++
+					continue
+				}
+				unresolved++
+				continue
+			}
+			interruptions = append(interruptions, pricing.Interruption{
+				InstanceID:   event.InstanceID,
+				InstanceType: event.InstanceType,
+				Rebalance:    event.DetailType == awscode.RebalanceRecommendationDetailType,
+				Time:         event.Time})
+			recordedEvents = append(recordedEvents, event)
+		}
+		if unresolved > 0 {
+			slog.Warn("instance type of interruption events unknown, leaving them queued",
+				"queueUrl", spotConfig.InterruptionQueueURL, "events", unresolved)
+		}
+		if abandoned > 0 {
+			slog.Warn("instance type of interruption events still unknown, deleting them",
+				"queueUrl", spotConfig.InterruptionQueueURL, "events", abandoned)
+		}
+	}
+
+	for _, interruption := range interruptions {
+		if tracker.Record(interruption) {
+			kind := "interruption"
+			if interruption.Rebalance {
+				kind = "rebalance recommendation"
+			}
+			slog.Info("spot "+kind, "instanceType", interruption.InstanceType,
+				"instanceId", interruption.InstanceID, "time", interruption.Time)
+		}
+	}
+	if len(recordedEvents) > 0 {
+		if err := awscode.DeleteInterruptionEvents(ctx, sess, spotConfig.InterruptionQueueURL, recordedEvents); err != nil {
+			slog.Warn("could not delete recorded interruption events", "queueUrl", spotConfig.InterruptionQueueURL, "error", err)
+		}
+	}
+}
+
+// maxInterruptionEventReceives is how many times an interruption event whose
+// instance cannot be described is received before it is deleted unrecorded.
+const maxInterruptionEventReceives = 5
+
+// isAbandonedInterruptionEvent reports whether a queued event whose instance
+// type is unknown should be deleted rather than left for another attempt:
+// once it has been received maxInterruptionEventReceives times, or once it is
+// older than the interruption window and could no longer count towards a rate.
+func isAbandonedInterruptionEvent(event awscode.InterruptionEvent, spotConfig awscode.SpotConfig, now time.Time) bool {
+	window := time.Duration(spotConfig.InterruptionWindowHours * float64(time.Hour))
+	return event.ReceiveCount >= maxInterruptionEventReceives || now.Sub(event.Time) > window
+}
+
+// getInterruptionPenalty returns the factor applied to an instance type's
+// hourly cost when ranking it, based on the interruptions seen in the window
+// and its interruption-frequency bucket.
+func getInterruptionPenalty(instanceSummary pricing.FullSummary, spotConfig awscode.SpotConfig) float64 {
+	interruptions := instanceSummary.InterruptionRate * spotConfig.InterruptionWindowHours
+	penalty := 1.0 + spotConfig.InterruptionPenaltyPercentage*0.01*interruptions
+	if instanceSummary.InterruptionBucket > 0 {
+		penalty += spotConfig.FrequencyPenaltyPercentage * 0.01 * float64(instanceSummary.InterruptionBucket)
+	}
+	return penalty
+}
+
+func isRecentlyInterrupted(priceList []pricing.FullSummary, instanceType string) bool {
+	for _, instanceSummary := range priceList {
+		if instanceSummary.Name == instanceType {
+			return instanceSummary.RecentlyInterrupted
+		}
+	}
+	return false
+}
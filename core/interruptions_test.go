@@ -0,0 +1,30 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+)
+
+func TestIsAbandonedInterruptionEvent(t *testing.T) {
+	spotConfig := awscode.DefaultSpotConfig()
+	spotConfig.InterruptionWindowHours = 24
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		event awscode.InterruptionEvent
+		want  bool
+	}{
+		{"first receive", awscode.InterruptionEvent{ReceiveCount: 1, Time: now.Add(-time.Minute)}, false},
+		{"receive count unknown", awscode.InterruptionEvent{Time: now.Add(-time.Minute)}, false},
+		{"received too often", awscode.InterruptionEvent{ReceiveCount: maxInterruptionEventReceives,
+			Time: now.Add(-time.Minute)}, true},
+		{"older than the window", awscode.InterruptionEvent{ReceiveCount: 1, Time: now.Add(-25 * time.Hour)}, true},
+	}
+	for _, c := range cases {
+		if got := isAbandonedInterruptionEvent(c.event, spotConfig, now); got != c.want {
+			t.Errorf("%v: isAbandonedInterruptionEvent() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
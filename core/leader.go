@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+// Leadership tracks whether this replica currently leads.  Each term as leader
+// has its own context, which client-go's elector cancels as soon as
+// leadership is lost and which bounds everything the replica changes during
+// that term.  A nil Leadership, used when leader election is disabled, always
+// leads.
+type Leadership struct {
+	Identity string
+
+	mu   sync.Mutex
+	term context.Context
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func (l *Leadership) IsLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.term != nil && l.term.Err() == nil
+}
+
+func (l *Leadership) startTerm(term context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.term = term
+}
+
+// endTerm forgets the current term, reporting whether there was one.
+func (l *Leadership) endTerm() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ended := l.term != nil
+	l.term = nil
+	return ended
+}
+
+// leaderTermKey carries the context of the current term as leader, from which
+// applies are derived.
+type leaderTermKey struct{}
+
+// Scope returns a context for one iteration, cancelled with ctx or as soon as
+// this replica stops leading, and reports whether it leads.  A standby
+// replica's context is only cancelled with ctx.
+func (l *Leadership) Scope(ctx context.Context) (context.Context, context.CancelFunc, bool) {
+	if l == nil {
+		scoped, cancel := context.WithCancel(ctx)
+		return scoped, cancel, true
+	}
+	l.mu.Lock()
+	term := l.term
+	l.mu.Unlock()
+	if term == nil || term.Err() != nil {
+		scoped, cancel := context.WithCancel(ctx)
+		return scoped, cancel, false
+	}
+	scoped, cancel := context.WithCancel(context.WithValue(ctx, leaderTermKey{}, term))
+	stopWatching := context.AfterFunc(term, cancel)
+	return scoped, func() {
+		stopWatching()
+		cancel()
+	}, true
+}
+
+// applyContext returns the context for an apply, bounded by applyTimeout.  It
+// is deliberately not cancelled with ctx, so that an apply that has started
+// runs to completion or rolls back even if shutdown has been requested, but it
+// is cancelled if the replica that started it stops leading.
+func applyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	term, ok := ctx.Value(leaderTermKey{}).(context.Context)
+	if !ok {
+		term = context.Background()
+	}
+	return context.WithTimeout(term, applyTimeout)
+}
+
+// releaseTimeout bounds how long Stop waits for leadership to be released.
+const releaseTimeout = 5 * time.Second
+
+// Stop stops campaigning, releasing leadership so that another replica can
+// take over at once.  It is called once the loop has finished, so that no
+// apply is still running when leadership passes on.  Stopping a nil
+// Leadership does nothing.
+func (l *Leadership) Stop() {
+	if l == nil {
+		return
+	}
+	l.stop()
+	select {
+	case <-l.done:
+	case <-time.After(releaseTimeout):
+		slog.Warn("leadership was not released in time", "identity", l.Identity)
+	}
+}
+
+// StartLeaderElection campaigns for spotConfig.LeaderElectionName in the
+// background until Stop is called.  Only the leader mutates AWS and reports
+// its decisions; the other replicas keep evaluating in monitor mode.
+func StartLeaderElection(spotConfig awscode.SpotConfig) (*Leadership, error) {
+	if !spotConfig.LeaderElect {
+		return nil, nil
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := k8code.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	electionCtx, stop := context.WithCancel(context.Background())
+	leadership := &Leadership{Identity: identity, stop: stop, done: make(chan struct{})}
+	elector, err := k8code.NewLeaderElector(clientset, spotConfig.LeaderElectionNamespace,
+		spotConfig.LeaderElectionName, identity, k8code.LeaderCallbacks{
+			OnStartedLeading: func(term context.Context) {
+				leadership.startTerm(term)
+				slog.Info("replica became the leader", "identity", identity)
+			},
+			OnStoppedLeading: func() {
+				if leadership.endTerm() {
+					slog.Warn("replica lost leadership, continuing in monitor mode", "identity", identity)
+				}
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					slog.Info("another replica is now the leader", "leader", leader)
+				}
+			},
+		})
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	go func() {
+		defer close(leadership.done)
+		// Run returns once leadership is lost; campaign again until stopped.
+		for electionCtx.Err() == nil {
+			elector.Run(electionCtx)
+		}
+	}()
+	return leadership, nil
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLeadershipScope(t *testing.T) {
+	var disabled *Leadership
+	ctx, endIteration, leading := disabled.Scope(context.Background())
+	if !leading || !disabled.IsLeader() || ctx.Err() != nil {
+		t.Errorf("without leader election the replica does not lead")
+	}
+	endIteration()
+
+	leadership := &Leadership{Identity: "replica-1"}
+	_, endIteration, leading = leadership.Scope(context.Background())
+	if leading || leadership.IsLeader() {
+		t.Errorf("a replica leads before it has been elected")
+	}
+	endIteration()
+
+	term, loseLeadership := context.WithCancel(context.Background())
+	leadership.startTerm(term)
+	iterationCtx, endIteration, leading := leadership.Scope(context.Background())
+	if !leading || !leadership.IsLeader() {
+		t.Fatalf("an elected replica does not lead")
+	}
+	applyCtx, cancelApply := applyContext(iterationCtx)
+	defer cancelApply()
+	if _, ok := applyCtx.Deadline(); !ok {
+		t.Errorf("an apply is not bounded by applyTimeout")
+	}
+
+	loseLeadership()
+	<-iterationCtx.Done()
+	<-applyCtx.Done()
+	if leadership.IsLeader() {
+		t.Errorf("a replica still leads after its term ended")
+	}
+	endIteration()
+	if !leadership.endTerm() || leadership.endTerm() {
+		t.Errorf("endTerm does not report the term once")
+	}
+}
+
+func TestApplyContextOutlivesShutdown(t *testing.T) {
+	leadership := &Leadership{Identity: "replica-1"}
+	term, loseLeadership := context.WithCancel(context.Background())
+	defer loseLeadership()
+	leadership.startTerm(term)
+
+	daemonCtx, shutdown := context.WithCancel(context.Background())
+	iterationCtx, endIteration, _ := leadership.Scope(daemonCtx)
+	defer endIteration()
+	applyCtx, cancelApply := applyContext(iterationCtx)
+	defer cancelApply()
+
+	shutdown()
+	<-iterationCtx.Done()
+	if applyCtx.Err() != nil {
+		t.Errorf("shutting down cancelled an apply in progress")
+	}
+}
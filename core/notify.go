@@ -0,0 +1,105 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/notify"
+)
+
+// webhookTimeout bounds a single webhook delivery attempt.
+const webhookTimeout = 10 * time.Second
+
+// NewNotifier starts delivering notifications to the webhooks of
+// spotConfig.NotificationsFile, if it is set.
+func NewNotifier(spotConfig awscode.SpotConfig) (*notify.Notifier, error) {
+	if len(spotConfig.NotificationsFile) == 0 {
+		return nil, nil
+	}
+	config, err := notify.ReadConfig(spotConfig.NotificationsFile)
+	if err != nil {
+		return nil, err
+	}
+	return notify.NewNotifier(config, &http.Client{Timeout: webhookTimeout}), nil
+}
+
+// notifyDecision sends a decision, with the launch configurations the
+// autoscaling group ran before and after it, to the webhooks routed its
+// outcome.  Failures other than a failed apply are left to notifyFailures.
+func notifyDecision(notifier *notify.Notifier, owner string, d Decision, err error) {
+	if err != nil && d.Outcome != DecisionApplyFailed {
+		return
+	}
+	originalSpotPrice := strconv.FormatFloat(d.OriginalSpotPrice, 'f', 2, 64)
+	if d.OriginalOnDemand {
+		originalSpotPrice = "on-demand"
+	}
+	notification := notify.Notification{
+		Event:            d.Outcome,
+		Time:             d.Time,
+		Owner:            owner,
+		AutoScalingGroup: d.AutoScalingGroupName,
+		Reason:           d.Reason,
+		Monitor:          d.Monitor,
+		Original: &notify.LaunchConfiguration{
+			Name:           d.OriginalLaunchConfigurationName,
+			InstanceType:   d.OriginalInstanceType,
+			SpotPrice:      originalSpotPrice,
+			DollarsPerHour: d.OriginalDollarsPerHour},
+		SwitchingCost: &notify.SwitchingCost{
+			NodesAffected:  d.SwitchingCost.NodesAffected,
+			Cost:           d.SwitchingCost.TotalCost,
+			HourlySavings:  d.SwitchingCost.HourlySavings,
+			HorizonSavings: d.SwitchingCost.HorizonSavings}}
+	if len(d.NewInstanceType) > 0 {
+		notification.New = &notify.LaunchConfiguration{
+			Name:           d.NewLaunchConfigurationName,
+			InstanceType:   d.NewInstanceType,
+			SpotPrice:      strconv.FormatFloat(d.NewSpotPrice, 'f', 2, 64),
+			DollarsPerHour: d.NewDollarsPerHour}
+	}
+	notifier.Notify(notification)
+}
+
+// failureNotifier follows the outcome of each iteration, notifying once the
+// loop has failed notifier.ErrorThreshold times in a row and again when it
+// next succeeds.
+type failureNotifier struct {
+	notifier *notify.Notifier
+	failures int
+	errors   []string
+	notified bool
+}
+
+func (f *failureNotifier) record(err error, monitor bool) {
+	if f.notifier == nil {
+		return
+	}
+	if err == nil {
+		if f.notified {
+			f.notifier.Notify(notify.Notification{
+				Event:   notify.EventRecovered,
+				Reason:  "an iteration succeeded after " + strconv.Itoa(f.failures) + " failures",
+				Monitor: monitor})
+		}
+		f.failures = 0
+		f.errors = nil
+		f.notified = false
+		return
+	}
+	f.failures++
+	f.errors = append(f.errors, err.Error())
+	if len(f.errors) > recentErrorsKept {
+		f.errors = f.errors[len(f.errors)-recentErrorsKept:]
+	}
+	if !f.notified && f.failures >= f.notifier.ErrorThreshold {
+		f.notifier.Notify(notify.Notification{
+			Event:    notify.EventErrors,
+			Monitor:  monitor,
+			Failures: f.failures,
+			Errors:   append([]string{}, f.errors...)})
+		f.notified = true
+	}
+}
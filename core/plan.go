@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// PlanResult is the decision a plan made for one autoscaling group, or the
+// error that stopped it.
+type PlanResult struct {
+	AutoScalingGroupName string
+	Decision             Decision
+	Err                  error
+}
+
+// Plan evaluates every autoscaling group run would manage, once, as run would
+// but without changing anything.  Spot prices are read from AWS rather than
+// the priceHistoryFile, which a running daemon holds, and interruptions are
+// not collected, since reading the interruption queue would consume the
+// daemon's messages; interruption frequencies are still applied.  The error
+// is set if the groups to plan could not be read at all.
+func Plan(ctx context.Context, spotConfig awscode.SpotConfig) ([]PlanResult, error) {
+	clientset, err := k8code.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(spotConfig.RegionName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	podSummary, err := k8code.SummarizePods(clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := []awscode.SpotConfig{spotConfig}
+	if spotConfig.WatchSpotPolicies {
+		policies, err := k8code.ListSpotPolicies(clientset, spotConfig.SpotPolicyNamespace)
+		if err != nil {
+			return nil, err
+		}
+		configs = []awscode.SpotConfig{}
+		for _, policy := range policies {
+			configs = append(configs, SpotConfigForPolicy(spotConfig, policy))
+		}
+	}
+
+	results := []PlanResult{}
+	for _, config := range configs {
+		result := PlanResult{AutoScalingGroupName: config.AutoScalingGroupName}
+		result.Decision, result.Err = func() (Decision, error) {
+			if err := config.Validate(); err != nil {
+				return Decision{}, err
+			}
+			inputs, err := pricing.GetInputs(ctx, sess, config, nil, nil)
+			if err != nil {
+				return Decision{}, err
+			}
+			priceList, err := pricing.DescribePricing(config, inputs)
+			if err != nil {
+				return Decision{}, err
+			}
+			_, decision, err := evaluate(ctx, sess, config, priceList, podSummary, clientset, nil, false)
+			return decision, err
+		}()
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// PlanChanges reports whether a plan recommends updating any autoscaling group.
+func PlanChanges(results []PlanResult) bool {
+	for _, result := range results {
+		if result.Err == nil && result.Decision.Updated() {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanFailed reports whether a plan could not decide for any autoscaling group.
+func PlanFailed(results []PlanResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintPlan writes a plan to standard out.
+func PrintPlan(results []PlanResult) {
+	changes := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("\nAutoscaling group '%v': error || %v\n", result.AutoScalingGroupName, result.Err)
+			continue
+		}
+		PrintDecision(result.Decision)
+		if result.Decision.Updated() {
+			changes++
+		}
+	}
+	if len(results) == 0 {
+		fmt.Printf("\nNo autoscaling groups to plan.\n")
+		return
+	}
+	fmt.Printf("\nPlan: change recommended for %v of %v autoscaling groups.\n", changes, len(results))
+}
+
+// planConfiguration is a group's instance type and bid in a JSON plan.
+type planConfiguration struct {
+	LaunchConfiguration string  `json:"launchConfiguration,omitempty"`
+	InstanceType        string  `json:"instanceType"`
+	SpotPrice           float64 `json:"spotPrice"`
+	OnDemand            bool    `json:"onDemand"`
+	DollarsPerHour      float64 `json:"dollarsPerHour"`
+}
+
+// planSavings is what a JSON plan's recommended instance type and bid save.
+type planSavings struct {
+	DollarsPerHour float64 `json:"dollarsPerHour"`
+	HorizonDollars float64 `json:"horizonDollars"`
+	SwitchingCost  float64 `json:"switchingCost"`
+	NodesAffected  int     `json:"nodesAffected"`
+	// BreakEvenHours is missing if the switch saves nothing.
+	BreakEvenHours *float64 `json:"breakEvenHours,omitempty"`
+}
+
+// planGroup is the plan for one autoscaling group in a JSON plan.  After is
+// only set if the plan changes the group; Candidates lists the eligible
+// instance types cheapest first, then the rejected ones.
+type planGroup struct {
+	AutoScalingGroupName string             `json:"autoScalingGroupName"`
+	Time                 *time.Time         `json:"time,omitempty"`
+	Outcome              string             `json:"outcome,omitempty"`
+	Reason               string             `json:"reason,omitempty"`
+	Change               bool               `json:"change"`
+	Before               *planConfiguration `json:"before,omitempty"`
+	After                *planConfiguration `json:"after,omitempty"`
+	Savings              *planSavings       `json:"savings,omitempty"`
+	Demand               map[string]float64 `json:"demand,omitempty"`
+	Candidates           []Candidate        `json:"candidates,omitempty"`
+	Error                string             `json:"error,omitempty"`
+}
+
+func newPlanGroup(result PlanResult) planGroup {
+	group := planGroup{AutoScalingGroupName: result.AutoScalingGroupName}
+	if result.Err != nil {
+		group.Error = result.Err.Error()
+		return group
+	}
+	d := result.Decision
+	group.Time = &d.Time
+	group.Outcome = d.Outcome
+	group.Reason = d.Reason
+	group.Change = d.Updated()
+	group.Before = &planConfiguration{
+		LaunchConfiguration: d.OriginalLaunchConfigurationName,
+		InstanceType:        d.OriginalInstanceType,
+		SpotPrice:           d.OriginalSpotPrice,
+		OnDemand:            d.OriginalOnDemand,
+		DollarsPerHour:      d.OriginalDollarsPerHour}
+	if d.Updated() {
+		group.After = &planConfiguration{
+			InstanceType:   d.NewInstanceType,
+			SpotPrice:      d.NewSpotPrice,
+			DollarsPerHour: d.NewDollarsPerHour}
+	}
+	if len(d.NewInstanceType) > 0 {
+		group.Savings = &planSavings{
+			DollarsPerHour: d.SwitchingCost.HourlySavings,
+			HorizonDollars: d.SwitchingCost.HorizonSavings,
+			SwitchingCost:  d.SwitchingCost.TotalCost,
+			NodesAffected:  d.SwitchingCost.NodesAffected}
+		if !math.IsInf(d.SwitchingCost.BreakEvenHours, 1) {
+			breakEvenHours := d.SwitchingCost.BreakEvenHours
+			group.Savings.BreakEvenHours = &breakEvenHours
+		}
+	}
+	group.Demand = d.Demand
+	eligible, _ := splitCandidates(d.Candidates)
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].PenalizedDollarsPerHour < eligible[j].PenalizedDollarsPerHour
+	})
+	group.Candidates = eligible
+	for _, candidate := range d.Candidates {
+		if len(candidate.Rejection) > 0 {
+			group.Candidates = append(group.Candidates, candidate)
+		}
+	}
+	return group
+}
+
+// WritePlanJSON writes a plan to w as a single JSON object, or, if planErr is
+// set, an object holding only the error that stopped the plan.
+func WritePlanJSON(w io.Writer, results []PlanResult, planErr error) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if planErr != nil {
+		return encoder.Encode(map[string]string{"error": planErr.Error()})
+	}
+	groups := []planGroup{}
+	for _, result := range results {
+		groups = append(groups, newPlanGroup(result))
+	}
+	return encoder.Encode(map[string]interface{}{
+		"change": PlanChanges(results),
+		"groups": groups})
+}
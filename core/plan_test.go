@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type planJSON struct {
+	Change bool        `json:"change"`
+	Groups []planGroup `json:"groups"`
+	Error  string      `json:"error"`
+}
+
+func writePlan(t *testing.T, results []PlanResult, planErr error) planJSON {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WritePlanJSON(&buf, results, planErr); err != nil {
+		t.Fatalf("WritePlanJSON: %v", err)
+	}
+	var plan planJSON
+	if err := json.Unmarshal(buf.Bytes(), &plan); err != nil {
+		t.Fatalf("plan is not JSON: %v\n%s", err, buf.String())
+	}
+	return plan
+}
+
+func TestWritePlanJSON(t *testing.T) {
+	switchDecision := Decision{
+		AutoScalingGroupName:            "workers",
+		Outcome:                         DecisionSwitch,
+		Reason:                          "'r5.xlarge' is cheaper than 'r4.xlarge'",
+		OriginalLaunchConfigurationName: "workers-r4",
+		OriginalInstanceType:            "r4.xlarge",
+		OriginalSpotPrice:               0.3,
+		OriginalDollarsPerHour:          3.0,
+		NewInstanceType:                 "r5.xlarge",
+		NewSpotPrice:                    0.2,
+		NewDollarsPerHour:               2.0,
+		SwitchingCost: SwitchingCost{NodesAffected: 10, TotalCost: 5, HourlySavings: 1, HorizonSavings: 24,
+			BreakEvenHours: 5},
+		Candidates: []Candidate{
+			{InstanceType: "m5.large", Rejection: "below minGB"},
+			{InstanceType: "r4.xlarge", PenalizedDollarsPerHour: 3},
+			{InstanceType: "r5.xlarge", PenalizedDollarsPerHour: 2},
+		},
+	}
+	noChangeDecision := Decision{
+		AutoScalingGroupName:   "batch",
+		Outcome:                DecisionNoChange,
+		OriginalInstanceType:   "c5.large",
+		OriginalDollarsPerHour: 1.0,
+		NewInstanceType:        "c5a.large",
+		SwitchingCost:          SwitchingCost{BreakEvenHours: math.Inf(1)},
+	}
+	plan := writePlan(t, []PlanResult{
+		{AutoScalingGroupName: "workers", Decision: switchDecision},
+		{AutoScalingGroupName: "batch", Decision: noChangeDecision},
+		{AutoScalingGroupName: "gpu", Err: fmt.Errorf("no spot price history")},
+	}, nil)
+
+	if !plan.Change || len(plan.Groups) != 3 {
+		t.Fatalf("plan = %+v, want a change over 3 groups", plan)
+	}
+
+	workers := plan.Groups[0]
+	if !workers.Change || workers.Outcome != DecisionSwitch || workers.Before == nil || workers.After == nil {
+		t.Fatalf("workers = %+v", workers)
+	}
+	if workers.Before.LaunchConfiguration != "workers-r4" || workers.Before.InstanceType != "r4.xlarge" ||
+		workers.After.InstanceType != "r5.xlarge" || workers.After.SpotPrice != 0.2 {
+		t.Errorf("workers before %+v after %+v", *workers.Before, *workers.After)
+	}
+	if workers.Savings == nil || workers.Savings.BreakEvenHours == nil || *workers.Savings.BreakEvenHours != 5 ||
+		workers.Savings.NodesAffected != 10 {
+		t.Errorf("workers savings = %+v", workers.Savings)
+	}
+	names := []string{}
+	for _, candidate := range workers.Candidates {
+		names = append(names, candidate.InstanceType)
+	}
+	if want := []string{"r5.xlarge", "r4.xlarge", "m5.large"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("workers candidates = %v, want %v", names, want)
+	}
+
+	batch := plan.Groups[1]
+	if batch.Change || batch.After != nil {
+		t.Errorf("batch = %+v, want no change", batch)
+	}
+	if batch.Savings == nil || batch.Savings.BreakEvenHours != nil {
+		t.Errorf("batch savings = %+v, want no break-even hours", batch.Savings)
+	}
+
+	gpu := plan.Groups[2]
+	if gpu.Error != "no spot price history" || gpu.Before != nil || gpu.Outcome != "" {
+		t.Errorf("gpu = %+v, want only the error", gpu)
+	}
+}
+
+func TestWritePlanJSONError(t *testing.T) {
+	plan := writePlan(t, nil, fmt.Errorf("could not list SpotPolicies"))
+	if plan.Error != "could not list SpotPolicies" || plan.Change || plan.Groups != nil {
+		t.Errorf("plan = %+v, want only the error", plan)
+	}
+}
+
+func TestWritePlanJSONNoGroups(t *testing.T) {
+	plan := writePlan(t, []PlanResult{}, nil)
+	if plan.Change || plan.Groups == nil || len(plan.Groups) != 0 || plan.Error != "" {
+		t.Errorf("plan = %+v, want no change and an empty list of groups", plan)
+	}
+}
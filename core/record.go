@@ -0,0 +1,247 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// Snapshot holds everything one evaluation of an autoscaling group decided
+// from, as the daemon saw it, so that the decision can be made again offline.
+type Snapshot struct {
+	Time       time.Time          `json:"time"`
+	SpotConfig awscode.SpotConfig `json:"spotConfig"`
+	Monitor    bool               `json:"monitor"`
+	PodSummary map[string]float64 `json:"podSummary"`
+	Pricing    pricing.Inputs     `json:"pricing"`
+	// ExcludedTypes were left out of the evaluation because a switch to them
+	// was recently rolled back.
+	ExcludedTypes []string `json:"excludedTypes"`
+
+	// AutoScalingGroup and LaunchConfigurations are as described by AWS, less
+	// the launch configurations' user data, and Group is what was decided from
+	// them.  They are missing if the evaluation failed before reading them.
+	AutoScalingGroup     *autoscaling.Group                 `json:"autoScalingGroup,omitempty"`
+	LaunchConfigurations []*autoscaling.LaunchConfiguration `json:"launchConfigurations,omitempty"`
+	Group                *GroupState                        `json:"group,omitempty"`
+
+	// Decision is what the daemon decided, or Error why it could not.
+	Decision *statusDecision `json:"decision,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// setGroup records the state of the autoscaling group being evaluated.
+// Setting the group of a nil Snapshot does nothing.
+func (s *Snapshot) setGroup(state GroupState) {
+	if s == nil {
+		return
+	}
+	s.AutoScalingGroup = state.autoScalingGroup
+	s.LaunchConfigurations = []*autoscaling.LaunchConfiguration{}
+	for _, lc := range state.launchConfigurations {
+		// User data tends to hold bootstrap secrets and plays no part in
+		// decisions.
+		redacted := *lc
+		redacted.UserData = nil
+		s.LaunchConfigurations = append(s.LaunchConfigurations, &redacted)
+	}
+	s.Group = &state
+}
+
+// ReadSnapshot reads a snapshot written by a Recorder.
+func ReadSnapshot(path string) (Snapshot, error) {
+	snapshot := Snapshot{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("could not parse snapshot '%v': %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// Replay makes the snapshot's decision again from its inputs.  Only Decide
+// is replayed: the PodDisruptionBudget check and the apply that follow an
+// update in CheckAndUpdate need the live cluster.
+func (s Snapshot) Replay() (Decision, error) {
+	if s.Group == nil {
+		return Decision{}, fmt.Errorf("snapshot has no autoscaling group state, the evaluation failed first: %v", s.Error)
+	}
+	priceList, err := pricing.DescribePricing(s.SpotConfig, s.Pricing)
+	if err != nil {
+		return Decision{}, err
+	}
+	excluded := map[string]bool{}
+	for _, instanceType := range s.ExcludedTypes {
+		excluded[instanceType] = true
+	}
+	filtered := []pricing.FullSummary{}
+	for _, instanceSummary := range priceList {
+		if !excluded[instanceSummary.Name] {
+			filtered = append(filtered, instanceSummary)
+		}
+	}
+	decision := Decide(s.SpotConfig, *s.Group, filtered, s.PodSummary, s.Time)
+	decision.Monitor = s.Monitor
+	return decision, nil
+}
+
+// PrintReplay writes a replayed decision to standard out, followed by how it
+// compares with what the daemon decided when the snapshot was recorded.
+func PrintReplay(snapshot Snapshot, decision Decision) {
+	PrintDecision(decision)
+	fmt.Printf("\nRecorded:\n")
+	if snapshot.Decision == nil {
+		fmt.Printf("  error || %v\n", snapshot.Error)
+		return
+	}
+	recorded := snapshot.Decision
+	fmt.Printf("  %v || %v (%v) | %v\n", recorded.Outcome, recorded.RecommendedInstanceType,
+		recorded.RecommendedSpotPrice, recorded.Reason)
+	replayed := newStatusDecision(decision)
+	switch {
+	case recorded.Outcome == replayed.Outcome && recorded.RecommendedInstanceType == replayed.RecommendedInstanceType &&
+		recorded.RecommendedSpotPrice == replayed.RecommendedSpotPrice:
+		fmt.Printf("The replay matches the recorded decision.\n")
+	case decision.Updated() && (recorded.Outcome == DecisionBlocked || recorded.Outcome == DecisionApplyFailed) &&
+		recorded.RecommendedInstanceType == replayed.RecommendedInstanceType:
+		fmt.Printf("The replay matches the recorded decision up to the PodDisruptionBudget check and apply, which are not replayed.\n")
+	default:
+		fmt.Printf("The replay DIFFERS from the recorded decision.\n")
+	}
+}
+
+// Recorder writes a snapshot of each evaluation to a directory, keeping the
+// newest Keep of them if Keep is positive.
+type Recorder struct {
+	Directory string
+	Keep      int
+}
+
+// NewRecorder returns a Recorder writing to spotConfig.RecordDirectory, or
+// nil if it is not set.
+func NewRecorder(spotConfig awscode.SpotConfig) (*Recorder, error) {
+	if len(spotConfig.RecordDirectory) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(spotConfig.RecordDirectory, 0755); err != nil {
+		return nil, fmt.Errorf("could not create record directory: %w", err)
+	}
+	return &Recorder{Directory: spotConfig.RecordDirectory, Keep: spotConfig.RecordKeep}, nil
+}
+
+// Start begins the snapshot of an evaluation from the given inputs, leaving
+// out of them the types of priceList the evaluation was not given.  It
+// returns nil if r is nil.
+func (r *Recorder) Start(spotConfig awscode.SpotConfig, inputs pricing.Inputs, allPrices []pricing.FullSummary,
+	priceList []pricing.FullSummary, podSummary map[string]float64, monitor bool) *Snapshot {
+
+	if r == nil {
+		return nil
+	}
+	kept := map[string]bool{}
+	for _, instanceSummary := range priceList {
+		kept[instanceSummary.Name] = true
+	}
+	excluded := []string{}
+	for _, instanceSummary := range allPrices {
+		if !kept[instanceSummary.Name] {
+			excluded = append(excluded, instanceSummary.Name)
+		}
+	}
+	return &Snapshot{
+		Time:          time.Now(),
+		SpotConfig:    spotConfig,
+		Monitor:       monitor,
+		PodSummary:    podSummary,
+		Pricing:       inputs,
+		ExcludedTypes: excluded}
+}
+
+// unsafeFileCharacters are replaced in the autoscaling group name a snapshot
+// file is named after.
+var unsafeFileCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Save writes the snapshot with the evaluation's outcome and prunes the
+// oldest snapshots.  Failures are logged, since recording must not stop the
+// daemon.  Saving a nil snapshot does nothing.
+func (r *Recorder) Save(snapshot *Snapshot, decision Decision, evaluationErr error) {
+	if r == nil || snapshot == nil {
+		return
+	}
+	if evaluationErr != nil {
+		snapshot.Error = evaluationErr.Error()
+	}
+	if len(decision.Outcome) > 0 {
+		recorded := newStatusDecision(decision)
+		snapshot.Decision = &recorded
+	}
+	name := fmt.Sprintf("%v-%v.json", snapshot.Time.UTC().Format("20060102T150405.000Z"),
+		unsafeFileCharacters.ReplaceAllString(snapshot.SpotConfig.AutoScalingGroupName, "_"))
+	if err := r.write(name, snapshot); err != nil {
+		slog.Warn("could not record snapshot", "file", name, "error", err)
+		return
+	}
+	slog.Debug("recorded snapshot", "file", filepath.Join(r.Directory, name))
+	if err := r.prune(); err != nil {
+		slog.Warn("could not prune recorded snapshots", "error", err)
+	}
+}
+
+// write writes the snapshot to a temporary file first, so that a reader never
+// sees it half written.
+func (r *Recorder) write(name string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(r.Directory, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(r.Directory, name))
+}
+
+// prune deletes all but the newest Keep snapshots, which sort last by name.
+func (r *Recorder) prune() error {
+	if r.Keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(r.Directory)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > r.Keep {
+		if err := os.Remove(filepath.Join(r.Directory, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
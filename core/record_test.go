@@ -0,0 +1,124 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func TestSnapshotReplay(t *testing.T) {
+	spotConfig := awscode.DefaultSpotConfig()
+	spotConfig.AutoScalingGroupName = "nodes"
+	spotConfig.RecordDirectory = t.TempDir()
+	spotConfig.RecordKeep = 2
+	recorder, err := NewRecorder(spotConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	price := func(instanceType string, spotPrice string) ec2.SpotPrice {
+		return ec2.SpotPrice{AvailabilityZone: aws.String("us-west-2a"), InstanceType: aws.String(instanceType),
+			SpotPrice: aws.String(spotPrice), Timestamp: aws.Time(now.Add(-time.Hour))}
+	}
+	inputs := pricing.Inputs{Time: now, SpotPrices: map[string][]ec2.SpotPrice{
+		"r4.xlarge": {price("r4.xlarge", "0.10")},
+		"r3.xlarge": {price("r3.xlarge", "0.05")}}}
+	allPrices, err := pricing.DescribePricing(spotConfig, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r4, _ := findSummary(allPrices, "r4.xlarge")
+	bid := getAdjustedSpotPrice(r4, spotConfig)
+	state := GroupState{AutoScalingGroupName: "nodes", LaunchConfigurationName: "nodes-spot-old",
+		InstanceType: "r4.xlarge", Bid: fmt.Sprintf("%v", bid), Price: bid, Summary: r4, Nodes: 2,
+		autoScalingGroup: &autoscaling.Group{AutoScalingGroupName: aws.String("nodes")},
+		launchConfigurations: []*autoscaling.LaunchConfiguration{
+			{LaunchConfigurationName: aws.String("nodes-spot-old"), UserData: aws.String("secret")}}}
+	podSummary := map[string]float64{"totalMemoryRequestedGB": 60, "maxMemoryRequestedGB": 4}
+
+	cases := []struct {
+		name          string
+		priceList     []pricing.FullSummary
+		evaluationErr error
+		wantOutcome   string
+		wantType      string
+		wantErr       string
+	}{
+		{"switch", allPrices, nil, DecisionSwitch, "r3.xlarge", ""},
+		{"excluded type", []pricing.FullSummary{r4}, nil, DecisionNoChange, "r4.xlarge", ""},
+		{"failed evaluation", nil, errors.New("could not describe autoscaling group"), "", "",
+			"the evaluation failed first: could not describe autoscaling group"},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			snapshot := recorder.Start(spotConfig, inputs, allPrices, c.priceList, podSummary, true)
+			snapshot.Time = now.Add(time.Duration(i) * time.Second)
+			decision := Decision{}
+			if c.evaluationErr == nil {
+				snapshot.setGroup(state)
+				decision = Decide(spotConfig, state, c.priceList, podSummary, snapshot.Time)
+			}
+			recorder.Save(snapshot, decision, c.evaluationErr)
+
+			entries, err := os.ReadDir(spotConfig.RecordDirectory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			sort.Strings(names)
+			want := i + 1
+			if want > spotConfig.RecordKeep {
+				want = spotConfig.RecordKeep
+			}
+			if len(names) != want {
+				t.Errorf("recorded %v, want %v snapshots", names, want)
+			}
+			recorded, err := ReadSnapshot(filepath.Join(spotConfig.RecordDirectory, names[len(names)-1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, lc := range recorded.LaunchConfigurations {
+				if lc.UserData != nil {
+					t.Errorf("snapshot keeps the user data of %v", aws.StringValue(lc.LaunchConfigurationName))
+				}
+			}
+
+			replayed, err := recorded.Replay()
+			if len(c.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Errorf("Replay() error = %v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayed.Outcome != c.wantOutcome || replayed.NewInstanceType != c.wantType {
+				t.Errorf("Replay() = %v %v, want %v %v", replayed.Outcome, replayed.NewInstanceType,
+					c.wantOutcome, c.wantType)
+			}
+			if replayed.Reason != decision.Reason || replayed.NewSpotPrice != decision.NewSpotPrice ||
+				!replayed.Monitor || !replayed.Time.Equal(snapshot.Time) {
+				t.Errorf("Replay() = %+v, want %+v", replayed, decision)
+			}
+			if recorded.Decision == nil || recorded.Decision.Outcome != decision.Outcome {
+				t.Errorf("recorded decision = %+v, want %v", recorded.Decision, decision.Outcome)
+			}
+		})
+	}
+}
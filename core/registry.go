@@ -0,0 +1,152 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+// LaunchConfigurationRecord notes which daemon or SpotPolicy created a launch
+// configuration, for which autoscaling group and when.  Launch configurations
+// cannot be tagged, so these records are kept in a ConfigMap instead.
+type LaunchConfigurationRecord struct {
+	Name                 string    `json:"name"`
+	Owner                string    `json:"owner"`
+	AutoScalingGroupName string    `json:"autoScalingGroupName"`
+	InstanceType         string    `json:"instanceType"`
+	SpotPrice            string    `json:"spotPrice"`
+	Created              time.Time `json:"created"`
+	// PendingSwitch is set while the group's switch to this launch
+	// configuration waits for its nodes to become Ready.
+	PendingSwitch *PendingSwitch `json:"pendingSwitch,omitempty"`
+}
+
+// Registry is the ConfigMap recording the launch configurations the daemon
+// created.  A nil Registry, used when spotConfig.RegistryName is empty,
+// records nothing.
+type Registry struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+}
+
+func NewRegistry(clientset *kubernetes.Clientset, spotConfig awscode.SpotConfig) *Registry {
+	if len(spotConfig.RegistryName) == 0 {
+		return nil
+	}
+	return &Registry{clientset: clientset, namespace: spotConfig.RegistryNamespace, name: spotConfig.RegistryName}
+}
+
+// getOwner names the creator of spotConfig's launch configurations.
+func getOwner(spotConfig awscode.SpotConfig) string {
+	if len(spotConfig.Owner) > 0 {
+		return spotConfig.Owner
+	}
+	return "daemon/" + spotConfig.AutoScalingGroupName
+}
+
+// Records returns every launch configuration in the registry by name.
+func (r *Registry) Records() (map[string]LaunchConfigurationRecord, error) {
+	records := map[string]LaunchConfigurationRecord{}
+	if r == nil {
+		return records, nil
+	}
+	data, _, err := k8code.GetConfigMapData(r.clientset, r.namespace, r.name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read registry '%v/%v': %w", r.namespace, r.name, err)
+	}
+	for name, value := range data {
+		record := LaunchConfigurationRecord{}
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			slog.Warn("ignoring unparsable registry entry", "launchConfiguration", name, "error", err)
+			continue
+		}
+		records[name] = record
+	}
+	return records, nil
+}
+
+// registryAttempts bounds how often update retries after losing a race for
+// the ConfigMap to another writer.
+const registryAttempts = 5
+
+// update applies change to the registry's data.  The write only succeeds if
+// nobody wrote the ConfigMap since it was read, so concurrent writers, such as
+// the daemon and gc or several SpotPolicies' daemons, cannot undo each other's
+// changes; on a conflict the data is read again and change reapplied.
+func (r *Registry) update(change func(data map[string]string) error) error {
+	if r == nil {
+		return nil
+	}
+	var err error
+	for attempt := 0; attempt < registryAttempts; attempt++ {
+		data, resourceVersion, readErr := k8code.GetConfigMapData(r.clientset, r.namespace, r.name)
+		if readErr != nil {
+			return fmt.Errorf("could not read registry '%v/%v': %w", r.namespace, r.name, readErr)
+		}
+		if err := change(data); err != nil {
+			return err
+		}
+		err = k8code.SaveConfigMapData(r.clientset, r.namespace, r.name, data, resourceVersion)
+		if err == nil {
+			return nil
+		}
+		if !k8code.IsConflict(err) {
+			break
+		}
+		slog.Debug("registry changed while it was being written, retrying",
+			"registry", r.namespace+"/"+r.name, "attempt", attempt+1)
+	}
+	return fmt.Errorf("could not write registry '%v/%v': %w", r.namespace, r.name, err)
+}
+
+// Record adds a launch configuration to the registry.
+func (r *Registry) Record(record LaunchConfigurationRecord) error {
+	return r.update(func(data map[string]string) error {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data[record.Name] = string(value)
+		return nil
+	})
+}
+
+// Forget removes deleted launch configurations from the registry.
+func (r *Registry) Forget(names ...string) error {
+	return r.update(func(data map[string]string) error {
+		for _, name := range names {
+			delete(data, name)
+		}
+		return nil
+	})
+}
+
+// SetPendingSwitch records pending on the entry of the launch configuration
+// its group was switched to, or clears it from the named entry if pending is
+// nil.  Launch configurations without an entry are left out.
+func (r *Registry) SetPendingSwitch(name string, pending *PendingSwitch) error {
+	return r.update(func(data map[string]string) error {
+		value, found := data[name]
+		if !found {
+			return nil
+		}
+		record := LaunchConfigurationRecord{}
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return err
+		}
+		record.PendingSwitch = pending
+		updated, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data[name] = string(updated)
+		return nil
+	})
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"reflect"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/logging"
+)
+
+// ConfigWatcher reloads the daemon's configuration whenever the contents of
+// its config file change.  Polling the contents, rather than watching for file
+// events, also picks up ConfigMap volumes, which are updated by swapping a
+// symlink underneath the mounted path.
+type ConfigWatcher struct {
+	Path         string
+	Load         func() (awscode.SpotConfig, error)
+	lastContents []byte
+}
+
+func NewConfigWatcher(path string, load func() (awscode.SpotConfig, error)) *ConfigWatcher {
+	contents, _ := ioutil.ReadFile(path)
+	return &ConfigWatcher{Path: path, Load: load, lastContents: contents}
+}
+
+// Check returns the reloaded configuration and true if the config file has
+// changed since the last check and holds a valid configuration.  An invalid
+// update is reported once and the current configuration is kept.
+func (w *ConfigWatcher) Check(current awscode.SpotConfig) (awscode.SpotConfig, bool) {
+	contents, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		slog.Warn("could not read config file, keeping current configuration", "path", w.Path, "error", err)
+		return current, false
+	}
+	if bytes.Equal(contents, w.lastContents) {
+		return current, false
+	}
+	w.lastContents = contents
+
+	reloaded, err := w.Load()
+	if err != nil {
+		slog.Warn("rejected config file update, keeping current configuration", "path", w.Path, "error", err)
+		return current, false
+	}
+	reloaded, ignored := keepStartupSettings(current, reloaded)
+	if len(ignored) > 0 {
+		slog.Warn("config file update changes settings only read at startup, restart to apply them",
+			"path", w.Path, "ignored", ignored)
+	}
+	diff := awscode.DiffSpotConfig(current, reloaded)
+	if len(diff) == 0 {
+		return current, false
+	}
+	slog.Info("applied config file update", "path", w.Path, "changes", diff)
+	if err := logging.Setup(reloaded.LogFormat, reloaded.LogLevel); err != nil {
+		slog.Warn("could not apply log settings", "error", err)
+	}
+	return reloaded, true
+}
+
+// startupSettings are the SpotConfig fields RunDaemon reads once, when it
+// starts: the leader election, the listener, the Event object, the
+// notifications file, the price history file, the record directory and the
+// log format, which stays the same for the whole of a log stream.
+var startupSettings = []string{
+	"LeaderElect", "LeaderElectionNamespace", "LeaderElectionName", "ListenAddress", "EventObject",
+	"NotificationsFile", "PriceHistoryFile", "RecordDirectory", "LogFormat",
+}
+
+// keepStartupSettings returns reloaded with the startupSettings of current,
+// and the changes to them it left out.
+func keepStartupSettings(current awscode.SpotConfig, reloaded awscode.SpotConfig) (awscode.SpotConfig, []string) {
+	currentValue := reflect.ValueOf(current)
+	reloadedValue := reflect.ValueOf(&reloaded).Elem()
+	ignored := []string{}
+	for _, name := range startupSettings {
+		was, is := currentValue.FieldByName(name), reloadedValue.FieldByName(name)
+		if was.Interface() != is.Interface() {
+			ignored = append(ignored, fmt.Sprintf("%v: '%v' -> '%v'", name, was.Interface(), is.Interface()))
+			is.Set(was)
+		}
+	}
+	return reloaded, ignored
+}
@@ -0,0 +1,447 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// failedTypeExclusion is how long an instance type whose nodes never became
+// Ready is kept out of consideration for the autoscaling group it failed in.
+const failedTypeExclusion = 24 * time.Hour
+
+// sortByRecency orders launch configurations newest first, except that
+// lastUsed, the one an autoscaling group was just moved off, always comes first.
+func sortByRecency(launchConfigurations []*autoscaling.LaunchConfiguration, lastUsed string) {
+	sort.SliceStable(launchConfigurations, func(i, j int) bool {
+		if *launchConfigurations[i].LaunchConfigurationName == lastUsed {
+			return true
+		}
+		if *launchConfigurations[j].LaunchConfigurationName == lastUsed {
+			return false
+		}
+		return aws.TimeValue(launchConfigurations[i].CreatedTime).After(aws.TimeValue(launchConfigurations[j].CreatedTime))
+	})
+}
+
+// RollbackAutoScalingGroup re-points spotConfig.AutoScalingGroupName at an
+// earlier launch configuration: launchConfigurationName if given, otherwise the
+// newest of the prefix's launch configurations that the group is not using.
+func RollbackAutoScalingGroup(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	launchConfigurationName string, monitor bool) error {
+
+	autoscalingGroup, err := awscode.GetAutoscaler(ctx, sess, spotConfig.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+	launchConfigurations, err := awscode.GetLaunchConfigurations(ctx, sess, spotConfig.LaunchConfigurationPrefix)
+	if err != nil {
+		return err
+	}
+	current := aws.StringValue(autoscalingGroup.LaunchConfigurationName)
+	sortByRecency(launchConfigurations, "")
+
+	fmt.Printf("\nLaunchconfiguration history of autoscalinggroup '%v':\n", spotConfig.AutoScalingGroupName)
+	var target *autoscaling.LaunchConfiguration
+	for _, lc := range launchConfigurations {
+		marker := " "
+		if *lc.LaunchConfigurationName == current {
+			marker = "*"
+		}
+		fmt.Printf("  %v %v || InstanceType: %12v | SpotPrice: %6v | Created: %v\n", marker,
+			*lc.LaunchConfigurationName, *lc.InstanceType, describeBid(lc),
+			aws.TimeValue(lc.CreatedTime).Format(time.RFC3339))
+		if target != nil || *lc.LaunchConfigurationName == current {
+			continue
+		}
+		if len(launchConfigurationName) == 0 || *lc.LaunchConfigurationName == launchConfigurationName {
+			target = lc
+		}
+	}
+	if target == nil {
+		if len(launchConfigurationName) > 0 {
+			return fmt.Errorf("launchconfiguration '%v' is not an earlier configuration of prefix '%v'",
+				launchConfigurationName, spotConfig.LaunchConfigurationPrefix)
+		}
+		return fmt.Errorf("no earlier launchconfiguration of prefix '%v' to roll back to", spotConfig.LaunchConfigurationPrefix)
+	}
+	return repointAutoScalingGroup(ctx, sess, spotConfig.AutoScalingGroupName, *target.LaunchConfigurationName, monitor)
+}
+
+func repointAutoScalingGroup(ctx context.Context, sess *session.Session, autoScalingGroupName string,
+	launchConfigurationName string, monitor bool) error {
+
+	updateAutoScalingGroupInput := autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName:    aws.String(autoScalingGroupName),
+		LaunchConfigurationName: aws.String(launchConfigurationName)}
+	slog.Warn("rolling back autoscaling group", "autoScalingGroup", autoScalingGroupName,
+		"launchConfiguration", launchConfigurationName, "monitor", monitor)
+	if monitor {
+		return nil
+	}
+	return awscode.UpdateAutoScalingGroup(ctx, sess, &updateAutoScalingGroupInput)
+}
+
+// PendingSwitch is a turnover whose new nodes have not all become Ready yet,
+// with when each of them was first seen.  Decision is kept without the inputs
+// it was made from.
+type PendingSwitch struct {
+	Decision  Decision             `json:"decision"`
+	FirstSeen map[string]time.Time `json:"firstSeen"`
+	// Failed is when the switch's nodes were found not Ready.  A failed switch
+	// is no longer followed, but stays recorded while its instance type is
+	// kept out of the group, so that neither a restart nor the next leader
+	// switches straight back to it.
+	Failed *time.Time `json:"failed,omitempty"`
+}
+
+// SwitchWatcher follows each turnover until every node launched from the new
+// launch configuration is Ready, and rolls the autoscaling group back to its
+// previous launch configuration if one stays NotReady past ReadyTimeoutSeconds.
+// Pending and failed switches are kept on their launch configuration's
+// registry entry, so that they are still followed, and failed instance types
+// still excluded, after a restart or by the next leader.
+type SwitchWatcher struct {
+	pending map[string]*PendingSwitch
+	failed  map[string]map[string]time.Time
+}
+
+func NewSwitchWatcher() *SwitchWatcher {
+	return &SwitchWatcher{
+		pending: map[string]*PendingSwitch{},
+		failed:  map[string]map[string]time.Time{}}
+}
+
+// Watch starts following decision if it turned the group's nodes over.
+func (w *SwitchWatcher) Watch(decision Decision, registry *Registry, monitor bool) {
+	if !decision.TurnedOver() || len(decision.NewLaunchConfigurationName) == 0 {
+		return
+	}
+	if _, found := w.pending[decision.AutoScalingGroupName]; found {
+		// The group was switched again before the previous switch was verified.
+		w.finish(registry, decision.AutoScalingGroupName, monitor)
+	}
+	decision.Demand, decision.Candidates = nil, nil
+	pending := &PendingSwitch{Decision: decision, FirstSeen: map[string]time.Time{}}
+	w.pending[decision.AutoScalingGroupName] = pending
+	w.save(registry, pending, monitor)
+}
+
+// save records pending in the registry.  Monitor mode only follows switches
+// in memory, leaving the registry to the replica that made them.
+func (w *SwitchWatcher) save(registry *Registry, pending *PendingSwitch, monitor bool) {
+	if monitor {
+		return
+	}
+	if err := registry.SetPendingSwitch(pending.Decision.NewLaunchConfigurationName, pending); err != nil {
+		slog.Warn("could not record pending switch", "autoScalingGroup", pending.Decision.AutoScalingGroupName,
+			"error", err)
+	}
+}
+
+// fail stops following the switch of groupName, whose nodes were found not
+// Ready at failedAt, and keeps its instance type out of the group for
+// failedTypeExclusion.
+func (w *SwitchWatcher) fail(registry *Registry, groupName string, failedAt time.Time, monitor bool) {
+	pending := w.pending[groupName]
+	delete(w.pending, groupName)
+	w.exclude(groupName, pending.Decision.NewInstanceType, failedAt)
+	if monitor {
+		return
+	}
+	pending.Failed = &failedAt
+	if err := registry.SetPendingSwitch(pending.Decision.NewLaunchConfigurationName, pending); err != nil {
+		slog.Warn("could not record failed switch", "autoScalingGroup", groupName, "error", err)
+	}
+}
+
+// exclude keeps instanceType out of groupName for failedTypeExclusion after
+// failedAt.
+func (w *SwitchWatcher) exclude(groupName string, instanceType string, failedAt time.Time) {
+	if w.failed[groupName] == nil {
+		w.failed[groupName] = map[string]time.Time{}
+	}
+	if failedAt.After(w.failed[groupName][instanceType]) {
+		w.failed[groupName][instanceType] = failedAt
+	}
+}
+
+// finish stops following the switch of groupName.
+func (w *SwitchWatcher) finish(registry *Registry, groupName string, monitor bool) {
+	pending := w.pending[groupName]
+	delete(w.pending, groupName)
+	if monitor {
+		return
+	}
+	if err := registry.SetPendingSwitch(pending.Decision.NewLaunchConfigurationName, nil); err != nil {
+		slog.Warn("could not clear pending switch", "autoScalingGroup", groupName, "error", err)
+	}
+}
+
+// watchesOwner reports whether a daemon running with spotConfig follows the
+// switches of owner: its own, or those of the SpotPolicies it watches.
+func watchesOwner(spotConfig awscode.SpotConfig, owner string) bool {
+	if !spotConfig.WatchSpotPolicies {
+		return owner == getOwner(spotConfig)
+	}
+	prefix := "spotpolicy/"
+	if len(spotConfig.SpotPolicyNamespace) > 0 {
+		prefix += spotConfig.SpotPolicyNamespace + "/"
+	}
+	return strings.HasPrefix(owner, prefix)
+}
+
+// restore takes up the switches recorded in the registry, and clears the
+// failed ones whose exclusion has expired.
+func (w *SwitchWatcher) restore(registry *Registry, spotConfig awscode.SpotConfig, monitor bool) {
+	records, err := registry.Records()
+	if err != nil {
+		slog.Warn("could not read pending switches", "error", err)
+		return
+	}
+	for _, name := range w.follow(records, spotConfig, time.Now()) {
+		if monitor {
+			break
+		}
+		if err := registry.SetPendingSwitch(name, nil); err != nil {
+			slog.Warn("could not clear failed switch", "launchConfiguration", name, "error", err)
+		}
+	}
+}
+
+// follow takes up the switches in records that a daemon running with
+// spotConfig watches: the pending ones it does not follow yet, such as those
+// made before a restart or by a previous leader, and the exclusions of the
+// failed ones.  It returns the launch configurations whose failed switch no
+// longer excludes anything at now.
+func (w *SwitchWatcher) follow(records map[string]LaunchConfigurationRecord, spotConfig awscode.SpotConfig,
+	now time.Time) []string {
+
+	expired := []string{}
+	for name, record := range records {
+		pending := record.PendingSwitch
+		if pending == nil || !watchesOwner(spotConfig, record.Owner) {
+			continue
+		}
+		groupName := pending.Decision.AutoScalingGroupName
+		if pending.Failed != nil {
+			if now.Sub(*pending.Failed) < failedTypeExclusion {
+				w.exclude(groupName, pending.Decision.NewInstanceType, *pending.Failed)
+			} else {
+				expired = append(expired, name)
+			}
+			continue
+		}
+		if _, found := w.pending[groupName]; found {
+			continue
+		}
+		if pending.FirstSeen == nil {
+			pending.FirstSeen = map[string]time.Time{}
+		}
+		slog.Info("following recorded pending switch", "autoScalingGroup", groupName,
+			"launchConfiguration", name)
+		w.pending[groupName] = pending
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// rollbackTargets returns the launch configurations named by the switches in
+// records: those switched to and the ones a rollback would return to.  They
+// are kept out of cleanup, so that a rollback always has a target and a failed
+// switch's exclusion outlives the next switch.
+func rollbackTargets(records map[string]LaunchConfigurationRecord) map[string]bool {
+	targets := map[string]bool{}
+	for _, record := range records {
+		if pending := record.PendingSwitch; pending != nil {
+			targets[pending.Decision.NewLaunchConfigurationName] = true
+			targets[pending.Decision.OriginalLaunchConfigurationName] = true
+		}
+	}
+	delete(targets, "")
+	return targets
+}
+
+// ExcludeFailedTypes drops the instance types whose switch failed in
+// autoScalingGroupName recently from priceList.
+func (w *SwitchWatcher) ExcludeFailedTypes(autoScalingGroupName string, priceList []pricing.FullSummary) []pricing.FullSummary {
+	return w.excludeFailedTypes(autoScalingGroupName, priceList, time.Now())
+}
+
+func (w *SwitchWatcher) excludeFailedTypes(autoScalingGroupName string, priceList []pricing.FullSummary,
+	now time.Time) []pricing.FullSummary {
+
+	failed := w.failed[autoScalingGroupName]
+	filtered := []pricing.FullSummary{}
+	for _, instanceSummary := range priceList {
+		if failedAt, found := failed[instanceSummary.Name]; found && now.Sub(failedAt) < failedTypeExclusion {
+			continue
+		}
+		filtered = append(filtered, instanceSummary)
+	}
+	return filtered
+}
+
+// rollbackDecision describes rolling a group back from the launch
+// configuration of switch to the one it ran before.
+func rollbackDecision(switched Decision, reason string, monitor bool) Decision {
+	return Decision{
+		Time:                            time.Now(),
+		AutoScalingGroupName:            switched.AutoScalingGroupName,
+		Outcome:                         DecisionRolledBack,
+		Reason:                          reason,
+		OriginalLaunchConfigurationName: switched.NewLaunchConfigurationName,
+		OriginalInstanceType:            switched.NewInstanceType,
+		OriginalSpotPrice:               switched.NewSpotPrice,
+		OriginalDollarsPerHour:          switched.NewDollarsPerHour,
+		NewLaunchConfigurationName:      switched.OriginalLaunchConfigurationName,
+		NewInstanceType:                 switched.OriginalInstanceType,
+		NewSpotPrice:                    switched.OriginalSpotPrice,
+		NewDollarsPerHour:               switched.OriginalDollarsPerHour,
+		Monitor:                         monitor}
+}
+
+// switchProgress is what one look at a pending switch found.
+type switchProgress struct {
+	// Superseded is set if the group no longer runs the switch's launch
+	// configuration, and Verified once every one of its nodes is on it and
+	// Ready.
+	Superseded bool
+	Verified   bool
+	// Seen is set if nodes of the switch were seen for the first time, and
+	// Failing lists those NotReady for longer than the ready timeout.
+	Seen    bool
+	Failing []string
+}
+
+// inspectSwitch looks at the nodes of autoscalingGroup at now, noting when
+// each node of pending's launch configuration was first seen.
+func inspectSwitch(pending *PendingSwitch, autoscalingGroup *autoscaling.Group, readyInstances map[string]bool,
+	readyTimeout time.Duration, now time.Time) switchProgress {
+
+	progress := switchProgress{Failing: []string{}}
+	if aws.StringValue(autoscalingGroup.LaunchConfigurationName) != pending.Decision.NewLaunchConfigurationName {
+		progress.Superseded = true
+		return progress
+	}
+	launched, ready := 0, 0
+	for _, instance := range autoscalingGroup.Instances {
+		if aws.StringValue(instance.LaunchConfigurationName) != pending.Decision.NewLaunchConfigurationName {
+			continue
+		}
+		instanceID := aws.StringValue(instance.InstanceId)
+		launched++
+		if _, found := pending.FirstSeen[instanceID]; !found {
+			pending.FirstSeen[instanceID] = now
+			progress.Seen = true
+		}
+		if readyInstances[instanceID] {
+			ready++
+		} else if now.Sub(pending.FirstSeen[instanceID]) > readyTimeout {
+			progress.Failing = append(progress.Failing, instanceID)
+		}
+	}
+	progress.Verified = len(progress.Failing) == 0 && launched > 0 && ready == launched &&
+		len(autoscalingGroup.Instances) == launched
+	return progress
+}
+
+// Check looks at every pending switch, rolling back those whose new nodes did
+// not become Ready in time, and returns a decision for each rollback.  A
+// rollback that failed transiently is returned as apply-failed and tried
+// again next time; one that cannot succeed, such as one whose launch
+// configuration is gone, is returned as apply-failed once and the switch is
+// given up.  Either way the failed instance type is kept out of the group.  A
+// group that cannot be checked is reported in the error and does not stop
+// the others.
+func (w *SwitchWatcher) Check(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset,
+	spotConfig awscode.SpotConfig, registry *Registry, monitor bool) ([]Decision, error) {
+
+	rollbacks := []Decision{}
+	w.restore(registry, spotConfig, monitor)
+	if len(w.pending) == 0 {
+		return rollbacks, nil
+	}
+	readyInstances, err := k8code.GetReadyInstanceIDs(clientset)
+	if err != nil {
+		return rollbacks, err
+	}
+	readyTimeout := time.Second * time.Duration(spotConfig.ReadyTimeoutSeconds)
+	errs := []error{}
+	for groupName, pending := range w.pending {
+		autoscalingGroup, err := awscode.GetAutoscaler(ctx, sess, groupName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not check autoscalinggroup '%v': %w", groupName, err))
+			continue
+		}
+		now := time.Now()
+		progress := inspectSwitch(pending, autoscalingGroup, readyInstances, readyTimeout, now)
+		switch {
+		case progress.Superseded:
+			// Something else has changed the group since, so there is nothing left to verify.
+			w.finish(registry, groupName, monitor)
+			continue
+		case progress.Verified:
+			slog.Info("every node on the new launch configuration is Ready", "autoScalingGroup", groupName,
+				"launchConfiguration", pending.Decision.NewLaunchConfigurationName)
+			w.finish(registry, groupName, monitor)
+			continue
+		case len(progress.Failing) == 0:
+			if progress.Seen {
+				w.save(registry, pending, monitor)
+			}
+			continue
+		}
+
+		failing := progress.Failing
+		slog.Warn("nodes did not become Ready in time", "autoScalingGroup", groupName,
+			"instanceIds", failing, "readyTimeoutSeconds", int(spotConfig.ReadyTimeoutSeconds))
+		rollback := rollbackDecision(pending.Decision, fmt.Sprintf("%v nodes from launch configuration '%v' were not Ready within %v seconds",
+			len(failing), pending.Decision.NewLaunchConfigurationName, int(spotConfig.ReadyTimeoutSeconds)), monitor)
+		target, err := awscode.DescribeLaunchConfiguration(ctx, sess, pending.Decision.OriginalLaunchConfigurationName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not roll back autoscalinggroup '%v': %w", groupName, err))
+			continue
+		}
+		if target == nil {
+			rollback.Outcome = DecisionApplyFailed
+			rollback.Reason = fmt.Sprintf("could not roll back autoscalinggroup '%v': launch configuration '%v' no longer exists",
+				groupName, pending.Decision.OriginalLaunchConfigurationName)
+			w.fail(registry, groupName, now, monitor)
+			rollbacks = append(rollbacks, rollback)
+			continue
+		}
+		if err := repointAutoScalingGroup(ctx, sess, groupName, pending.Decision.OriginalLaunchConfigurationName, monitor); err != nil {
+			rollback.Outcome = DecisionApplyFailed
+			rollback.Reason = fmt.Sprintf("could not roll back autoscalinggroup '%v': %v", groupName, err)
+			if !awscode.IsTransient(err) {
+				w.fail(registry, groupName, now, monitor)
+			}
+			rollbacks = append(rollbacks, rollback)
+			continue
+		}
+		if !monitor {
+			for _, instanceID := range failing {
+				if err := awscode.TerminateInstanceInAutoScalingGroup(ctx, sess, instanceID); err != nil {
+					slog.Warn("could not terminate instance", "instanceId", instanceID, "error", err)
+				}
+			}
+		}
+		w.fail(registry, groupName, now, monitor)
+		rollbacks = append(rollbacks, rollback)
+	}
+	return rollbacks, errors.Join(errs...)
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func switchDecision(groupName string, from string, to string, instanceType string) Decision {
+	return Decision{AutoScalingGroupName: groupName, Outcome: DecisionSwitch,
+		OriginalLaunchConfigurationName: from, NewLaunchConfigurationName: to, NewInstanceType: instanceType}
+}
+
+func TestInspectSwitch(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	instance := func(id string, launchConfigurationName string) *autoscaling.Instance {
+		return &autoscaling.Instance{InstanceId: aws.String(id), LaunchConfigurationName: aws.String(launchConfigurationName)}
+	}
+	group := func(launchConfigurationName string, instances ...*autoscaling.Instance) *autoscaling.Group {
+		return &autoscaling.Group{LaunchConfigurationName: aws.String(launchConfigurationName), Instances: instances}
+	}
+	cases := []struct {
+		name      string
+		group     *autoscaling.Group
+		firstSeen map[string]time.Time
+		ready     map[string]bool
+		want      switchProgress
+	}{
+		{"superseded", group("other", instance("i-1", "other")), nil, nil,
+			switchProgress{Superseded: true, Failing: []string{}}},
+		{"no new nodes yet", group("new", instance("i-1", "old")), nil, map[string]bool{"i-1": true},
+			switchProgress{Failing: []string{}}},
+		{"new nodes seen", group("new", instance("i-1", "old"), instance("i-2", "new")), nil, nil,
+			switchProgress{Seen: true, Failing: []string{}}},
+		{"new nodes Ready but old ones left", group("new", instance("i-1", "old"), instance("i-2", "new")),
+			map[string]time.Time{"i-2": now.Add(-time.Hour)}, map[string]bool{"i-2": true},
+			switchProgress{Failing: []string{}}},
+		{"verified", group("new", instance("i-2", "new"), instance("i-3", "new")),
+			map[string]time.Time{"i-2": now.Add(-time.Hour)}, map[string]bool{"i-2": true, "i-3": true},
+			switchProgress{Verified: true, Seen: true, Failing: []string{}}},
+		{"NotReady within the timeout", group("new", instance("i-2", "new")),
+			map[string]time.Time{"i-2": now.Add(-5 * time.Minute)}, nil,
+			switchProgress{Failing: []string{}}},
+		{"NotReady past the timeout", group("new", instance("i-2", "new"), instance("i-3", "new")),
+			map[string]time.Time{"i-2": now.Add(-20 * time.Minute), "i-3": now.Add(-20 * time.Minute)},
+			map[string]bool{"i-3": true}, switchProgress{Failing: []string{"i-2"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pending := &PendingSwitch{Decision: switchDecision("nodes", "old", "new", "r5.xlarge"),
+				FirstSeen: map[string]time.Time{}}
+			for id, seen := range c.firstSeen {
+				pending.FirstSeen[id] = seen
+			}
+			got := inspectSwitch(pending, c.group, c.ready, 10*time.Minute, now)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("inspectSwitch = %+v, want %+v", got, c.want)
+			}
+			for _, instance := range c.group.Instances {
+				if *instance.LaunchConfigurationName == "new" && !c.want.Superseded && pending.FirstSeen[*instance.InstanceId].IsZero() {
+					t.Errorf("%v was not noted as seen", *instance.InstanceId)
+				}
+			}
+		})
+	}
+}
+
+func TestSwitchWatcherWatch(t *testing.T) {
+	watcher := NewSwitchWatcher()
+	bidChange := switchDecision("nodes", "old", "new", "r4.xlarge")
+	bidChange.Outcome = DecisionBidChange
+	monitored := switchDecision("nodes", "old", "new", "r5.xlarge")
+	monitored.Monitor = true
+	for _, decision := range []Decision{bidChange, monitored, switchDecision("nodes", "old", "", "r5.xlarge")} {
+		watcher.Watch(decision, nil, false)
+	}
+	if len(watcher.pending) > 0 {
+		t.Fatalf("followed %v, want nothing", watcher.pending)
+	}
+
+	first := switchDecision("nodes", "old", "first", "r5.xlarge")
+	first.Demand = map[string]float64{"totalMemoryRequestedGB": 60}
+	first.Candidates = []Candidate{{InstanceType: "r5.xlarge"}}
+	watcher.Watch(first, nil, false)
+	pending := watcher.pending["nodes"]
+	if pending == nil || pending.Decision.Demand != nil || pending.Decision.Candidates != nil {
+		t.Fatalf("following %+v, want the switch without its inputs", pending)
+	}
+	watcher.Watch(switchDecision("nodes", "first", "second", "m5.xlarge"), nil, false)
+	if got := watcher.pending["nodes"].Decision.NewLaunchConfigurationName; got != "second" || len(watcher.pending) != 1 {
+		t.Errorf("following %v, want only the second switch", got)
+	}
+
+	watcher.fail(nil, "nodes", time.Now(), false)
+	if len(watcher.pending) > 0 {
+		t.Errorf("still following %v after it failed", watcher.pending)
+	}
+}
+
+func TestExcludeFailedTypes(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	watcher := NewSwitchWatcher()
+	watcher.exclude("nodes", "r5.xlarge", now.Add(-time.Hour))
+	watcher.exclude("nodes", "m5.xlarge", now.Add(-25*time.Hour))
+	watcher.exclude("nodes", "m5.xlarge", now.Add(-26*time.Hour))
+	watcher.exclude("other", "r4.xlarge", now)
+	priceList := []pricing.FullSummary{{Name: "r4.xlarge"}, {Name: "r5.xlarge"}, {Name: "m5.xlarge"}}
+
+	names := func(priceList []pricing.FullSummary) []string {
+		names := []string{}
+		for _, instanceSummary := range priceList {
+			names = append(names, instanceSummary.Name)
+		}
+		return names
+	}
+	if got := names(watcher.excludeFailedTypes("nodes", priceList, now)); !reflect.DeepEqual(got, []string{"r4.xlarge", "m5.xlarge"}) {
+		t.Errorf("excludeFailedTypes(nodes) = %v, want [r4.xlarge m5.xlarge]", got)
+	}
+	if got := names(watcher.excludeFailedTypes("other", priceList, now)); !reflect.DeepEqual(got, []string{"r5.xlarge", "m5.xlarge"}) {
+		t.Errorf("excludeFailedTypes(other) = %v, want [r5.xlarge m5.xlarge]", got)
+	}
+}
+
+func TestSwitchWatcherFollow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	spotConfig := awscode.DefaultSpotConfig()
+	spotConfig.AutoScalingGroupName = "nodes"
+	recentFailure, oldFailure := now.Add(-time.Hour), now.Add(-25*time.Hour)
+	records := map[string]LaunchConfigurationRecord{
+		"pending": {Owner: "daemon/nodes", PendingSwitch: &PendingSwitch{
+			Decision: switchDecision("nodes", "old", "pending", "r5.xlarge")}},
+		"failed": {Owner: "daemon/nodes", PendingSwitch: &PendingSwitch{
+			Decision: switchDecision("nodes", "old", "failed", "m5.xlarge"), Failed: &recentFailure}},
+		"expired": {Owner: "daemon/nodes", PendingSwitch: &PendingSwitch{
+			Decision: switchDecision("nodes", "old", "expired", "c5.xlarge"), Failed: &oldFailure}},
+		"others": {Owner: "daemon/other", PendingSwitch: &PendingSwitch{
+			Decision: switchDecision("other", "old", "others", "r4.xlarge")}},
+		"settled": {Owner: "daemon/nodes"},
+	}
+
+	watcher := NewSwitchWatcher()
+	expired := watcher.follow(records, spotConfig, now)
+	if !reflect.DeepEqual(expired, []string{"expired"}) {
+		t.Errorf("expired = %v, want [expired]", expired)
+	}
+	if len(watcher.pending) != 1 || watcher.pending["nodes"].Decision.NewLaunchConfigurationName != "pending" ||
+		watcher.pending["nodes"].FirstSeen == nil {
+		t.Errorf("following %v, want the pending switch of nodes", watcher.pending)
+	}
+	if !reflect.DeepEqual(watcher.failed, map[string]map[string]time.Time{"nodes": {"m5.xlarge": recentFailure}}) {
+		t.Errorf("failed = %v, want m5.xlarge in nodes", watcher.failed)
+	}
+
+	// A switch already followed is not replaced by its recorded copy.
+	followed := watcher.pending["nodes"]
+	watcher.follow(records, spotConfig, now)
+	if watcher.pending["nodes"] != followed {
+		t.Errorf("the followed switch was replaced")
+	}
+
+	spotConfig.WatchSpotPolicies = true
+	records["policy"] = LaunchConfigurationRecord{Owner: "spotpolicy/default/workers", PendingSwitch: &PendingSwitch{
+		Decision: switchDecision("workers", "old", "policy", "r5.xlarge")}}
+	watcher = NewSwitchWatcher()
+	watcher.follow(records, spotConfig, now)
+	groups := []string{}
+	for groupName := range watcher.pending {
+		groups = append(groups, groupName)
+	}
+	sort.Strings(groups)
+	if !reflect.DeepEqual(groups, []string{"workers"}) {
+		t.Errorf("following %v with SpotPolicies, want [workers]", groups)
+	}
+}
+
+func TestRollbackTargets(t *testing.T) {
+	failedAt := time.Now()
+	records := map[string]LaunchConfigurationRecord{
+		"nodes-b": {PendingSwitch: &PendingSwitch{Decision: switchDecision("nodes", "nodes-a", "nodes-b", "r5.xlarge")}},
+		"nodes-d": {PendingSwitch: &PendingSwitch{Decision: switchDecision("nodes", "nodes-c", "nodes-d", "m5.xlarge"),
+			Failed: &failedAt}},
+		"nodes-e": {},
+		"nodes-f": {PendingSwitch: &PendingSwitch{Decision: switchDecision("nodes", "", "nodes-f", "c5.xlarge")}},
+	}
+	want := map[string]bool{"nodes-a": true, "nodes-b": true, "nodes-c": true, "nodes-d": true, "nodes-f": true}
+	if got := rollbackTargets(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("rollbackTargets = %v, want %v", got, want)
+	}
+}
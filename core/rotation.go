@@ -0,0 +1,211 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+func getStaleInstanceIDs(autoscalingGroup *autoscaling.Group, launchConfigurationName string) []string {
+	staleInstanceIDs := []string{}
+	for _, instance := range autoscalingGroup.Instances {
+		if instance.LaunchConfigurationName == nil || *instance.LaunchConfigurationName != launchConfigurationName {
+			staleInstanceIDs = append(staleInstanceIDs, *instance.InstanceId)
+		}
+	}
+	return staleInstanceIDs
+}
+
+// countReadyReplacements counts the autoscaling group's instances launched
+// from launchConfigurationName whose node reports Ready.
+func countReadyReplacements(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset,
+	autoScalingGroupName string, launchConfigurationName string) (int, error) {
+
+	autoscalingGroup, err := awscode.GetAutoscaler(ctx, sess, autoScalingGroupName)
+	if err != nil {
+		return 0, err
+	}
+	readyInstances, err := k8code.GetReadyInstanceIDs(clientset)
+	if err != nil {
+		return 0, err
+	}
+	ready := 0
+	for _, instance := range autoscalingGroup.Instances {
+		if aws.StringValue(instance.LaunchConfigurationName) == launchConfigurationName &&
+			readyInstances[aws.StringValue(instance.InstanceId)] {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+// waitForReadyReplacements waits until count of the autoscaling group's
+// instances launched from launchConfigurationName are Ready, the timeout
+// expires or ctx is cancelled.
+func waitForReadyReplacements(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset,
+	autoScalingGroupName string, launchConfigurationName string, count int, timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, err := countReadyReplacements(ctx, sess, clientset, autoScalingGroupName, launchConfigurationName)
+		if err != nil {
+			return err
+		}
+		if ready >= count {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %v ready nodes from launch configuration '%v' (%v ready)",
+				count, launchConfigurationName, ready)
+		}
+		daemonStatus.beat()
+		if !sleepContext(ctx, 10*time.Second) {
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForDisruptionBudgets waits until no PodDisruptionBudget covering the pods
+// on instanceIDs is blocking evictions, and reports the budget to blame if one
+// still is once the timeout expires.
+func waitForDisruptionBudgets(ctx context.Context, clientset *kubernetes.Clientset, instanceIDs []string,
+	timeout time.Duration) (k8code.DisruptionReport, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		report, err := checkDisruptionBudgets(clientset, instanceIDs)
+		if err != nil {
+			return report, err
+		}
+		blocking := report.Blocking()
+		if len(blocking) == 0 {
+			return report, nil
+		}
+		if !time.Now().Before(deadline) {
+			logDisruptionReport(report)
+			return report, fmt.Errorf("PodDisruptionBudget '%v' allows no disruptions of its %v affected pods",
+				blocking[0].Name, blocking[0].AffectedPods)
+		}
+		if !sleepContext(ctx, 10*time.Second) {
+			return report, ctx.Err()
+		}
+	}
+}
+
+// RotateNodes replaces the autoscaling group's instances that were not launched
+// from launchConfigurationName, RotationBatchSize at a time.  Each node in a
+// batch is cordoned, drained through the Eviction API and terminated, and the
+// next batch only starts once the replacements have become Ready.  A batch
+// waits while a PodDisruptionBudget blocks it and shrinks to a single node
+// while one would be violated by draining the whole batch.
+func RotateNodes(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset, spotConfig awscode.SpotConfig,
+	launchConfigurationName string, monitor bool) error {
+
+	autoscalingGroup, err := awscode.GetAutoscaler(ctx, sess, spotConfig.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+	// Replacements are launched from whatever the group points at, so
+	// rotating before it points at the new launch configuration would only
+	// churn nodes.
+	if current := aws.StringValue(autoscalingGroup.LaunchConfigurationName); !monitor && current != launchConfigurationName {
+		return fmt.Errorf("autoscaling group uses launch configuration '%v', not '%v'", current, launchConfigurationName)
+	}
+	staleInstanceIDs := getStaleInstanceIDs(autoscalingGroup, launchConfigurationName)
+	if len(staleInstanceIDs) == 0 {
+		slog.Info("no nodes left on a previous launch configuration to rotate")
+		return nil
+	}
+
+	nodeNames, err := k8code.GetNodeNamesByInstanceID(clientset)
+	if err != nil {
+		return err
+	}
+
+	batchSize := spotConfig.RotationBatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	drainTimeout := time.Second * time.Duration(spotConfig.DrainTimeoutSeconds)
+	readyTimeout := time.Second * time.Duration(spotConfig.ReadyTimeoutSeconds)
+
+	slog.Info("rotating nodes", "nodes", len(staleInstanceIDs), "batchSize", batchSize)
+	for start := 0; start < len(staleInstanceIDs); {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		daemonStatus.beat()
+		end := start + batchSize
+		if end > len(staleInstanceIDs) {
+			end = len(staleInstanceIDs)
+		}
+		budgetTimeout := drainTimeout
+		if monitor {
+			budgetTimeout = 0
+		}
+		report, err := waitForDisruptionBudgets(ctx, clientset, staleInstanceIDs[start:end], budgetTimeout)
+		if err != nil {
+			return err
+		}
+		if limiting := report.Limiting(); len(limiting) > 0 && end-start > 1 {
+			slog.Info("PodDisruptionBudget limits the rotation, replacing one node at a time",
+				"podDisruptionBudget", limiting[0].Name)
+			end = start + 1
+		}
+		readyBefore, err := countReadyReplacements(ctx, sess, clientset, spotConfig.AutoScalingGroupName,
+			launchConfigurationName)
+		if err != nil {
+			return err
+		}
+
+		for _, instanceID := range staleInstanceIDs[start:end] {
+			nodeName, found := nodeNames[instanceID]
+			slog.Info("replacing node", "instanceId", instanceID, "node", nodeName, "monitor", monitor)
+			if monitor {
+				continue
+			}
+			// Losing leadership cancels ctx; no node is touched after that.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			daemonStatus.beat()
+			if found {
+				if err := k8code.CordonNode(clientset, nodeName); err != nil {
+					return err
+				}
+				if err := k8code.DrainNode(ctx, clientset, nodeName, drainTimeout); err != nil {
+					slog.Warn("drain stopped, uncordoning node", "node", nodeName, "error", err)
+					if uncordonErr := k8code.UncordonNode(clientset, nodeName); uncordonErr != nil {
+						slog.Error("could not uncordon node", "node", nodeName, "error", uncordonErr)
+					}
+					return err
+				}
+			}
+			if err := awscode.TerminateInstanceInAutoScalingGroup(ctx, sess, instanceID); err != nil {
+				return err
+			}
+		}
+
+		if !monitor {
+			readyAfter := readyBefore + end - start
+			slog.Info("waiting for ready replacement nodes", "nodes", readyAfter,
+				"launchConfiguration", launchConfigurationName)
+			daemonStatus.beat()
+			if err := waitForReadyReplacements(ctx, sess, clientset, spotConfig.AutoScalingGroupName,
+				launchConfigurationName, readyAfter, readyTimeout); err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return nil
+}
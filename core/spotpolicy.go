@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/history"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+	"github.com/davidboren/k8-spot-daemon/metrics"
+	"github.com/davidboren/k8-spot-daemon/notify"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func overrideFloat(target *float64, value *float64) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func overrideInt(target *int, value *int) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+// SpotConfigForPolicy overlays a SpotPolicy's spec on the daemon's own
+// configuration, which supplies every setting the policy leaves unset.  The
+// result is the configuration of a single group, so that Validate requires the
+// policy to name its group and launch configuration prefix.
+func SpotConfigForPolicy(base awscode.SpotConfig, policy k8code.SpotPolicy) awscode.SpotConfig {
+	spotConfig := base
+	spotConfig.WatchSpotPolicies = false
+	spec := policy.Spec
+	spotConfig.AutoScalingGroupName = spec.AutoScalingGroupName
+	spotConfig.LaunchConfigurationPrefix = spec.LaunchConfigurationPrefix
+	spotConfig.Owner = "spotpolicy/" + policy.Namespace + "/" + policy.Name
+
+	overrideFloat(&spotConfig.MinGB, spec.Constraints.MinGB)
+	overrideFloat(&spotConfig.MaxCV, spec.Constraints.MaxCV)
+	overrideFloat(&spotConfig.MaxDollarsPerGB, spec.Constraints.MaxDollarsPerGB)
+	overrideFloat(&spotConfig.MaxDollarsPerCPU, spec.Constraints.MaxDollarsPerCPU)
+	overrideFloat(&spotConfig.MemoryBufferPercentage, spec.Constraints.MemoryBufferPercentage)
+	overrideInt(&spotConfig.MaxInterruptionBucket, spec.Constraints.MaxInterruptionBucket)
+
+	overrideFloat(&spotConfig.MaxTotalDollarsPerHour, spec.Budgets.MaxTotalDollarsPerHour)
+	overrideInt(&spotConfig.MaxAutoscalingNodes, spec.Budgets.MaxAutoscalingNodes)
+	overrideFloat(&spotConfig.SwitchingCostPerNode, spec.Budgets.SwitchingCostPerNode)
+	overrideFloat(&spotConfig.AmortizationHours, spec.Budgets.AmortizationHours)
+
+	overrideFloat(&spotConfig.HistoricalHours, spec.BidStrategy.HistoricalHours)
+	overrideFloat(&spotConfig.MinMarkupPercentage, spec.BidStrategy.MinMarkupPercentage)
+	overrideFloat(&spotConfig.MinPriceDifferencePercentage, spec.BidStrategy.MinPriceDifferencePercentage)
+	return spotConfig
+}
+
+// evaluatePolicy runs a single evaluation for one policy's configuration from
+// the inputs shared by every policy, narrowed to its own historical window.
+func evaluatePolicy(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset, spotConfig awscode.SpotConfig,
+	sharedInputs pricing.Inputs, podSummary map[string]float64, switchWatcher *SwitchWatcher, recorder *Recorder,
+	monitor bool) (Decision, error) {
+
+	if err := spotConfig.Validate(); err != nil {
+		return Decision{}, err
+	}
+	inputs := sharedInputs.Within(time.Duration(spotConfig.HistoricalHours * float64(time.Hour)))
+	allPrices, err := pricing.DescribePricing(spotConfig, inputs)
+	if err != nil {
+		daemonStatus.recordCheck(checkPricing, err)
+		return Decision{}, err
+	}
+	metrics.RecordPricing(allPrices)
+	daemonStatus.recordPricing(allPrices)
+	priceList := switchWatcher.ExcludeFailedTypes(spotConfig.AutoScalingGroupName, allPrices)
+	snapshot := recorder.Start(spotConfig, inputs, allPrices, priceList, podSummary, monitor)
+	decision, err := CheckAndUpdate(ctx, sess, spotConfig, priceList, podSummary, clientset, snapshot, monitor)
+	recorder.Save(snapshot, decision, err)
+	return decision, err
+}
+
+func setPolicyStatus(policy *k8code.SpotPolicy, decision Decision, err error) {
+	policy.Status.ObservedGeneration = policy.Generation
+	if err != nil {
+		policy.Status.LastError = err.Error()
+		return
+	}
+	policy.Status.LastError = ""
+	policy.Status.CurrentInstanceType = decision.CurrentInstanceType()
+	policy.Status.CurrentBid = strconv.FormatFloat(decision.CurrentSpotPrice(), 'f', 2, 64)
+	if decision.OnDemand() {
+		policy.Status.CurrentBid = "on-demand"
+	}
+	policy.Status.EstimatedDollarsPerHour = strconv.FormatFloat(decision.EstimatedDollarsPerHour(), 'f', 3, 64)
+	policy.Status.LastDecision = decision.Outcome
+	policy.Status.LastDecisionReason = decision.Reason
+	policy.Status.LastDecisionTime = metav1.NewTime(decision.Time)
+}
+
+// RunSpotPolicies evaluates every SpotPolicy in spotConfig.SpotPolicyNamespace
+// and writes the outcome back to its status.  A policy is skipped while its
+// autoscaling group is within MinimumTurnoverSeconds of its last update.  A
+// failing policy does not stop the others; RunSpotPolicies reports whether any
+// autoscaling group was updated, and the errors of the policies that failed.
+// A standby replica leaves SpotPolicy status to the leader.
+func RunSpotPolicies(ctx context.Context, sess *session.Session, clientset *kubernetes.Clientset, spotConfig awscode.SpotConfig,
+	interruptionTracker *pricing.InterruptionTracker, podSummary map[string]float64,
+	lastTurnover map[string]time.Time, switchWatcher *SwitchWatcher, events *DecisionEvents,
+	notifier *notify.Notifier, priceHistory *history.Store, recorder *Recorder, monitor bool, standby bool) (bool, error) {
+
+	policies, err := k8code.ListSpotPolicies(clientset, spotConfig.SpotPolicyNamespace)
+	if err != nil {
+		return false, fmt.Errorf("could not list SpotPolicies: %w", err)
+	}
+	registry := NewRegistry(clientset, spotConfig)
+	updated := false
+	slog.Info("evaluating SpotPolicies", "count", len(policies))
+
+	// Every policy prices instance types in the daemon's region, so the inputs
+	// are read once, over the longest historical window of the policies due.
+	due := []k8code.SpotPolicy{}
+	inputsConfig := spotConfig
+	inputsConfig.HistoricalHours = 0
+	for _, policy := range policies {
+		key := policy.Namespace + "/" + policy.Name
+		policyConfig := SpotConfigForPolicy(spotConfig, policy)
+		if time.Since(lastTurnover[key]) < time.Second*time.Duration(policyConfig.MinimumTurnoverSeconds) {
+			slog.Info("SpotPolicy was updated recently, skipping", "spotPolicy", key)
+			continue
+		}
+		due = append(due, policy)
+		if policyConfig.Validate() == nil {
+			inputsConfig.HistoricalHours = math.Max(inputsConfig.HistoricalHours, policyConfig.HistoricalHours)
+		}
+	}
+	if len(due) == 0 {
+		return false, nil
+	}
+	if inputsConfig.HistoricalHours == 0 {
+		// No policy due is valid; each of them reports its own problems.
+		inputsConfig.HistoricalHours = spotConfig.HistoricalHours
+	}
+	inputs, inputsErr := pricing.GetInputs(ctx, sess, inputsConfig, interruptionTracker, priceHistory)
+	daemonStatus.recordCheck(checkAWS, inputsErr)
+
+	errs := []error{}
+	for _, policy := range due {
+		if ctx.Err() != nil {
+			return updated, errors.Join(errs...)
+		}
+		key := policy.Namespace + "/" + policy.Name
+		policyConfig := SpotConfigForPolicy(spotConfig, policy)
+		decision, err := Decision{}, inputsErr
+		if inputsErr == nil {
+			decision, err = evaluatePolicy(ctx, sess, clientset, policyConfig, inputs, podSummary,
+				switchWatcher, recorder, monitor)
+		}
+		events.RecordPolicy(policy, decision, err)
+		notifyDecision(notifier, policyConfig.Owner, decision, err)
+		if len(decision.Outcome) > 0 {
+			recordDecision(decision)
+		}
+		if err != nil {
+			slog.Error("SpotPolicy failed", "spotPolicy", key, "error", err)
+			errs = append(errs, fmt.Errorf("SpotPolicy '%v': %w", key, err))
+		}
+		if err == nil && decision.Updated() {
+			lastTurnover[key] = decision.Time
+			updated = true
+			switchWatcher.Watch(decision, registry, monitor)
+		}
+
+		if standby {
+			continue
+		}
+		setPolicyStatus(&policy, decision, err)
+		if err := k8code.UpdateSpotPolicyStatus(clientset, policy); err != nil {
+			slog.Warn("could not update SpotPolicy status", "spotPolicy", key, "error", err)
+		}
+	}
+	return updated, errors.Join(errs...)
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/k8code"
+)
+
+func TestSpotConfigForPolicy(t *testing.T) {
+	base := awscode.DefaultSpotConfig()
+	base.WatchSpotPolicies = true
+	maxCV, maxNodes := 0.2, 7
+	cases := []struct {
+		name    string
+		spec    k8code.SpotPolicySpec
+		wantErr string
+	}{
+		{"complete", k8code.SpotPolicySpec{AutoScalingGroupName: "workers", LaunchConfigurationPrefix: "workers-spot",
+			Constraints: k8code.SpotPolicyConstraints{MaxCV: &maxCV},
+			Budgets:     k8code.SpotPolicyBudgets{MaxAutoscalingNodes: &maxNodes}}, ""},
+		{"no group", k8code.SpotPolicySpec{LaunchConfigurationPrefix: "workers-spot"}, "autoScalingGroupName"},
+		{"no prefix", k8code.SpotPolicySpec{AutoScalingGroupName: "workers"}, "launchConfigurationPrefix"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			policy := k8code.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "workers"}, Spec: c.spec}
+			spotConfig := SpotConfigForPolicy(base, policy)
+			err := spotConfig.Validate()
+			if len(c.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("Validate() = %v, want a problem with %v", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spotConfig.Owner != "spotpolicy/default/workers" || spotConfig.MaxCV != maxCV ||
+				spotConfig.MaxAutoscalingNodes != maxNodes || spotConfig.MinGB != base.MinGB {
+				t.Errorf("SpotConfigForPolicy = %+v", spotConfig)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+// recentErrorsKept bounds how many of the latest iteration errors /status lists.
+const recentErrorsKept = 10
+
+// Dependencies whose last result in the loop /readyz reports.
+const (
+	checkAWS        = "aws"
+	checkKubernetes = "kubernetes"
+	checkPricing    = "pricing"
+)
+
+// readinessChecks are the dependencies that must have last succeeded for the
+// daemon to be ready.
+var readinessChecks = []string{checkAWS, checkKubernetes, checkPricing}
+
+// statusCheck is the last result the loop had from a dependency.
+type statusCheck struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+type statusError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// statusDecision is the part of a Decision reported on /status.
+type statusDecision struct {
+	Time                    time.Time `json:"time"`
+	Outcome                 string    `json:"outcome"`
+	Reason                  string    `json:"reason"`
+	Monitor                 bool      `json:"monitor"`
+	InstanceType            string    `json:"instanceType"`
+	SpotPrice               float64   `json:"spotPrice"`
+	OnDemand                bool      `json:"onDemand"`
+	RecommendedInstanceType string    `json:"recommendedInstanceType"`
+	RecommendedSpotPrice    float64   `json:"recommendedSpotPrice"`
+	EstimatedDollarsPerHour float64   `json:"estimatedDollarsPerHour"`
+	LaunchConfiguration     string    `json:"launchConfiguration"`
+}
+
+// Status follows the daemon loop for the /healthz, /readyz and /status
+// endpoints.
+type Status struct {
+	mutex        sync.Mutex
+	started      time.Time
+	heartbeat    time.Time
+	nextCheck    time.Time
+	pricedTypes  int
+	decisions    map[string]statusDecision
+	recentErrors []statusError
+	checks       map[string]statusCheck
+	spotConfig   awscode.SpotConfig
+	monitor      bool
+}
+
+// daemonStatus is updated wherever the loop makes progress, like the metrics
+// it is served alongside.
+var daemonStatus = newStatus()
+
+func newStatus() *Status {
+	now := time.Now()
+	return &Status{started: now, heartbeat: now, decisions: map[string]statusDecision{},
+		checks: map[string]statusCheck{}}
+}
+
+// beat records that the loop is making progress.
+func (s *Status) beat() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.heartbeat = time.Now()
+}
+
+// startIteration records a heartbeat and the configuration an iteration runs with.
+func (s *Status) startIteration(spotConfig awscode.SpotConfig, monitor bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.heartbeat = time.Now()
+	s.spotConfig = spotConfig
+	s.monitor = monitor
+}
+
+// sleep records that the loop is idle until the next check, d from now.
+func (s *Status) sleep(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.heartbeat = time.Now()
+	s.nextCheck = s.heartbeat.Add(d)
+}
+
+// recordPricing records how many instance types were priced.  Pricing fails
+// readiness if none were.
+func (s *Status) recordPricing(priceList []pricing.FullSummary) {
+	var err error
+	if len(priceList) == 0 {
+		err = fmt.Errorf("no instance type was priced")
+	}
+	s.recordCheck(checkPricing, err)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pricedTypes = len(priceList)
+}
+
+// recordCheck records the result the loop has just had from a dependency.
+func (s *Status) recordCheck(name string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	check := statusCheck{Time: time.Now()}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	s.checks[name] = check
+}
+
+// newStatusDecision returns the part of d reported on /status.
+func newStatusDecision(d Decision) statusDecision {
+	launchConfiguration := d.OriginalLaunchConfigurationName
+	if d.Updated() && len(d.NewLaunchConfigurationName) > 0 {
+		launchConfiguration = d.NewLaunchConfigurationName
+	}
+	return statusDecision{
+		Time:                    d.Time,
+		Outcome:                 d.Outcome,
+		Reason:                  d.Reason,
+		Monitor:                 d.Monitor,
+		InstanceType:            d.CurrentInstanceType(),
+		SpotPrice:               d.CurrentSpotPrice(),
+		OnDemand:                d.OnDemand(),
+		RecommendedInstanceType: d.NewInstanceType,
+		RecommendedSpotPrice:    d.NewSpotPrice,
+		EstimatedDollarsPerHour: d.EstimatedDollarsPerHour(),
+		LaunchConfiguration:     launchConfiguration}
+}
+
+func (s *Status) recordDecision(d Decision) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.decisions[d.AutoScalingGroupName] = newStatusDecision(d)
+}
+
+func (s *Status) recordError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.recentErrors = append(s.recentErrors, statusError{Time: time.Now(), Error: err.Error()})
+	if len(s.recentErrors) > recentErrorsKept {
+		s.recentErrors = s.recentErrors[len(s.recentErrors)-recentErrorsKept:]
+	}
+}
+
+// Handlers returns the /healthz, /readyz and /status endpoints by path.
+func (s *Status) Handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/healthz": http.HandlerFunc(s.serveHealth),
+		"/readyz":  http.HandlerFunc(s.serveReady),
+		"/status":  http.HandlerFunc(s.serveStatus)}
+}
+
+// serveHealth fails once the loop has gone HeartbeatTimeoutSeconds without a
+// heartbeat, counting from its next scheduled check while it is sleeping.
+func (s *Status) serveHealth(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	last := s.heartbeat
+	if s.nextCheck.After(last) {
+		last = s.nextCheck
+	}
+	timeout := time.Second * time.Duration(s.spotConfig.HeartbeatTimeoutSeconds)
+	s.mutex.Unlock()
+
+	if stalled := time.Since(last); stalled > timeout {
+		http.Error(w, fmt.Sprintf("no heartbeat for %v", stalled.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReady reports the last result the loop had from AWS, the Kubernetes
+// API server and pricing, failing until each of them has succeeded and
+// whenever the latest of them failed.  It makes no calls itself, so probes
+// cost nothing and agree with what the loop sees.
+func (s *Status) serveReady(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	code := http.StatusOK
+	results := map[string]string{}
+	for _, name := range readinessChecks {
+		check, found := s.checks[name]
+		switch {
+		case !found:
+			results[name] = "not checked yet"
+		case len(check.Error) > 0:
+			results[name] = check.Error
+		default:
+			results[name] = "ok"
+			continue
+		}
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, results)
+}
+
+func (s *Status) serveStatus(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"started":             s.started,
+		"heartbeat":           s.heartbeat,
+		"nextCheck":           s.nextCheck,
+		"monitor":             s.monitor,
+		"pricedInstanceTypes": s.pricedTypes,
+		"lastDecisions":       s.decisions,
+		"recentErrors":        s.recentErrors,
+		"checks":              s.checks,
+		"config":              s.spotConfig})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("could not write status response", "error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+)
+
+func getReadiness(t *testing.T, s *Status) (int, map[string]string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	s.Handlers()["/readyz"].ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	results := map[string]string{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &results); err != nil {
+		t.Fatalf("/readyz response is not JSON: %v", err)
+	}
+	return recorder.Code, results
+}
+
+func TestServeReady(t *testing.T) {
+	s := newStatus()
+	code, results := getReadiness(t, s)
+	want := map[string]string{checkAWS: "not checked yet", checkKubernetes: "not checked yet",
+		checkPricing: "not checked yet"}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(results, want) {
+		t.Errorf("before any check: %v %v, want %v %v", code, results, http.StatusServiceUnavailable, want)
+	}
+
+	s.recordCheck(checkAWS, nil)
+	s.recordCheck(checkKubernetes, nil)
+	s.recordPricing(nil)
+	code, results = getReadiness(t, s)
+	want = map[string]string{checkAWS: "ok", checkKubernetes: "ok", checkPricing: "no instance type was priced"}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(results, want) {
+		t.Errorf("nothing priced: %v %v, want %v %v", code, results, http.StatusServiceUnavailable, want)
+	}
+
+	s.recordPricing([]pricing.FullSummary{{Name: "r5.xlarge"}})
+	code, results = getReadiness(t, s)
+	want = map[string]string{checkAWS: "ok", checkKubernetes: "ok", checkPricing: "ok"}
+	if code != http.StatusOK || !reflect.DeepEqual(results, want) {
+		t.Errorf("all checks passed: %v %v, want %v %v", code, results, http.StatusOK, want)
+	}
+
+	// Only the latest result counts: a failure makes the daemon unready again.
+	s.recordCheck(checkKubernetes, fmt.Errorf("connection refused"))
+	code, results = getReadiness(t, s)
+	want = map[string]string{checkAWS: "ok", checkKubernetes: "connection refused", checkPricing: "ok"}
+	if code != http.StatusServiceUnavailable || !reflect.DeepEqual(results, want) {
+		t.Errorf("latest check failed: %v %v, want %v %v", code, results, http.StatusServiceUnavailable, want)
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	cases := []struct {
+		name      string
+		heartbeat time.Duration
+		nextCheck time.Duration
+		want      int
+	}{
+		{"recent heartbeat", -time.Minute, 0, http.StatusOK},
+		{"stalled", -time.Hour, 0, http.StatusServiceUnavailable},
+		{"sleeping until a later check", -time.Hour, 10 * time.Minute, http.StatusOK},
+		{"overslept", -time.Hour, -30 * time.Minute, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newStatus()
+			s.spotConfig = awscode.SpotConfig{HeartbeatTimeoutSeconds: 600}
+			s.heartbeat = time.Now().Add(c.heartbeat)
+			if c.nextCheck != 0 {
+				s.nextCheck = time.Now().Add(c.nextCheck)
+			}
+			recorder := httptest.NewRecorder()
+			s.Handlers()["/healthz"].ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+			if recorder.Code != c.want {
+				t.Errorf("/healthz = %v (%v), want %v", recorder.Code, recorder.Body.String(), c.want)
+			}
+		})
+	}
+}
+
+func TestRecordErrorKeepsRecent(t *testing.T) {
+	s := newStatus()
+	for i := 0; i < recentErrorsKept+3; i++ {
+		s.recordError(fmt.Errorf("error %v", i))
+	}
+	if len(s.recentErrors) != recentErrorsKept {
+		t.Fatalf("kept %v errors, want %v", len(s.recentErrors), recentErrorsKept)
+	}
+	if first := s.recentErrors[0].Error; first != "error 3" {
+		t.Errorf("oldest kept error = %q, want %q", first, "error 3")
+	}
+}
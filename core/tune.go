@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+)
+
+// TuneSpace lists the values a tune tries for each setting it searches.
+type TuneSpace struct {
+	MaxCV                        []float64
+	MinMarkupPercentage          []float64
+	MinPriceDifferencePercentage []float64
+	HistoricalHours              []float64
+	WeightingKernel              []string
+}
+
+// Configs returns every combination of the space's values applied to base.
+func (s TuneSpace) Configs(base awscode.SpotConfig) []awscode.SpotConfig {
+	configs := []awscode.SpotConfig{}
+	for _, maxCV := range s.MaxCV {
+		for _, markup := range s.MinMarkupPercentage {
+			for _, difference := range s.MinPriceDifferencePercentage {
+				for _, hours := range s.HistoricalHours {
+					for _, kernel := range s.WeightingKernel {
+						config := base
+						config.MaxCV = maxCV
+						config.MinMarkupPercentage = markup
+						config.MinPriceDifferencePercentage = difference
+						config.HistoricalHours = hours
+						config.WeightingKernel = kernel
+						configs = append(configs, config)
+					}
+				}
+			}
+		}
+	}
+	return configs
+}
+
+// TunePenalties are the dollars a tune charges each interruption and each
+// switch on top of the cost, which already includes SwitchingCostPerNode.
+type TunePenalties struct {
+	Interruption float64
+	Turnover     float64
+}
+
+// TuneTrial is one configuration a tune backtested and how it did.
+type TuneTrial struct {
+	SpotConfig awscode.SpotConfig
+	Result     BacktestResult
+	Score      float64
+	// Pareto is set if no other trial was at least as good on total cost,
+	// interruptions and switches, and better on one of them.
+	Pareto bool
+	Err    error
+}
+
+// Settings returns the trial's values of the settings a tune searches, by
+// flag name.
+func (t TuneTrial) Settings() map[string]string {
+	return map[string]string{
+		"maxCV":                        strconv.FormatFloat(t.SpotConfig.MaxCV, 'f', -1, 64),
+		"minMarkupPercentage":          strconv.FormatFloat(t.SpotConfig.MinMarkupPercentage, 'f', -1, 64),
+		"minPriceDifferencePercentage": strconv.FormatFloat(t.SpotConfig.MinPriceDifferencePercentage, 'f', -1, 64),
+		"historicalHours":              strconv.FormatFloat(t.SpotConfig.HistoricalHours, 'f', -1, 64),
+		"weightingKernel":              t.SpotConfig.WeightingKernel}
+}
+
+func (t TuneTrial) dominates(other TuneTrial) bool {
+	a, b := t.Result, other.Result
+	if a.TotalCost() > b.TotalCost() || a.Interruptions > b.Interruptions || a.Switches > b.Switches {
+		return false
+	}
+	return a.TotalCost() < b.TotalCost() || a.Interruptions < b.Interruptions || a.Switches < b.Switches
+}
+
+// Tune backtests the configurations of space over base, every one of them or,
+// if samples is positive, that many chosen at random with seed.  The trials
+// that ran are returned best score first, followed by those that failed.
+func Tune(backtest Backtest, base awscode.SpotConfig, space TuneSpace, samples int, seed int64,
+	penalties TunePenalties) []TuneTrial {
+
+	backtest.SkipBaselines = true
+	configs := space.Configs(base)
+	if samples > 0 && samples < len(configs) {
+		random := rand.New(rand.NewSource(seed))
+		random.Shuffle(len(configs), func(i, j int) { configs[i], configs[j] = configs[j], configs[i] })
+		configs = configs[:samples]
+	}
+
+	trials := make([]TuneTrial, len(configs))
+	next := make(chan int)
+	var wait sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU(); worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range next {
+				trial := TuneTrial{SpotConfig: configs[i]}
+				if trial.Err = configs[i].Validate(); trial.Err == nil {
+					var report BacktestReport
+					report, trial.Err = backtest.Run(configs[i])
+					trial.Result = report.Daemon
+					trial.Score = trial.Result.TotalCost() + penalties.Interruption*float64(trial.Result.Interruptions) +
+						penalties.Turnover*float64(trial.Result.Switches)
+				}
+				trials[i] = trial
+			}
+		}()
+	}
+	for i := range configs {
+		next <- i
+	}
+	close(next)
+	wait.Wait()
+
+	for i := range trials {
+		if trials[i].Err != nil {
+			continue
+		}
+		trials[i].Pareto = true
+		for j := range trials {
+			if trials[j].Err == nil && trials[j].dominates(trials[i]) {
+				trials[i].Pareto = false
+				break
+			}
+		}
+	}
+	sort.SliceStable(trials, func(i, j int) bool {
+		if (trials[i].Err == nil) != (trials[j].Err == nil) {
+			return trials[i].Err == nil
+		}
+		return trials[i].Score < trials[j].Score
+	})
+	return trials
+}
+
+// PrintTune writes the Pareto frontier of a tune's trials to standard out,
+// ranked by score among every trial.
+func PrintTune(trials []TuneTrial, penalties TunePenalties) {
+	failed := 0
+	for _, trial := range trials {
+		if trial.Err != nil {
+			failed++
+		}
+	}
+	fmt.Printf("\nTried %v configurations (%v failed), scored as total cost + $%v per interruption + $%v per switch.\n",
+		len(trials), failed, penalties.Interruption, penalties.Turnover)
+	fmt.Printf("Pareto frontier on total cost, interruptions and switches:\n")
+	fmt.Printf("  %4v %9v %9v %6v %6v %6v || %6v %6v %6v %6v %v\n", "Rank", "Score", "Total", "Intr", "Switch", "Bids",
+		"maxCV", "Markup", "Diff", "Hours", "Kernel")
+	for rank, trial := range trials {
+		if !trial.Pareto {
+			continue
+		}
+		fmt.Printf("  %4v %9.2f %9.2f %6v %6v %6v || %6v %6v %6v %6v %v\n", rank+1, trial.Score,
+			trial.Result.TotalCost(), trial.Result.Interruptions, trial.Result.Switches, trial.Result.BidChanges,
+			trial.SpotConfig.MaxCV, trial.SpotConfig.MinMarkupPercentage, trial.SpotConfig.MinPriceDifferencePercentage,
+			trial.SpotConfig.HistoricalHours, trial.SpotConfig.WeightingKernel)
+	}
+	if failed == len(trials) && failed > 0 {
+		fmt.Printf("\nEvery configuration failed, the first with: %v\n", trials[0].Err)
+	}
+}
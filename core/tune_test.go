@@ -0,0 +1,83 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/davidboren/k8-spot-daemon/awscode"
+)
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTuneTrialDominates(t *testing.T) {
+	trial := func(cost float64, interruptions int, switches int) TuneTrial {
+		return TuneTrial{Result: BacktestResult{Cost: cost, Interruptions: interruptions, Switches: switches}}
+	}
+	cases := []struct {
+		name string
+		a    TuneTrial
+		b    TuneTrial
+		want bool
+	}{
+		{"better on everything", trial(1, 0, 0), trial(2, 1, 1), true},
+		{"better on cost only", trial(1, 1, 1), trial(2, 1, 1), true},
+		{"better on interruptions only", trial(2, 0, 1), trial(2, 1, 1), true},
+		{"better on switches only", trial(2, 1, 0), trial(2, 1, 1), true},
+		{"equal", trial(2, 1, 1), trial(2, 1, 1), false},
+		{"trade-off", trial(1, 2, 1), trial(2, 1, 1), false},
+		{"worse", trial(3, 1, 1), trial(2, 1, 1), false},
+	}
+	for _, c := range cases {
+		if got := c.a.dominates(c.b); got != c.want {
+			t.Errorf("%v: dominates = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestTune(t *testing.T) {
+	backtest, base := newTestBacktest()
+	base.AutoScalingGroupName = "nodes"
+	base.LaunchConfigurationPrefix = "nodes-spot"
+	// A minPriceDifferencePercentage of 1000 never leaves r4.xlarge, which is
+	// cheaper than following r5.xlarge into its interruption, and a maxCV of
+	// 0 is invalid.
+	space := TuneSpace{
+		MaxCV:                        []float64{0.05, 0},
+		MinMarkupPercentage:          []float64{10},
+		MinPriceDifferencePercentage: []float64{10, 1000},
+		HistoricalHours:              []float64{1},
+		WeightingKernel:              []string{awscode.KernelInverse},
+	}
+	penalties := TunePenalties{Interruption: 1, Turnover: 0.5}
+
+	trials := Tune(backtest, base, space, 0, 0, penalties)
+	want := []struct {
+		difference float64
+		score      float64
+		pareto     bool
+		failed     bool
+	}{
+		{1000, 1.2, true, false},
+		{10, 1.15 + 0.4 + 1 + 2*0.5, false, false},
+		{10, 0, false, true},
+		{1000, 0, false, true},
+	}
+	if len(trials) != len(want) {
+		t.Fatalf("Tune returned %v trials, want %v", len(trials), len(want))
+	}
+	for i, w := range want {
+		trial := trials[i]
+		if trial.SpotConfig.MinPriceDifferencePercentage != w.difference || (trial.Err != nil) != w.failed ||
+			trial.Pareto != w.pareto || (!w.failed && !closeTo(trial.Score, w.score)) {
+			t.Errorf("trial %v = %v %v, score %v, pareto %v, want %v", i, trial.Settings(), trial.Err,
+				trial.Score, trial.Pareto, w)
+		}
+	}
+
+	sampled := Tune(backtest, base, space, 2, 1, penalties)
+	if len(sampled) != 2 {
+		t.Errorf("Tune sampled %v trials, want 2", len(sampled))
+	}
+}
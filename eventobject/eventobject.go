@@ -0,0 +1,54 @@
+// Package eventobject parses references to the objects the daemon records
+// Events on.  It has no Kubernetes dependencies, so that configuration can be
+// validated without linking client-go.
+package eventobject
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Object is the object an Event is recorded on.
+type Object struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	UID        string
+}
+
+// kinds maps the kinds Parse accepts to their API version and resource.  The
+// SpotPolicy entry must match the custom resource k8code serves.
+var kinds = map[string][2]string{
+	"Deployment":  {"apps/v1", "deployments"},
+	"DaemonSet":   {"apps/v1", "daemonsets"},
+	"StatefulSet": {"apps/v1", "statefulsets"},
+	"Pod":         {"v1", "pods"},
+	"SpotPolicy":  {"k8spotdaemon.io/v1alpha1", "spotpolicies"},
+}
+
+// Parse parses a "Kind/namespace/name" reference such as
+// "Deployment/kube-system/k8-spot-daemon".
+func Parse(reference string) (Object, error) {
+	parts := strings.Split(reference, "/")
+	if len(parts) != 3 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return Object{}, fmt.Errorf("event object '%v' is not of the form Kind/namespace/name", reference)
+	}
+	kind, found := kinds[parts[0]]
+	if !found {
+		names := []string{}
+		for name := range kinds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Object{}, fmt.Errorf("event object kind '%v' is not one of %v", parts[0], strings.Join(names, ", "))
+	}
+	return Object{APIVersion: kind[0], Kind: parts[0], Namespace: parts[1], Name: parts[2]}, nil
+}
+
+// Resource returns the API resource of the object's kind, such as
+// "deployments".
+func (o Object) Resource() string {
+	return kinds[o.Kind][1]
+}
@@ -0,0 +1,242 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// pointsBucket holds a bucket of price points per "region/instanceType/zone",
+	// keyed by timestamp.
+	pointsBucket = []byte("points")
+	// coverageBucket holds the Coverage of each "region/instanceType".
+	coverageBucket = []byte("coverage")
+)
+
+// openTimeout bounds the wait for another process holding the store's file.
+const openTimeout = 5 * time.Second
+
+// Coverage is the span of time an instance type's history is complete for in
+// the store: every price change between From and Until has been fetched.
+type Coverage struct {
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+// Store keeps spot price history on disk so that it survives restarts and
+// only the points published since the last fetch need to be downloaded.
+// Points older than Retention are pruned, except the last one of each zone,
+// which is the price still in effect at the start of the retained history.
+type Store struct {
+	Retention time.Duration
+
+	db *bolt.DB
+}
+
+// Open opens, or creates, the store at path.
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open price history '%v': %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(pointsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(coverageBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialise price history '%v': %w", path, err)
+	}
+	return &Store{Retention: retention, db: db}, nil
+}
+
+// OpenReadOnly opens the store at path for reading only.  A daemon holding
+// the file open blocks it, so offline tools are best pointed at a copy.
+func OpenReadOnly(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("could not open price history '%v': %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store.  Closing a nil Store does nothing.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func coverageKey(regionName string, instanceType string) []byte {
+	return []byte(regionName + "/" + instanceType)
+}
+
+func pointsKey(regionName string, instanceType string, zone string) []byte {
+	return []byte(regionName + "/" + instanceType + "/" + zone)
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))).UTC()
+}
+
+// Coverage returns the span the history of instanceType in regionName is
+// complete for, and whether there is any.
+func (s *Store) Coverage(regionName string, instanceType string) (Coverage, bool, error) {
+	coverage := Coverage{}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		coverages := tx.Bucket(coverageBucket)
+		if coverages == nil {
+			return nil
+		}
+		value := coverages.Get(coverageKey(regionName, instanceType))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &coverage)
+	})
+	return coverage, found, err
+}
+
+// Add records the price history of each instance type in regionName fetched
+// from its time in from until until, then prunes points beyond the retention.
+// A type's coverage is extended if the fetch overlaps it and replaced if not.
+func (s *Store) Add(regionName string, from map[string]time.Time, until time.Time,
+	prices map[string][]ec2.SpotPrice) error {
+
+	cutoff := until.Add(-s.Retention)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		points := tx.Bucket(pointsBucket)
+		for instanceType, start := range from {
+			for _, price := range prices[instanceType] {
+				if price.Timestamp == nil || price.SpotPrice == nil || price.AvailabilityZone == nil {
+					continue
+				}
+				bucket, err := points.CreateBucketIfNotExists(pointsKey(regionName, instanceType, *price.AvailabilityZone))
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put(timeKey(*price.Timestamp), []byte(*price.SpotPrice)); err != nil {
+					return err
+				}
+			}
+
+			coverages := tx.Bucket(coverageBucket)
+			key := coverageKey(regionName, instanceType)
+			coverage := Coverage{From: start, Until: until}
+			if value := coverages.Get(key); value != nil {
+				existing := Coverage{}
+				if err := json.Unmarshal(value, &existing); err == nil &&
+					!existing.Until.Before(start) && existing.From.Before(start) {
+					coverage.From = existing.From
+				}
+			}
+			if coverage.From.Before(cutoff) {
+				coverage.From = cutoff
+			}
+			value, err := json.Marshal(coverage)
+			if err != nil {
+				return err
+			}
+			if err := coverages.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return prune(points, timeKey(cutoff))
+	})
+}
+
+// prune deletes every point before cutoff but the last of each zone.
+func prune(points *bolt.Bucket, cutoff []byte) error {
+	return points.ForEach(func(name []byte, _ []byte) error {
+		bucket := points.Bucket(name)
+		if bucket == nil {
+			return nil
+		}
+		stale := [][]byte{}
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, cutoff) < 0; key, _ = cursor.Next() {
+			stale = append(stale, append([]byte{}, key...))
+		}
+		if len(stale) > 0 {
+			stale = stale[:len(stale)-1]
+		}
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Query returns the price history of instanceType in every zone of regionName
+// from since until until.  Like DescribeSpotPriceHistory, the history of each
+// zone begins with the price in effect at since, whose timestamp may be
+// earlier.
+func (s *Store) Query(regionName string, instanceType string, since time.Time,
+	until time.Time) ([]ec2.SpotPrice, error) {
+
+	prices := []ec2.SpotPrice{}
+	prefix := string(pointsKey(regionName, instanceType, ""))
+	start, end := timeKey(since), timeKey(until)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		points := tx.Bucket(pointsBucket)
+		if points == nil {
+			return nil
+		}
+		cursor := points.Cursor()
+		for name, _ := cursor.Seek([]byte(prefix)); name != nil && strings.HasPrefix(string(name), prefix); name, _ = cursor.Next() {
+			bucket := points.Bucket(name)
+			if bucket == nil {
+				continue
+			}
+			zone := strings.TrimPrefix(string(name), prefix)
+			add := func(key []byte, value []byte) {
+				prices = append(prices, ec2.SpotPrice{
+					AvailabilityZone: aws.String(zone),
+					InstanceType:     aws.String(instanceType),
+					SpotPrice:        aws.String(string(value)),
+					Timestamp:        aws.Time(keyTime(key))})
+			}
+
+			zoneCursor := bucket.Cursor()
+			key, value := zoneCursor.Seek(start)
+			if key == nil || bytes.Compare(key, start) > 0 {
+				if key == nil {
+					key, value = zoneCursor.Last()
+				} else {
+					key, value = zoneCursor.Prev()
+				}
+				if key != nil {
+					add(key, value)
+				}
+				key, value = zoneCursor.Seek(start)
+			}
+			for ; key != nil && bytes.Compare(key, end) <= 0; key, value = zoneCursor.Next() {
+				add(key, value)
+			}
+		}
+		return nil
+	})
+	return prices, err
+}
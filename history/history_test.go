@@ -0,0 +1,110 @@
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours float64) time.Time {
+	return start.Add(time.Duration(hours * float64(time.Hour)))
+}
+
+func point(zone string, hours float64, price string) ec2.SpotPrice {
+	return ec2.SpotPrice{AvailabilityZone: aws.String(zone), InstanceType: aws.String("r4.xlarge"),
+		SpotPrice: aws.String(price), Timestamp: aws.Time(at(hours))}
+}
+
+// describe lists points as "zone@hours=price".
+func describe(prices []ec2.SpotPrice) []string {
+	described := []string{}
+	for _, price := range prices {
+		described = append(described, fmt.Sprintf("%v@%v=%v", aws.StringValue(price.AvailabilityZone),
+			price.Timestamp.Sub(start).Hours(), aws.StringValue(price.SpotPrice)))
+	}
+	return described
+}
+
+func TestStore(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "prices.db"), 10*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, found, err := store.Coverage("us-west-2", "r4.xlarge"); err != nil || found {
+		t.Fatalf("Coverage of an empty store = %v, %v", found, err)
+	}
+	err = store.Add("us-west-2", map[string]time.Time{"r4.xlarge": at(0)}, at(4), map[string][]ec2.SpotPrice{
+		"r4.xlarge": {point("a", 0, "0.10"), point("a", 1, "0.11"), point("a", 3, "0.12"), point("b", 2, "0.20"),
+			{AvailabilityZone: aws.String("b"), Timestamp: aws.Time(at(3))}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct {
+		name  string
+		since float64
+		until float64
+		want  []string
+	}{
+		{"everything", 0, 4, []string{"a@0=0.10", "a@1=0.11", "a@3=0.12", "b@2=0.20"}},
+		{"starts with the price in effect", 1.5, 4, []string{"a@1=0.11", "a@3=0.12", "b@2=0.20"}},
+		{"starts on a point", 1, 4, []string{"a@1=0.11", "a@3=0.12", "b@2=0.20"}},
+		{"after the last points", 5, 6, []string{"a@3=0.12", "b@2=0.20"}},
+		{"ends on a point", 0, 2, []string{"a@0=0.10", "a@1=0.11", "b@2=0.20"}},
+	}
+	for _, q := range queries {
+		prices, err := store.Query("us-west-2", "r4.xlarge", at(q.since), at(q.until))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(prices); !reflect.DeepEqual(got, q.want) {
+			t.Errorf("%v: Query = %v, want %v", q.name, got, q.want)
+		}
+	}
+	if prices, err := store.Query("us-east-1", "r4.xlarge", at(0), at(4)); err != nil || len(prices) > 0 {
+		t.Errorf("Query of another region = %v, %v", describe(prices), err)
+	}
+
+	additions := []struct {
+		name         string
+		from         float64
+		until        float64
+		prices       []ec2.SpotPrice
+		wantCoverage Coverage
+		wantPoints   []string
+	}{
+		{"overlapping fetch extends the coverage up to the retention", 4, 12,
+			[]ec2.SpotPrice{point("a", 11, "0.13")}, Coverage{From: at(2), Until: at(12)},
+			[]string{"a@1=0.11", "a@3=0.12", "a@11=0.13", "b@2=0.20"}},
+		{"disjoint fetch replaces the coverage", 20, 22,
+			nil, Coverage{From: at(20), Until: at(22)},
+			[]string{"a@11=0.13", "b@2=0.20"}},
+	}
+	for _, a := range additions {
+		err := store.Add("us-west-2", map[string]time.Time{"r4.xlarge": at(a.from)}, at(a.until),
+			map[string][]ec2.SpotPrice{"r4.xlarge": a.prices})
+		if err != nil {
+			t.Fatal(err)
+		}
+		coverage, found, err := store.Coverage("us-west-2", "r4.xlarge")
+		if err != nil || !found || !coverage.From.Equal(a.wantCoverage.From) || !coverage.Until.Equal(a.wantCoverage.Until) {
+			t.Errorf("%v: Coverage = %v, %v, %v, want %v", a.name, coverage, found, err, a.wantCoverage)
+		}
+		prices, err := store.Query("us-west-2", "r4.xlarge", at(-100), at(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(prices); !reflect.DeepEqual(got, a.wantPoints) {
+			t.Errorf("%v: points = %v, want %v", a.name, got, a.wantPoints)
+		}
+	}
+}
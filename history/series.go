@@ -0,0 +1,91 @@
+package history
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Series is the price history of one instance type held in memory, by zone
+// and in time order, for reading many windows of it quickly.
+type Series map[string][]ec2.SpotPrice
+
+// NewSeries arranges price points, such as those returned by Query, by zone.
+func NewSeries(prices []ec2.SpotPrice) Series {
+	series := Series{}
+	for _, price := range prices {
+		if price.Timestamp == nil || price.SpotPrice == nil || price.AvailabilityZone == nil {
+			continue
+		}
+		zone := *price.AvailabilityZone
+		series[zone] = append(series[zone], price)
+	}
+	for _, points := range series {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Timestamp.Before(*points[j].Timestamp) })
+	}
+	return series
+}
+
+// search returns the index of the first point of points after t.
+func search(points []ec2.SpotPrice, t time.Time) int {
+	return sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(t) })
+}
+
+// Window returns the points from since until until, beginning, like Query,
+// with the price in effect in each zone at since.
+func (s Series) Window(since time.Time, until time.Time) []ec2.SpotPrice {
+	prices := []ec2.SpotPrice{}
+	for _, zone := range s.Zones() {
+		points := s[zone]
+		first := sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(since) })
+		if first > 0 && (first == len(points) || points[first].Timestamp.After(since)) {
+			first--
+		}
+		prices = append(prices, points[first:search(points, until)]...)
+	}
+	return prices
+}
+
+// At returns the price in effect in each zone at t, leaving out the zones
+// without a point at or before it.
+func (s Series) At(t time.Time) map[string]float64 {
+	prices := map[string]float64{}
+	for zone, points := range s {
+		if last := search(points, t); last > 0 {
+			price, err := strconv.ParseFloat(aws.StringValue(points[last-1].SpotPrice), 64)
+			if err == nil {
+				prices[zone] = price
+			}
+		}
+	}
+	return prices
+}
+
+// Changes returns the times, after since and before until, at which the price
+// in any zone changed.
+func (s Series) Changes(since time.Time, until time.Time) []time.Time {
+	changes := []time.Time{}
+	for _, points := range s {
+		for _, point := range points[search(points, since):] {
+			if !point.Timestamp.Before(until) {
+				break
+			}
+			changes = append(changes, *point.Timestamp)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Before(changes[j]) })
+	return changes
+}
+
+// Zones returns the zones of the series in order.
+func (s Series) Zones() []string {
+	zones := []string{}
+	for zone := range s {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,56 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSeries(t *testing.T) {
+	series := NewSeries([]ec2.SpotPrice{point("b", 2, "0.20"), point("a", 3, "0.12"), point("a", 0, "0.10"),
+		point("a", 1, "0.11"), {AvailabilityZone: aws.String("c"), Timestamp: aws.Time(at(1))}})
+	if zones := series.Zones(); !reflect.DeepEqual(zones, []string{"a", "b"}) {
+		t.Errorf("Zones = %v, want [a b]", zones)
+	}
+
+	cases := []struct {
+		name        string
+		since       float64
+		until       float64
+		wantWindow  []string
+		wantAt      map[string]float64
+		wantChanges []float64
+	}{
+		{"everything", 0, 4, []string{"a@0=0.10", "a@1=0.11", "a@3=0.12", "b@2=0.20"},
+			map[string]float64{"a": 0.10}, []float64{1, 2, 3}},
+		{"starts with the price in effect", 1.5, 4, []string{"a@1=0.11", "a@3=0.12", "b@2=0.20"},
+			map[string]float64{"a": 0.11}, []float64{2, 3}},
+		{"starts on a point", 1, 4, []string{"a@1=0.11", "a@3=0.12", "b@2=0.20"},
+			map[string]float64{"a": 0.11}, []float64{2, 3}},
+		{"after the last points", 5, 6, []string{"a@3=0.12", "b@2=0.20"},
+			map[string]float64{"a": 0.12, "b": 0.20}, []float64{}},
+		{"before the first points", -2, -1, []string{},
+			map[string]float64{}, []float64{}},
+		{"ends on a point", 0, 2, []string{"a@0=0.10", "a@1=0.11", "b@2=0.20"},
+			map[string]float64{"a": 0.10}, []float64{1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := describe(series.Window(at(c.since), at(c.until))); !reflect.DeepEqual(got, c.wantWindow) {
+				t.Errorf("Window = %v, want %v", got, c.wantWindow)
+			}
+			if got := series.At(at(c.since)); !reflect.DeepEqual(got, c.wantAt) {
+				t.Errorf("At = %v, want %v", got, c.wantAt)
+			}
+			changes := []float64{}
+			for _, change := range series.Changes(at(c.since), at(c.until)) {
+				changes = append(changes, change.Sub(start).Hours())
+			}
+			if !reflect.DeepEqual(changes, c.wantChanges) {
+				t.Errorf("Changes = %v, want %v", changes, c.wantChanges)
+			}
+		})
+	}
+}
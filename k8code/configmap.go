@@ -0,0 +1,61 @@
+package k8code
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GetConfigMapData returns the data of a ConfigMap with the resourceVersion it
+// was read at, or an empty map and version if the ConfigMap does not exist
+// yet.
+func GetConfigMapData(clientset *kubernetes.Clientset, namespace string, name string) (map[string]string, string, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return map[string]string{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if configMap.Data == nil {
+		return map[string]string{}, configMap.ResourceVersion, nil
+	}
+	return configMap.Data, configMap.ResourceVersion, nil
+}
+
+// SaveConfigMapData replaces the data of a ConfigMap read at resourceVersion by
+// GetConfigMapData, creating it if the version is empty.  If the ConfigMap
+// has been written since it was read the error satisfies IsConflict.
+func SaveConfigMapData(clientset *kubernetes.Clientset, namespace string, name string, data map[string]string,
+	resourceVersion string) error {
+
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	if len(resourceVersion) == 0 {
+		_, err := configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       data})
+		return err
+	}
+	// The ConfigMap is read again to keep its labels and annotations; the
+	// update still only succeeds at the version the data was read at.
+	configMap, err := configMaps.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if configMap.ResourceVersion != resourceVersion {
+		return errors.NewConflict(v1.Resource("configmaps"), name,
+			fmt.Errorf("resourceVersion is %v, not %v", configMap.ResourceVersion, resourceVersion))
+	}
+	configMap.Data = data
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// IsConflict reports whether a write failed because the object was changed, or
+// created, since it was read.
+func IsConflict(err error) bool {
+	return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+}
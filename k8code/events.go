@@ -0,0 +1,150 @@
+package k8code
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidboren/k8-spot-daemon/eventobject"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// EventComponent is the source of the Events the daemon records.
+const EventComponent = "k8-spot-daemon"
+
+// EventObject is the object an Event is recorded on; see eventobject.Parse.
+type EventObject = eventobject.Object
+
+// ResolveEventObject fills in the UID of object, without which its Events are
+// listed by kubectl get events but not by kubectl describe.
+func ResolveEventObject(clientset *kubernetes.Clientset, object EventObject) (EventObject, error) {
+	root := "/apis/"
+	if !strings.Contains(object.APIVersion, "/") {
+		root = "/api/"
+	}
+	path := fmt.Sprintf("%v%v/namespaces/%v/%v/%v", root, object.APIVersion, object.Namespace,
+		object.Resource(), object.Name)
+	body, err := clientset.CoreV1().RESTClient().Get().AbsPath(path).DoRaw()
+	if err != nil {
+		return object, err
+	}
+	var resource struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &resource); err != nil {
+		return object, err
+	}
+	object.UID = resource.Metadata.UID
+	return object, nil
+}
+
+// SpotPolicyEventObject returns the EventObject of a SpotPolicy.
+func SpotPolicyEventObject(policy SpotPolicy) EventObject {
+	return EventObject{
+		APIVersion: SpotPolicyGroup + "/" + SpotPolicyVersion,
+		Kind:       "SpotPolicy",
+		Namespace:  policy.Namespace,
+		Name:       policy.Name,
+		UID:        string(policy.UID)}
+}
+
+// EventRecorder records Events through client-go's EventRecorder, and
+// aggregates repeated Events into a count as client-go's own sink does.  A nil
+// EventRecorder records nothing.
+type EventRecorder struct {
+	watcher  watch.Interface
+	recorder record.EventRecorder
+	pending  sync.WaitGroup
+}
+
+// eventFlushTimeout bounds how long Shutdown waits for Events still queued.
+const eventFlushTimeout = 10 * time.Second
+
+func NewEventRecorder(clientset *kubernetes.Clientset) *EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	sink := &typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")}
+	correlator := record.NewEventCorrelator(clock.RealClock{})
+	r := &EventRecorder{recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: EventComponent})}
+	r.watcher = broadcaster.StartEventWatcher(func(event *v1.Event) {
+		defer r.pending.Done()
+		writeEvent(sink, correlator, event)
+	})
+	return r
+}
+
+// writeEvent writes an Event, or the count of the Event it repeats, to sink.
+// An Event that cannot be written is logged and dropped rather than retried,
+// so that Shutdown is not held up by an unreachable API server.
+func writeEvent(sink record.EventSink, correlator *record.EventCorrelator, event *v1.Event) {
+	eventCopy := *event
+	result, err := correlator.EventCorrelate(&eventCopy)
+	if err != nil || result.Skip {
+		return
+	}
+	var written *v1.Event
+	update := result.Event.Count > 1
+	if update {
+		written, err = sink.Patch(result.Event, result.Patch)
+	}
+	if !update || errors.IsNotFound(err) {
+		result.Event.ResourceVersion = ""
+		written, err = sink.Create(result.Event)
+	}
+	if err != nil {
+		slog.Warn("could not record event", "reason", event.Reason,
+			"object", event.InvolvedObject.Name, "error", err)
+		return
+	}
+	correlator.UpdateState(written)
+}
+
+// Record records a Normal Event on object, or a Warning if warning is set.
+func (r *EventRecorder) Record(object EventObject, warning bool, reason string, message string) {
+	if r == nil {
+		return
+	}
+	eventType := v1.EventTypeNormal
+	if warning {
+		eventType = v1.EventTypeWarning
+	}
+	r.pending.Add(1)
+	r.recorder.Event(&v1.ObjectReference{
+		APIVersion: object.APIVersion,
+		Kind:       object.Kind,
+		Namespace:  object.Namespace,
+		Name:       object.Name,
+		UID:        types.UID(object.UID)}, eventType, reason, message)
+}
+
+// Shutdown waits up to eventFlushTimeout for the Events recorded so far to be
+// written, since client-go queues them, and then stops recording Events.
+func (r *EventRecorder) Shutdown() {
+	if r == nil {
+		return
+	}
+	written := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(eventFlushTimeout):
+		slog.Warn("stopped recording events before all were written", "timeout", eventFlushTimeout)
+	}
+	r.watcher.Stop()
+}
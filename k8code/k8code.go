@@ -1,35 +1,48 @@
 package k8code
 
 import (
+	"context"
+	"fmt"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	// "github.com/aws/aws-sdk-go/service/autoscaling"
 )
 
-func GetClientSet() *kubernetes.Clientset {
+func GetClientSet() (*kubernetes.Clientset, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		usr, _ := user.Current()
+		usr, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
 		dir := usr.HomeDir
 		config, err = clientcmd.BuildConfigFromFlags("", filepath.Join(dir, ".kube", "config"))
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
 	}
 
 	// creates the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
-	}
+	return kubernetes.NewForConfig(config)
+}
 
-	return clientset
+// IsTransient reports whether a Kubernetes API error is worth retrying, such
+// as a timeout, throttling or an internal server error.
+func IsTransient(err error) bool {
+	return errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) || errors.IsInternalError(err)
 }
 
 // func GetAutoscalerDetails(clientset *kubernetes.Clientset, autoscalerDeploymentName string) (int64, string) {
@@ -54,9 +67,16 @@ func GetClientSet() *kubernetes.Clientset {
 // 	return -1, ""
 // }
 
-func SummarizePods(clientset *kubernetes.Clientset) map[string]float64 {
-	pods, _ := clientset.CoreV1().Pods("").List(metav1.ListOptions{})
+// SummarizePods sums the memory requested by the pods outside kube-system.
+// maxMemoryRequestedGB is the largest request of a running or pending pod,
+// which a node must be able to hold, and maxMemoryUsedGB the largest of any.
+func SummarizePods(clientset *kubernetes.Clientset) (map[string]float64, error) {
+	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
 	var max_mem int64 = 0
+	var max_mem_requested int64 = 0
 	var tot_mem int64 = 0
 	var tot_mem_requested int64 = 0
 	var tot_running_pods int64 = 0
@@ -67,6 +87,9 @@ func SummarizePods(clientset *kubernetes.Clientset) map[string]float64 {
 			if max_mem < gb {
 				max_mem = gb
 			}
+			if (pod.Status.Phase == v1.PodRunning || pod.Status.Phase == v1.PodPending) && max_mem_requested < gb {
+				max_mem_requested = gb
+			}
 			if pod.Status.Phase == v1.PodRunning {
 				tot_mem += gb
 				tot_mem_requested += gb
@@ -79,6 +102,318 @@ func SummarizePods(clientset *kubernetes.Clientset) map[string]float64 {
 	return map[string]float64{
 		"totalMemoryRequestedGB": float64(tot_mem_requested) / (1024 * 1024 * 1000),
 		"totalMemoryUsedGB":      float64(tot_mem) / (1024 * 1024 * 1000),
+		"maxMemoryRequestedGB":   float64(max_mem_requested) / (1024 * 1024 * 1000),
 		"maxMemoryUsedGB":        float64(max_mem) / (1024 * 1024 * 1000),
-		"totalRunningPods":       float64(tot_running_pods)}
+		"totalRunningPods":       float64(tot_running_pods)}, nil
+}
+
+// GetNodeNamesByInstanceID maps each node's EC2 instance id (taken from its
+// aws:///<zone>/<instance-id> providerID) to the node name.
+func GetNodeNamesByInstanceID(clientset *kubernetes.Clientset) (map[string]string, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := map[string]string{}
+	for _, node := range nodes.Items {
+		instanceID := getInstanceID(node.Spec.ProviderID)
+		if len(instanceID) == 0 {
+			continue
+		}
+		nodeNames[instanceID] = node.Name
+	}
+	return nodeNames, nil
+}
+
+// getInstanceID returns the EC2 instance id at the end of an
+// aws:///<zone>/<instance-id> providerID, or an empty string if the node has
+// no providerID or it does not name an instance.
+func getInstanceID(providerID string) string {
+	instanceID := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(instanceID, "i-") {
+		return ""
+	}
+	return instanceID
+}
+
+// CordonNode marks a node unschedulable so no new pods land on it.
+func CordonNode(clientset *kubernetes.Clientset, nodeName string) error {
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = true
+	_, err = clientset.CoreV1().Nodes().Update(node)
+	return err
+}
+
+// UncordonNode makes a cordoned node schedulable again.
+func UncordonNode(clientset *kubernetes.Clientset, nodeName string) error {
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !node.Spec.Unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = false
+	_, err = clientset.CoreV1().Nodes().Update(node)
+	return err
+}
+
+// sleep waits for d, returning ctx's error early if it is cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func isDrainable(pod v1.Pod) bool {
+	if _, mirror := pod.Annotations["kubernetes.io/config.mirror"]; mirror {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+func getDrainablePods(clientset *kubernetes.Clientset, nodeName string) ([]v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": nodeName}).String()})
+	if err != nil {
+		return nil, err
+	}
+	drainable := []v1.Pod{}
+	for _, pod := range pods.Items {
+		if isDrainable(pod) {
+			drainable = append(drainable, pod)
+		}
+	}
+	return drainable, nil
+}
+
+// DrainNode evicts every pod on the node through the Eviction API, so that
+// PodDisruptionBudgets are respected, and waits for the pods to go away.
+// Evictions refused by a budget are retried until the timeout expires or ctx
+// is cancelled.
+func DrainNode(ctx context.Context, clientset *kubernetes.Clientset, nodeName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := getDrainablePods(clientset, nodeName)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out draining node '%v' with %v pods remaining", nodeName, len(pods))
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			err := clientset.CoreV1().Pods(pod.Namespace).Evict(&policy.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}})
+			if err != nil && !errors.IsNotFound(err) && !errors.IsTooManyRequests(err) {
+				return err
+			}
+		}
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func isReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// GetReadyInstanceIDs maps the EC2 instance id of each registered node to
+// whether the node reports a Ready condition.
+func GetReadyInstanceIDs(clientset *kubernetes.Clientset) (map[string]bool, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ready := map[string]bool{}
+	for _, node := range nodes.Items {
+		instanceID := getInstanceID(node.Spec.ProviderID)
+		if len(instanceID) == 0 {
+			continue
+		}
+		ready[instanceID] = isReady(node)
+	}
+	return ready, nil
+}
+
+// BudgetCheck describes how a single PodDisruptionBudget relates to the pods
+// running on a set of nodes that are about to be turned over.
+type BudgetCheck struct {
+	Name               string
+	AffectedPods       int
+	DisruptionsAllowed int
+}
+
+// DisruptionReport summarises the PodDisruptionBudgets covering the pods on a
+// set of nodes and how many of those pods could be evicted right now.
+type DisruptionReport struct {
+	AffectedPods  int
+	EvictablePods int
+	Budgets       []BudgetCheck
+}
+
+// Blocking returns the budgets that cover affected pods but currently allow no
+// disruptions at all.
+func (r DisruptionReport) Blocking() []BudgetCheck {
+	blocking := []BudgetCheck{}
+	for _, budget := range r.Budgets {
+		if budget.AffectedPods > 0 && budget.DisruptionsAllowed <= 0 {
+			blocking = append(blocking, budget)
+		}
+	}
+	return blocking
+}
+
+// Limiting returns the budgets that would be violated if every affected pod
+// were evicted at once.
+func (r DisruptionReport) Limiting() []BudgetCheck {
+	limiting := []BudgetCheck{}
+	for _, budget := range r.Budgets {
+		if budget.AffectedPods > budget.DisruptionsAllowed {
+			limiting = append(limiting, budget)
+		}
+	}
+	return limiting
+}
+
+// CheckDisruptionBudgets lists every PodDisruptionBudget in the cluster and
+// works out which of them cover the drainable pods on nodeNames.
+func CheckDisruptionBudgets(clientset *kubernetes.Clientset, nodeNames []string) (DisruptionReport, error) {
+	report := DisruptionReport{Budgets: []BudgetCheck{}}
+	affectedNodes := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		affectedNodes[nodeName] = true
+	}
+
+	pods, err := clientset.CoreV1().Pods("").List(metav1.ListOptions{})
+	if err != nil {
+		return report, err
+	}
+	affectedPods := []v1.Pod{}
+	for _, pod := range pods.Items {
+		if affectedNodes[pod.Spec.NodeName] && isDrainable(pod) {
+			affectedPods = append(affectedPods, pod)
+		}
+	}
+	report.AffectedPods = len(affectedPods)
+
+	budgets, err := clientset.PolicyV1beta1().PodDisruptionBudgets("").List(metav1.ListOptions{})
+	if err != nil {
+		return report, err
+	}
+	blockedPods := map[string]bool{}
+	for _, budget := range budgets.Items {
+		selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+		if err != nil {
+			return report, err
+		}
+		check := BudgetCheck{
+			Name:               budget.Namespace + "/" + budget.Name,
+			DisruptionsAllowed: int(budget.Status.PodDisruptionsAllowed)}
+		for _, pod := range affectedPods {
+			if pod.Namespace == budget.Namespace && selector.Matches(labels.Set(pod.Labels)) {
+				check.AffectedPods++
+				if check.DisruptionsAllowed <= 0 {
+					blockedPods[pod.Namespace+"/"+pod.Name] = true
+				}
+			}
+		}
+		if check.AffectedPods > 0 {
+			report.Budgets = append(report.Budgets, check)
+		}
+	}
+	report.EvictablePods = report.AffectedPods - len(blockedPods)
+	return report, nil
+}
+
+// Taints applied by aws-node-termination-handler, and the equivalent node
+// conditions reported by node-problem-detector style handlers.
+const (
+	SpotInterruptionTaint            = "aws-node-termination-handler/spot-itn"
+	RebalanceRecommendationTaint     = "aws-node-termination-handler/rebalance-recommendation"
+	SpotInterruptionCondition        = "SpotInterruption"
+	RebalanceRecommendationCondition = "RebalanceRecommendation"
+	InstanceTypeLabel                = "beta.kubernetes.io/instance-type"
+)
+
+// NodeInterruption is an interruption or rebalance signal found on a node.
+type NodeInterruption struct {
+	NodeName     string
+	InstanceID   string
+	InstanceType string
+	Rebalance    bool
+	Time         time.Time
+}
+
+// GetNodeInterruptions scans every node for interruption or rebalance taints
+// and conditions left by a termination handler.  Nodes without an instance id
+// are skipped, since their signals cannot be told apart.
+func GetNodeInterruptions(clientset *kubernetes.Clientset) ([]NodeInterruption, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	interruptions := []NodeInterruption{}
+	for _, node := range nodes.Items {
+		instanceID := getInstanceID(node.Spec.ProviderID)
+		if len(instanceID) == 0 {
+			continue
+		}
+		interruption := NodeInterruption{
+			NodeName:     node.Name,
+			InstanceID:   instanceID,
+			InstanceType: node.Labels[InstanceTypeLabel],
+			Time:         time.Now()}
+		interrupted := false
+		rebalance := false
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == SpotInterruptionTaint || taint.Key == RebalanceRecommendationTaint {
+				interrupted = interrupted || taint.Key == SpotInterruptionTaint
+				rebalance = rebalance || taint.Key == RebalanceRecommendationTaint
+				if !taint.TimeAdded.IsZero() {
+					interruption.Time = taint.TimeAdded.Time
+				}
+			}
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Status != v1.ConditionTrue {
+				continue
+			}
+			if condition.Type == SpotInterruptionCondition || condition.Type == RebalanceRecommendationCondition {
+				interrupted = interrupted || condition.Type == SpotInterruptionCondition
+				rebalance = rebalance || condition.Type == RebalanceRecommendationCondition
+				interruption.Time = condition.LastTransitionTime.Time
+			}
+		}
+		interruption.Rebalance = rebalance && !interrupted
+		if interrupted || rebalance {
+			interruptions = append(interruptions, interruption)
+		}
+	}
+	return interruptions, nil
 }
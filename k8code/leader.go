@@ -0,0 +1,50 @@
+package k8code
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaderCallbacks are notified as this replica gains or loses leadership, and
+// whenever a new leader is observed.  OnStartedLeading is given a context
+// that is cancelled as soon as leadership is lost.
+type LeaderCallbacks struct {
+	OnStartedLeading func(term context.Context)
+	OnStoppedLeading func()
+	OnNewLeader      func(identity string)
+}
+
+// NewLeaderElector returns client-go's elector for the
+// control-plane.alpha.kubernetes.io/leader annotation of the named ConfigMap,
+// campaigning under identity.  Its Run campaigns until ctx is cancelled or
+// leadership is lost, and releases leadership when it returns.
+func NewLeaderElector(clientset *kubernetes.Clientset, namespace string, name string, identity string,
+	callbacks LeaderCallbacks) (*leaderelection.LeaderElector, error) {
+
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.ConfigMapLock{
+			ConfigMapMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:        clientset.CoreV1(),
+			LockConfig:    resourcelock.ResourceLockConfig{Identity: identity}},
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: callbacks.OnStartedLeading,
+			OnStoppedLeading: callbacks.OnStoppedLeading,
+			OnNewLeader:      callbacks.OnNewLeader},
+		Name: namespace + "/" + name,
+	})
+}
@@ -0,0 +1,113 @@
+package k8code
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	SpotPolicyGroup    = "k8spotdaemon.io"
+	SpotPolicyVersion  = "v1alpha1"
+	SpotPolicyResource = "spotpolicies"
+)
+
+// SpotPolicyConstraints are the per-instance-type filters of a SpotPolicy.
+// Unset fields fall back to the daemon's own configuration.
+type SpotPolicyConstraints struct {
+	MinGB                  *float64 `json:"minGB,omitempty"`
+	MaxCV                  *float64 `json:"maxCV,omitempty"`
+	MaxDollarsPerGB        *float64 `json:"maxDollarsPerGB,omitempty"`
+	MaxDollarsPerCPU       *float64 `json:"maxDollarsPerCPU,omitempty"`
+	MemoryBufferPercentage *float64 `json:"memoryBufferPercentage,omitempty"`
+	MaxInterruptionBucket  *int     `json:"maxInterruptionBucket,omitempty"`
+}
+
+// SpotPolicyBudgets bound what a SpotPolicy may spend, both per hour and on
+// turning nodes over.
+type SpotPolicyBudgets struct {
+	MaxTotalDollarsPerHour *float64 `json:"maxTotalDollarsPerHour,omitempty"`
+	MaxAutoscalingNodes    *int     `json:"maxAutoscalingNodes,omitempty"`
+	SwitchingCostPerNode   *float64 `json:"switchingCostPerNode,omitempty"`
+	AmortizationHours      *float64 `json:"amortizationHours,omitempty"`
+}
+
+// SpotPolicyBidStrategy controls how a SpotPolicy's bid price is chosen.
+type SpotPolicyBidStrategy struct {
+	HistoricalHours              *float64 `json:"historicalHours,omitempty"`
+	MinMarkupPercentage          *float64 `json:"minMarkupPercentage,omitempty"`
+	MinPriceDifferencePercentage *float64 `json:"minPriceDifferencePercentage,omitempty"`
+}
+
+type SpotPolicySpec struct {
+	AutoScalingGroupName      string                `json:"autoScalingGroupName"`
+	LaunchConfigurationPrefix string                `json:"launchConfigurationPrefix"`
+	Constraints               SpotPolicyConstraints `json:"constraints,omitempty"`
+	Budgets                   SpotPolicyBudgets     `json:"budgets,omitempty"`
+	BidStrategy               SpotPolicyBidStrategy `json:"bidStrategy,omitempty"`
+}
+
+type SpotPolicyStatus struct {
+	CurrentInstanceType     string      `json:"currentInstanceType,omitempty"`
+	CurrentBid              string      `json:"currentBid,omitempty"`
+	EstimatedDollarsPerHour string      `json:"estimatedDollarsPerHour,omitempty"`
+	LastDecision            string      `json:"lastDecision,omitempty"`
+	LastDecisionReason      string      `json:"lastDecisionReason,omitempty"`
+	LastDecisionTime        metav1.Time `json:"lastDecisionTime,omitempty"`
+	LastError               string      `json:"lastError,omitempty"`
+	ObservedGeneration      int64       `json:"observedGeneration,omitempty"`
+}
+
+// SpotPolicy is the spotpolicies.k8spotdaemon.io custom resource, described by
+// deploy/spotpolicy-crd.yaml.
+type SpotPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SpotPolicySpec   `json:"spec"`
+	Status SpotPolicyStatus `json:"status,omitempty"`
+}
+
+type SpotPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SpotPolicy `json:"items"`
+}
+
+func spotPolicyPath(namespace string) string {
+	if len(namespace) == 0 {
+		return fmt.Sprintf("/apis/%v/%v/%v", SpotPolicyGroup, SpotPolicyVersion, SpotPolicyResource)
+	}
+	return fmt.Sprintf("/apis/%v/%v/namespaces/%v/%v", SpotPolicyGroup, SpotPolicyVersion, namespace, SpotPolicyResource)
+}
+
+// ListSpotPolicies lists the SpotPolicies in namespace, or in every namespace
+// if it is empty.
+func ListSpotPolicies(clientset *kubernetes.Clientset, namespace string) ([]SpotPolicy, error) {
+	body, err := clientset.CoreV1().RESTClient().Get().AbsPath(spotPolicyPath(namespace)).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	var list SpotPolicyList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// UpdateSpotPolicyStatus writes the policy's status through the status
+// subresource, leaving its spec untouched.
+func UpdateSpotPolicyStatus(clientset *kubernetes.Clientset, policy SpotPolicy) error {
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().RESTClient().Put().
+		AbsPath(spotPolicyPath(policy.Namespace), policy.Name, "status").
+		Body(body).
+		DoRaw()
+	return err
+}
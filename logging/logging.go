@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Formats and levels accepted by Setup.
+var (
+	Formats = []string{"text", "json"}
+	Levels  = []string{"debug", "info", "warn", "error"}
+)
+
+func parseLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return parsed, fmt.Errorf("unknown log level '%v' (expected one of %v)", level, strings.Join(Levels, ", "))
+	}
+	return parsed, nil
+}
+
+// Check reports whether format and level can be passed to Setup.
+func Check(format string, level string) error {
+	if _, err := parseLevel(level); err != nil {
+		return err
+	}
+	for _, known := range Formats {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log format '%v' (expected one of %v)", format, strings.Join(Formats, ", "))
+}
+
+// Setup makes the default slog logger write records at or above level to
+// stdout, as logfmt-style text or as one JSON object per line.
+func Setup(format string, level string) error {
+	return SetupWriter(os.Stdout, format, level)
+}
+
+// SetupWriter is Setup writing to w, for commands whose own output is on
+// stdout.
+func SetupWriter(w io.Writer, format string, level string) error {
+	if err := Check(format, level); err != nil {
+		return err
+	}
+	parsed, _ := parseLevel(level)
+	options := &slog.HandlerOptions{Level: parsed}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
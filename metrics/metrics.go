@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/davidboren/k8-spot-daemon/pricing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "k8_spot_daemon"
+
+var (
+	spotPrice = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spot_price_dollars",
+		Help:      "Time-weighted average spot price per hour of an instance type.",
+	}, []string{"instance_type"})
+	spotPriceStdDev = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spot_price_stddev_dollars",
+		Help:      "Standard deviation of the spot price of an instance type.",
+	}, []string{"instance_type"})
+	spotPriceCoefVar = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spot_price_coef_var",
+		Help:      "Coefficient of variation of the spot price of an instance type.",
+	}, []string{"instance_type"})
+
+	memoryRequested = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_requested_gb",
+		Help:      "Memory requested by running and pending pods outside kube-system.",
+	})
+	memoryUsed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memory_used_gb",
+		Help:      "Memory requested by running pods outside kube-system.",
+	})
+	maxPodMemory = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "max_pod_memory_gb",
+		Help:      "Largest memory request of a single pod outside kube-system.",
+	})
+	runningPods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "running_pods",
+		Help:      "Number of running pods outside kube-system.",
+	})
+
+	instanceType = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "instance_type_info",
+		Help:      "Set to 1 for the current and the recommended instance type of an autoscaling group.",
+	}, []string{"autoscaling_group", "role", "instance_type"})
+	bid = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bid_dollars",
+		Help:      "Current and recommended spot bid per hour of an autoscaling group.",
+	}, []string{"autoscaling_group", "role"})
+	dollarsPerHour = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "estimated_dollars_per_hour",
+		Help:      "Estimated hourly cost of an autoscaling group after the last decision.",
+	}, []string{"autoscaling_group"})
+	decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decisions_total",
+		Help:      "Evaluations of an autoscaling group by outcome.",
+	}, []string{"autoscaling_group", "outcome"})
+
+	awsDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "aws_api_duration_seconds",
+		Help:      "Latency of AWS API calls, including retries, by service and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+	awsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "aws_api_errors_total",
+		Help:      "Failed AWS API calls by service and operation.",
+	}, []string{"service", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(spotPrice, spotPriceStdDev, spotPriceCoefVar,
+		memoryRequested, memoryUsed, maxPodMemory, runningPods,
+		instanceType, bid, dollarsPerHour, decisions,
+		awsDuration, awsErrors)
+}
+
+// Serve exposes /metrics, along with any other handlers by path, on address
+// until ctx is cancelled.  An empty address disables it.
+func Serve(ctx context.Context, address string, handlers map[string]http.Handler) {
+	if len(address) == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	for path, handler := range handlers {
+		mux.Handle(path, handler)
+	}
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		slog.Info("serving metrics and status", "address", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+}
+
+// InstrumentSession records the latency and errors of every AWS API call made
+// through sess.
+func InstrumentSession(sess *session.Session) {
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "k8-spot-daemon/metrics",
+		Fn: func(r *request.Request) {
+			service := r.ClientInfo.ServiceName
+			operation := r.Operation.Name
+			awsDuration.WithLabelValues(service, operation).Observe(time.Since(r.Time).Seconds())
+			if r.Error != nil {
+				awsErrors.WithLabelValues(service, operation).Inc()
+			}
+		},
+	})
+}
+
+// RecordPricing replaces the per-instance-type price gauges with priceList.
+func RecordPricing(priceList []pricing.FullSummary) {
+	spotPrice.Reset()
+	spotPriceStdDev.Reset()
+	spotPriceCoefVar.Reset()
+	for _, instanceSummary := range priceList {
+		spotPrice.WithLabelValues(instanceSummary.Name).Set(instanceSummary.Price)
+		spotPriceStdDev.WithLabelValues(instanceSummary.Name).Set(instanceSummary.StdDev)
+		spotPriceCoefVar.WithLabelValues(instanceSummary.Name).Set(instanceSummary.CoefVar)
+	}
+}
+
+// RecordPods records the cluster demand reported by k8code.SummarizePods.
+func RecordPods(podSummary map[string]float64) {
+	memoryRequested.Set(podSummary["totalMemoryRequestedGB"])
+	memoryUsed.Set(podSummary["totalMemoryUsedGB"])
+	maxPodMemory.Set(podSummary["maxMemoryRequestedGB"])
+	runningPods.Set(podSummary["totalRunningPods"])
+}
+
+var (
+	instanceTypesMutex sync.Mutex
+	instanceTypes      = map[[2]string]string{}
+)
+
+func setInstanceType(autoScalingGroupName string, role string, name string) {
+	instanceTypesMutex.Lock()
+	defer instanceTypesMutex.Unlock()
+	key := [2]string{autoScalingGroupName, role}
+	if previous, found := instanceTypes[key]; found && previous != name {
+		instanceType.DeleteLabelValues(autoScalingGroupName, role, previous)
+	}
+	instanceTypes[key] = name
+	instanceType.WithLabelValues(autoScalingGroupName, role, name).Set(1)
+}
+
+// Decision is what RecordDecision needs to know about an evaluation.
+type Decision struct {
+	AutoScalingGroupName    string
+	Outcome                 string
+	CurrentInstanceType     string
+	CurrentBid              float64
+	RecommendedInstanceType string
+	RecommendedBid          float64
+	EstimatedDollarsPerHour float64
+}
+
+// RecordDecision counts an evaluation by outcome and records the instance
+// types, bids and hourly cost it left the autoscaling group with.
+func RecordDecision(decision Decision) {
+	group := decision.AutoScalingGroupName
+	decisions.WithLabelValues(group, decision.Outcome).Inc()
+	setInstanceType(group, "current", decision.CurrentInstanceType)
+	setInstanceType(group, "recommended", decision.RecommendedInstanceType)
+	bid.WithLabelValues(group, "current").Set(decision.CurrentBid)
+	bid.WithLabelValues(group, "recommended").Set(decision.RecommendedBid)
+	dollarsPerHour.WithLabelValues(group).Set(decision.EstimatedDollarsPerHour)
+}
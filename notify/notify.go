@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Events a webhook can be routed.  Decision notifications are named after the
+// decision's outcome, and EventRolledBack after rolling a switch back whose
+// nodes did not become Ready; EventErrors is sent once the daemon has failed
+// ErrorThreshold iterations in a row and EventRecovered once it succeeds again.
+const (
+	EventSwitch      = "switch"
+	EventConvert     = "convert-to-spot"
+	EventBidChange   = "bid-change"
+	EventBlocked     = "blocked"
+	EventNoChange    = "no-change"
+	EventApplyFailed = "apply-failed"
+	EventRolledBack  = "rolled-back"
+	EventErrors      = "errors"
+	EventRecovered   = "recovered"
+)
+
+// Events lists every event a webhook can be routed, and DefaultEvents those a
+// webhook receives if it does not list any.
+var (
+	Events = []string{EventSwitch, EventConvert, EventBidChange, EventBlocked, EventNoChange,
+		EventApplyFailed, EventRolledBack, EventErrors, EventRecovered}
+	DefaultEvents = []string{EventSwitch, EventConvert, EventApplyFailed, EventRolledBack, EventErrors, EventRecovered}
+)
+
+// LaunchConfiguration is one side of a change to an autoscaling group: the
+// launch configuration it runs before or after the decision.
+type LaunchConfiguration struct {
+	Name           string  `json:"launchConfiguration,omitempty"`
+	InstanceType   string  `json:"instanceType"`
+	SpotPrice      string  `json:"spotPrice"`
+	DollarsPerHour float64 `json:"dollarsPerHour"`
+}
+
+func (lc LaunchConfiguration) describe() string {
+	description := fmt.Sprintf("%v at %v", lc.InstanceType, lc.SpotPrice)
+	if len(lc.Name) > 0 {
+		description += "\n" + lc.Name
+	}
+	return description
+}
+
+// SwitchingCost is what turning an autoscaling group's nodes over was
+// estimated to cost, and to save over the amortisation horizon.
+type SwitchingCost struct {
+	NodesAffected  int     `json:"nodesAffected"`
+	Cost           float64 `json:"cost"`
+	HourlySavings  float64 `json:"hourlySavings"`
+	HorizonSavings float64 `json:"horizonSavings"`
+}
+
+// Notification is the JSON payload of the generic format.
+type Notification struct {
+	Event            string               `json:"event"`
+	Time             time.Time            `json:"time"`
+	Owner            string               `json:"owner,omitempty"`
+	AutoScalingGroup string               `json:"autoScalingGroup,omitempty"`
+	Reason           string               `json:"reason,omitempty"`
+	Monitor          bool                 `json:"monitor"`
+	Original         *LaunchConfiguration `json:"original,omitempty"`
+	New              *LaunchConfiguration `json:"new,omitempty"`
+	SwitchingCost    *SwitchingCost       `json:"switchingCost,omitempty"`
+	Failures         int                  `json:"failures,omitempty"`
+	Errors           []string             `json:"errors,omitempty"`
+
+	// Suppressed counts the notifications of the same event the webhook's
+	// rate limit dropped since the last one of that event it delivered.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// Summary describes the notification in a line, for chat messages.
+func (n Notification) Summary() string {
+	summary := ""
+	if n.Monitor {
+		summary = "[monitor] "
+	}
+	switch n.Event {
+	case EventErrors:
+		summary += fmt.Sprintf("k8-spot-daemon failed %v iterations in a row", n.Failures)
+		if len(n.Errors) > 0 {
+			summary += ": " + n.Errors[len(n.Errors)-1]
+		}
+	case EventRecovered:
+		summary += "k8-spot-daemon recovered: " + n.Reason
+	default:
+		summary += fmt.Sprintf("autoscaling group '%v' %v", n.AutoScalingGroup, n.Event)
+		if n.Original != nil && n.New != nil {
+			summary += fmt.Sprintf(": %v (%v, $%.3f/hour) -> %v (%v, $%.3f/hour)",
+				n.Original.InstanceType, n.Original.SpotPrice, n.Original.DollarsPerHour,
+				n.New.InstanceType, n.New.SpotPrice, n.New.DollarsPerHour)
+		}
+		if len(n.Reason) > 0 {
+			summary += " because " + n.Reason
+		}
+	}
+	if n.Suppressed > 0 {
+		summary += fmt.Sprintf(" (%v earlier notifications were rate limited)", n.Suppressed)
+	}
+	return summary
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []slackField `json:"fields"`
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+// slackPayload formats a notification for a Slack incoming webhook.
+func slackPayload(n Notification) slackMessage {
+	color := "good"
+	switch n.Event {
+	case EventBlocked, EventNoChange:
+		color = "warning"
+	case EventApplyFailed, EventErrors:
+		color = "danger"
+	}
+	fields := []slackField{}
+	if n.Original != nil {
+		fields = append(fields, slackField{Title: "Before", Short: true, Value: n.Original.describe()})
+	}
+	if n.New != nil {
+		fields = append(fields, slackField{Title: "After", Short: true, Value: n.New.describe()})
+	}
+	if n.SwitchingCost != nil && n.SwitchingCost.NodesAffected > 0 {
+		fields = append(fields, slackField{Title: "Switching cost", Short: true, Value: fmt.Sprintf(
+			"$%.2f for %v nodes, saving $%.2f over the horizon",
+			n.SwitchingCost.Cost, n.SwitchingCost.NodesAffected, n.SwitchingCost.HorizonSavings)})
+	}
+	if len(n.Errors) > 1 {
+		fields = append(fields, slackField{Title: "Errors", Value: strings.Join(n.Errors, "\n")})
+	}
+	message := slackMessage{Text: n.Summary()}
+	if len(fields) > 0 {
+		message.Attachments = []slackAttachment{{Color: color, Fields: fields}}
+	}
+	return message
+}
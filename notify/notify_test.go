@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlackPayload(t *testing.T) {
+	original := &LaunchConfiguration{Name: "nodes-r4.xlarge", InstanceType: "r4.xlarge", SpotPrice: "0.10", DollarsPerHour: 0.5}
+	switched := &LaunchConfiguration{Name: "nodes-r5.xlarge", InstanceType: "r5.xlarge", SpotPrice: "0.08", DollarsPerHour: 0.4}
+	cases := []struct {
+		name         string
+		notification Notification
+		wantText     []string
+		wantColor    string
+		wantFields   []string
+	}{
+		{"switch", Notification{Event: EventSwitch, AutoScalingGroup: "nodes", Original: original, New: switched,
+			SwitchingCost: &SwitchingCost{NodesAffected: 3, Cost: 0.6, HorizonSavings: 2.4}, Reason: "cheaper"},
+			[]string{"autoscaling group 'nodes' switch", "r4.xlarge (0.10, $0.500/hour) -> r5.xlarge (0.08, $0.400/hour)", "because cheaper"},
+			"good", []string{"Before", "After", "Switching cost"}},
+		{"blocked in monitor mode", Notification{Event: EventBlocked, AutoScalingGroup: "nodes", Monitor: true, Reason: "pdb"},
+			[]string{"[monitor] autoscaling group 'nodes' blocked because pdb"}, "", nil},
+		{"apply failed", Notification{Event: EventApplyFailed, AutoScalingGroup: "nodes", Original: original, New: switched},
+			[]string{"apply-failed"}, "danger", []string{"Before", "After"}},
+		{"errors", Notification{Event: EventErrors, Failures: 3, Errors: []string{"throttled", "timed out"}},
+			[]string{"failed 3 iterations in a row: timed out"}, "danger", []string{"Errors"}},
+		{"suppressed", Notification{Event: EventRecovered, Reason: "iteration succeeded", Suppressed: 2},
+			[]string{"recovered: iteration succeeded", "(2 earlier notifications were rate limited)"}, "", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message := slackPayload(c.notification)
+			for _, text := range c.wantText {
+				if !strings.Contains(message.Text, text) {
+					t.Errorf("text %q does not contain %q", message.Text, text)
+				}
+			}
+			if len(c.wantFields) == 0 {
+				if len(message.Attachments) > 0 {
+					t.Errorf("attachments = %+v, want none", message.Attachments)
+				}
+				return
+			}
+			if len(message.Attachments) != 1 {
+				t.Fatalf("attachments = %+v, want one", message.Attachments)
+			}
+			attachment := message.Attachments[0]
+			if attachment.Color != c.wantColor {
+				t.Errorf("color = %q, want %q", attachment.Color, c.wantColor)
+			}
+			titles := []string{}
+			for _, field := range attachment.Fields {
+				titles = append(titles, field.Title)
+			}
+			if strings.Join(titles, ",") != strings.Join(c.wantFields, ",") {
+				t.Errorf("fields = %v, want %v", titles, c.wantFields)
+			}
+		})
+	}
+}
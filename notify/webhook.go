@@ -0,0 +1,310 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Webhook formats, and the defaults of the settings a webhook may leave out.
+const (
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+
+	DefaultRetries          = 3
+	DefaultRateLimitPerHour = 30
+	DefaultErrorThreshold   = 3
+)
+
+// retryBackoff is the wait before the first retry of a delivery, doubling
+// with every further attempt.  It is a variable so that tests can shorten it.
+var retryBackoff = time.Second
+
+// closeTimeout bounds how long Close waits for queued notifications.
+const closeTimeout = 10 * time.Second
+
+// queueLength bounds the notifications waiting for delivery; beyond it new
+// ones are dropped rather than holding up the daemon.
+const queueLength = 100
+
+// Webhook is an HTTP endpoint that notifications are POSTed to.
+type Webhook struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Format  string            `yaml:"format"`
+	Events  []string          `yaml:"events"`
+	Headers map[string]string `yaml:"headers"`
+	// Retries is how many times a delivery failing with a network error, a
+	// 429 or a 5xx response is retried.
+	Retries *int `yaml:"retries"`
+	// RateLimitPerHour caps the notifications delivered per hour, with bursts
+	// of up to the same number; 0 disables the limit.
+	RateLimitPerHour *int `yaml:"rateLimitPerHour"`
+}
+
+// Config is the contents of the notifications file.
+type Config struct {
+	Webhooks []Webhook `yaml:"webhooks"`
+	// ErrorThreshold is how many iterations must fail in a row before an
+	// EventErrors notification is sent.
+	ErrorThreshold int `yaml:"errorThreshold"`
+}
+
+// ReadConfig loads and validates a notifications file, filling in defaults.
+func ReadConfig(path string) (Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	config := Config{}
+	if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+		return Config{}, fmt.Errorf("could not parse notifications file '%v': %w", path, err)
+	}
+	if config.ErrorThreshold == 0 {
+		config.ErrorThreshold = DefaultErrorThreshold
+	}
+
+	problems := []string{}
+	if config.ErrorThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("errorThreshold must not be negative (got %v)", config.ErrorThreshold))
+	}
+	for i := range config.Webhooks {
+		webhook := &config.Webhooks[i]
+		if len(webhook.Name) == 0 {
+			webhook.Name = fmt.Sprintf("webhook %v", i+1)
+		}
+		if parsed, err := url.Parse(webhook.URL); err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("%v: url '%v' is not an absolute URL", webhook.Name, webhook.URL))
+		}
+		if len(webhook.Format) == 0 {
+			webhook.Format = FormatGeneric
+		}
+		if webhook.Format != FormatGeneric && webhook.Format != FormatSlack {
+			problems = append(problems, fmt.Sprintf("%v: format '%v' is not '%v' or '%v'",
+				webhook.Name, webhook.Format, FormatGeneric, FormatSlack))
+		}
+		if len(webhook.Events) == 0 {
+			webhook.Events = DefaultEvents
+		}
+		for _, event := range webhook.Events {
+			if !contains(Events, event) {
+				problems = append(problems, fmt.Sprintf("%v: event '%v' is not one of %v",
+					webhook.Name, event, strings.Join(Events, ", ")))
+			}
+		}
+		if webhook.Retries == nil {
+			retries := DefaultRetries
+			webhook.Retries = &retries
+		}
+		if webhook.RateLimitPerHour == nil {
+			rateLimit := DefaultRateLimitPerHour
+			webhook.RateLimitPerHour = &rateLimit
+		}
+		if *webhook.Retries < 0 || *webhook.RateLimitPerHour < 0 {
+			problems = append(problems, fmt.Sprintf("%v: retries and rateLimitPerHour must not be negative", webhook.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return Config{}, fmt.Errorf("invalid notifications file '%v':\n  %v", path, strings.Join(problems, "\n  "))
+	}
+	return config, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, each := range values {
+		if each == value {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter is a token bucket refilled at its capacity per hour.
+type rateLimiter struct {
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(perHour int) *rateLimiter {
+	return &rateLimiter{capacity: float64(perHour), tokens: float64(perHour), last: time.Now()}
+}
+
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l.capacity == 0 {
+		return true
+	}
+	l.tokens += now.Sub(l.last).Hours() * l.capacity
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+type endpoint struct {
+	webhook Webhook
+	limiter *rateLimiter
+	// suppressed counts, by event, the notifications the rate limit dropped
+	// since the last one of the same event was delivered.
+	suppressed map[string]int
+}
+
+// admit reports whether notification is routed to the endpoint and within its
+// rate limit, counting it as suppressed if it is not within the limit.  An
+// admitted notification carries the count of its event's suppressed ones.
+func (e *endpoint) admit(notification *Notification, now time.Time) bool {
+	if !contains(e.webhook.Events, notification.Event) {
+		return false
+	}
+	if !e.limiter.allow(now) {
+		e.suppressed[notification.Event]++
+		slog.Warn("webhook rate limit reached, dropping notification",
+			"webhook", e.webhook.Name, "event", notification.Event)
+		return false
+	}
+	notification.Suppressed = e.suppressed[notification.Event]
+	return true
+}
+
+// Notifier delivers notifications to the webhooks they are routed to, in the
+// background and in order.  A nil Notifier sends nothing.
+type Notifier struct {
+	ErrorThreshold int
+
+	client    *http.Client
+	endpoints []*endpoint
+	queue     chan Notification
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewNotifier starts delivering notifications to config's webhooks with client.
+func NewNotifier(config Config, client *http.Client) *Notifier {
+	n := &Notifier{
+		ErrorThreshold: config.ErrorThreshold,
+		client:         client,
+		queue:          make(chan Notification, queueLength),
+		closing:        make(chan struct{}),
+		done:           make(chan struct{})}
+	for _, webhook := range config.Webhooks {
+		n.endpoints = append(n.endpoints, &endpoint{webhook: webhook,
+			limiter: newRateLimiter(*webhook.RateLimitPerHour), suppressed: map[string]int{}})
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues a notification without waiting for its delivery.
+func (n *Notifier) Notify(notification Notification) {
+	if n == nil {
+		return
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+	select {
+	case n.queue <- notification:
+	default:
+		slog.Warn("notification queue is full, dropping notification", "event", notification.Event)
+	}
+}
+
+// Close stops accepting notifications and waits, up to closeTimeout, for the
+// queued ones to be delivered.  Deliveries are no longer retried once Close has
+// been called.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() {
+		close(n.closing)
+		close(n.queue)
+	})
+	select {
+	case <-n.done:
+	case <-time.After(closeTimeout):
+		slog.Warn("gave up waiting for notifications to be delivered")
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		for _, endpoint := range n.endpoints {
+			notification := notification
+			if !endpoint.admit(&notification, time.Now()) {
+				continue
+			}
+			if err := n.deliver(endpoint.webhook, notification); err != nil {
+				slog.Warn("could not deliver notification", "webhook", endpoint.webhook.Name,
+					"event", notification.Event, "error", err)
+				continue
+			}
+			delete(endpoint.suppressed, notification.Event)
+		}
+	}
+}
+
+// deliver POSTs a notification to a webhook, retrying network errors, 429 and
+// 5xx responses with a doubling backoff until the Notifier is closed.
+func (n *Notifier) deliver(webhook Webhook, notification Notification) error {
+	var payload interface{} = notification
+	if webhook.Format == FormatSlack {
+		payload = slackPayload(notification)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(webhook, body)
+		if err == nil || !retry || attempt >= *webhook.Retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.closing:
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(webhook Webhook, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded '%v'", response.Status)
+}
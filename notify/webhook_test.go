@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notifications.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	config, err := ReadConfig(writeConfig(t, "webhooks:\n- url: https://hooks.example.com/a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ErrorThreshold != DefaultErrorThreshold {
+		t.Errorf("ErrorThreshold = %v, want %v", config.ErrorThreshold, DefaultErrorThreshold)
+	}
+	webhook := config.Webhooks[0]
+	if webhook.Name != "webhook 1" || webhook.Format != FormatGeneric {
+		t.Errorf("Name, Format = %q, %q, want %q, %q", webhook.Name, webhook.Format, "webhook 1", FormatGeneric)
+	}
+	if strings.Join(webhook.Events, ",") != strings.Join(DefaultEvents, ",") {
+		t.Errorf("Events = %v, want %v", webhook.Events, DefaultEvents)
+	}
+	if *webhook.Retries != DefaultRetries || *webhook.RateLimitPerHour != DefaultRateLimitPerHour {
+		t.Errorf("Retries, RateLimitPerHour = %v, %v, want %v, %v",
+			*webhook.Retries, *webhook.RateLimitPerHour, DefaultRetries, DefaultRateLimitPerHour)
+	}
+}
+
+func TestReadConfigValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		problem  string
+	}{
+		{"valid", "webhooks:\n- url: https://hooks.example.com/a\n  format: slack\n  events: [switch, errors]\n  retries: 0\n  rateLimitPerHour: 0\n", ""},
+		{"relative url", "webhooks:\n- url: /hooks/a\n", "is not an absolute URL"},
+		{"unknown format", "webhooks:\n- url: https://hooks.example.com/a\n  format: teams\n", "format 'teams'"},
+		{"unknown event", "webhooks:\n- url: https://hooks.example.com/a\n  events: [reboot]\n", "event 'reboot'"},
+		{"negative retries", "webhooks:\n- url: https://hooks.example.com/a\n  retries: -1\n", "must not be negative"},
+		{"negative threshold", "errorThreshold: -2\n", "errorThreshold must not be negative"},
+		{"unknown key", "webhook: []\n", "could not parse"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ReadConfig(writeConfig(t, c.contents))
+			if len(c.problem) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.problem) {
+				t.Fatalf("error = %v, want one containing %q", err, c.problem)
+			}
+		})
+	}
+	if _, err := ReadConfig(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error = %v, want not exist", err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	cases := []struct {
+		name    string
+		perHour int
+		offsets []time.Duration
+		allowed []bool
+	}{
+		{"unlimited", 0, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+		{"burst then empty", 2, []time.Duration{0, 0, 0, 0}, []bool{true, true, false, false}},
+		{"refills at its capacity per hour", 2, []time.Duration{0, 0, 0, 30 * time.Minute, 30 * time.Minute},
+			[]bool{true, true, false, true, false}},
+		{"refill is capped", 1, []time.Duration{0, 5 * time.Hour, 5 * time.Hour}, []bool{true, true, false}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			limiter := newRateLimiter(c.perHour)
+			limiter.last = start
+			for i, offset := range c.offsets {
+				if got := limiter.allow(start.Add(offset)); got != c.allowed[i] {
+					t.Errorf("call %v at %v: allow = %v, want %v", i, offset, got, c.allowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointAdmit(t *testing.T) {
+	start := time.Now()
+	e := &endpoint{webhook: Webhook{Name: "hook", Events: []string{EventSwitch, EventBlocked}},
+		limiter: newRateLimiter(1), suppressed: map[string]int{}}
+	e.limiter.last = start
+	cases := []struct {
+		event          string
+		offset         time.Duration
+		want           bool
+		wantSuppressed int
+	}{
+		{EventSwitch, 0, true, 0},
+		{EventSwitch, 0, false, 0},
+		{EventBlocked, 0, false, 0},
+		{EventBlocked, 0, false, 0},
+		{EventErrors, 0, false, 0},
+		{EventSwitch, time.Hour, true, 1},
+		{EventBlocked, 2 * time.Hour, true, 2},
+	}
+	for i, c := range cases {
+		notification := Notification{Event: c.event}
+		if got := e.admit(&notification, start.Add(c.offset)); got != c.want {
+			t.Errorf("notification %v (%v): admit = %v, want %v", i, c.event, got, c.want)
+		}
+		if got := notification.Suppressed; got != c.wantSuppressed {
+			t.Errorf("notification %v (%v): suppressed = %v, want %v", i, c.event, got, c.wantSuppressed)
+		}
+	}
+	if e.suppressed[EventErrors] != 0 {
+		t.Errorf("an event the webhook is not routed counted as suppressed")
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = time.Second }()
+
+	cases := []struct {
+		name         string
+		statuses     []int
+		retries      int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", []int{200}, 3, 1, false},
+		{"5xx then success", []int{503, 500, 200}, 3, 3, false},
+		{"429 then success", []int{429, 204}, 3, 2, false},
+		{"4xx is not retried", []int{400, 200}, 3, 1, true},
+		{"retries run out", []int{502, 502, 502}, 2, 3, true},
+		{"no retries", []int{500, 200}, 0, 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("Authorization header = %q", r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(c.statuses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			retries := c.retries
+			webhook := Webhook{Name: c.name, URL: server.URL, Format: FormatGeneric, Retries: &retries,
+				Headers: map[string]string{"Authorization": "Bearer token"}}
+			n := &Notifier{client: server.Client()}
+			err := n.deliver(webhook, Notification{Event: EventSwitch})
+			if (err != nil) != c.wantErr {
+				t.Errorf("error = %v, want error %v", err, c.wantErr)
+			}
+			if attempts != c.wantAttempts {
+				t.Errorf("attempts = %v, want %v", attempts, c.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDeliverStopsRetryingOnClose(t *testing.T) {
+	retryBackoff = time.Hour
+	defer func() { retryBackoff = time.Second }()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	retries := 3
+	webhook := Webhook{Name: "hook", URL: server.URL, Format: FormatGeneric, Retries: &retries}
+	n := &Notifier{client: server.Client(), closing: make(chan struct{})}
+	close(n.closing)
+	if err := n.deliver(webhook, Notification{Event: EventSwitch}); err == nil {
+		t.Errorf("deliver succeeded against a failing webhook")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %v, want 1 once closed", attempts)
+	}
+}
+
+func TestNotifierRoutesAndCountsSuppressed(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer server.Close()
+
+	retries, rateLimit := 0, 1
+	n := NewNotifier(Config{Webhooks: []Webhook{{Name: "hook", URL: server.URL, Format: FormatGeneric,
+		Events: []string{EventSwitch}, Retries: &retries, RateLimitPerHour: &rateLimit}}}, server.Client())
+	n.Notify(Notification{Event: EventSwitch, AutoScalingGroup: "first"})
+	n.Notify(Notification{Event: EventBlocked, AutoScalingGroup: "not routed"})
+	n.Notify(Notification{Event: EventSwitch, AutoScalingGroup: "rate limited"})
+	n.Close()
+	close(received)
+
+	bodies := []string{}
+	for body := range received {
+		bodies = append(bodies, body)
+	}
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"autoScalingGroup":"first"`) {
+		t.Fatalf("delivered %v, want only the first switch", bodies)
+	}
+	if suppressed := n.endpoints[0].suppressed; !reflect.DeepEqual(suppressed, map[string]int{EventSwitch: 1}) {
+		t.Errorf("suppressed = %v, want 1 switch", suppressed)
+	}
+}
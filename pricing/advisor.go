@@ -0,0 +1,74 @@
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// UnknownInterruptionBucket marks an instance type with no interruption
+// frequency data.  Being below every real bucket, it passes any
+// maxInterruptionBucket and is not penalized; rejectUnknownFrequency rejects
+// such types instead.
+const UnknownInterruptionBucket = -1
+
+// InterruptionBucket is one of the Spot Advisor interruption-frequency ranges,
+// e.g. "<5%" or "5-10%".
+type InterruptionBucket struct {
+	Index int     `json:"index"`
+	Label string  `json:"label"`
+	Max   float64 `json:"max"`
+}
+
+type advisorEntry struct {
+	Savings int `json:"s"`
+	Range   int `json:"r"`
+}
+
+// advisorData follows the layout of the Spot Advisor's spot-advisor-data.json.
+type advisorData struct {
+	Ranges      []InterruptionBucket                          `json:"ranges"`
+	SpotAdvisor map[string]map[string]map[string]advisorEntry `json:"spot_advisor"`
+}
+
+// ReadInterruptionFrequencies loads the Linux interruption-frequency bucket of
+// every instance type in regionName from a local Spot Advisor JSON file.
+func ReadInterruptionFrequencies(path string, regionName string) (map[string]InterruptionBucket, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data advisorData
+	if err := json.Unmarshal(contents, &data); err != nil {
+		return nil, fmt.Errorf("could not parse interruption frequency file '%v': %v", path, err)
+	}
+
+	buckets := map[int]InterruptionBucket{}
+	for _, bucket := range data.Ranges {
+		buckets[bucket.Index] = bucket
+	}
+	frequencies := map[string]InterruptionBucket{}
+	for instanceType, entry := range data.SpotAdvisor[regionName]["Linux"] {
+		bucket, found := buckets[entry.Range]
+		if !found {
+			bucket = InterruptionBucket{Index: entry.Range, Label: fmt.Sprintf("range %v", entry.Range)}
+		}
+		frequencies[instanceType] = bucket
+	}
+	return frequencies, nil
+}
+
+// ApplyInterruptionFrequencies sets the interruption-frequency bucket of every
+// summary, leaving types missing from frequencies as unknown.
+func ApplyInterruptionFrequencies(priceList []FullSummary, frequencies map[string]InterruptionBucket) {
+	for i := range priceList {
+		bucket, found := frequencies[priceList[i].Name]
+		if !found {
+			priceList[i].InterruptionBucket = UnknownInterruptionBucket
+			priceList[i].InterruptionFrequency = "unknown"
+			continue
+		}
+		priceList[i].InterruptionBucket = bucket.Index
+		priceList[i].InterruptionFrequency = bucket.Label
+	}
+}
@@ -0,0 +1,64 @@
+package pricing
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/davidboren/k8-spot-daemon/awscode"
+	"github.com/davidboren/k8-spot-daemon/history"
+)
+
+// historyOverlap is how far before the end of an instance type's stored
+// history an incremental fetch starts, to pick up points AWS published late.
+const historyOverlap = 15 * time.Minute
+
+// GetSpotPrices returns the spot price history of instanceTypes over the last
+// historical duration.  Without a priceHistory every point is downloaded;
+// with one, only the points since each type's stored history ends are, and
+// the window is then read from the store.
+func GetSpotPrices(ctx context.Context, sess *session.Session, priceHistory *history.Store,
+	instanceTypes []string, regionNames []string, historical time.Duration) (map[string][]ec2.SpotPrice, error) {
+
+	if priceHistory == nil {
+		return awscode.GetSpotPrices(ctx, sess, instanceTypes, regionNames, historical)
+	}
+	now := time.Now()
+	since := now.Add(-historical)
+	priceMap := map[string][]ec2.SpotPrice{}
+	for _, regionName := range regionNames {
+		startTimes := map[string]time.Time{}
+		incremental := 0
+		for _, instanceType := range instanceTypes {
+			startTimes[instanceType] = since
+			coverage, found, err := priceHistory.Coverage(regionName, instanceType)
+			if err != nil {
+				return nil, err
+			}
+			if found && !coverage.From.After(since) && coverage.Until.After(since) {
+				startTimes[instanceType] = coverage.Until.Add(-historyOverlap)
+				incremental++
+			}
+		}
+		slog.Debug("fetching spot price history", "region", regionName, "instanceTypes", len(instanceTypes),
+			"incremental", incremental)
+
+		fetched, err := awscode.GetSpotPricesSince(ctx, sess, startTimes, []string{regionName})
+		if err != nil {
+			return nil, err
+		}
+		if err := priceHistory.Add(regionName, startTimes, now, fetched); err != nil {
+			return nil, err
+		}
+		for _, instanceType := range instanceTypes {
+			prices, err := priceHistory.Query(regionName, instanceType, since, now)
+			if err != nil {
+				return nil, err
+			}
+			priceMap[instanceType] = append(priceMap[instanceType], prices...)
+		}
+	}
+	return priceMap, nil
+}
@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Interruption is a single spot reclaim or rebalance-recommendation signal
+// observed for an instance.
+type Interruption struct {
+	InstanceID   string
+	InstanceType string
+	Rebalance    bool
+	Time         time.Time
+}
+
+// InterruptionTracker keeps the interruption signals seen over a sliding
+// window and turns them into a per-type interruption rate.
+type InterruptionTracker struct {
+	Window        time.Duration
+	interruptions map[string]Interruption
+}
+
+func NewInterruptionTracker(window time.Duration) *InterruptionTracker {
+	return &InterruptionTracker{
+		Window:        window,
+		interruptions: map[string]Interruption{}}
+}
+
+// Record adds an interruption to the tracker, returning false if the same
+// signal has already been recorded for the instance or its instance or type is
+// unknown.
+func (t *InterruptionTracker) Record(interruption Interruption) bool {
+	if len(interruption.InstanceID) == 0 || len(interruption.InstanceType) == 0 {
+		return false
+	}
+	key := interruption.InstanceID + "/" + strconv.FormatBool(interruption.Rebalance)
+	if _, seen := t.interruptions[key]; seen {
+		return false
+	}
+	t.interruptions[key] = interruption
+	return true
+}
+
+func (t *InterruptionTracker) prune(now time.Time) {
+	for key, interruption := range t.interruptions {
+		if now.Sub(interruption.Time) > t.Window {
+			delete(t.interruptions, key)
+		}
+	}
+}
+
+// Rate returns the interruptions per hour seen for instanceType over the
+// window.  A rebalance recommendation counts as half an interruption.
+func (t *InterruptionTracker) Rate(instanceType string, now time.Time) float64 {
+	if t.Window <= 0 {
+		return 0
+	}
+	t.prune(now)
+	count := 0.0
+	for _, interruption := range t.interruptions {
+		if interruption.InstanceType != instanceType {
+			continue
+		}
+		if interruption.Rebalance {
+			count += 0.5
+		} else {
+			count += 1.0
+		}
+	}
+	return count / t.Window.Hours()
+}
+
+// LastInterrupted returns the time instanceType was last reclaimed, ignoring
+// rebalance recommendations, or the zero time if it has not been.
+func (t *InterruptionTracker) LastInterrupted(instanceType string) time.Time {
+	last := time.Time{}
+	for _, interruption := range t.interruptions {
+		if interruption.InstanceType == instanceType && !interruption.Rebalance && interruption.Time.After(last) {
+			last = interruption.Time
+		}
+	}
+	return last
+}
+
+// Interruptions returns the interruptions the tracker holds, oldest first.
+func (t *InterruptionTracker) Interruptions() []Interruption {
+	interruptions := []Interruption{}
+	for _, interruption := range t.interruptions {
+		interruptions = append(interruptions, interruption)
+	}
+	sort.Slice(interruptions, func(i, j int) bool {
+		if !interruptions[i].Time.Equal(interruptions[j].Time) {
+			return interruptions[i].Time.Before(interruptions[j].Time)
+		}
+		return interruptions[i].InstanceID < interruptions[j].InstanceID
+	})
+	return interruptions
+}
+
+// ApplyInterruptions sets the interruption rate of every summary and flags the
+// types that have been reclaimed within the exclusion period.
+func ApplyInterruptions(priceList []FullSummary, tracker *InterruptionTracker, exclusion time.Duration, now time.Time) {
+	if tracker == nil {
+		return
+	}
+	for i := range priceList {
+		priceList[i].InterruptionRate = tracker.Rate(priceList[i].Name, now)
+		lastInterrupted := tracker.LastInterrupted(priceList[i].Name)
+		priceList[i].RecentlyInterrupted = !lastInterrupted.IsZero() && now.Sub(lastInterrupted) < exclusion
+	}
+}
@@ -0,0 +1,41 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterruptionTracker(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	tracker := NewInterruptionTracker(4 * time.Hour)
+	cases := []struct {
+		name         string
+		interruption Interruption
+		want         bool
+	}{
+		{"interruption", Interruption{InstanceID: "i-1", InstanceType: "r4.xlarge", Time: now.Add(-time.Hour)}, true},
+		{"same signal again", Interruption{InstanceID: "i-1", InstanceType: "r4.xlarge", Time: now}, false},
+		{"rebalance of the same instance", Interruption{InstanceID: "i-1", InstanceType: "r4.xlarge",
+			Rebalance: true, Time: now.Add(-2 * time.Hour)}, true},
+		{"unknown type", Interruption{InstanceID: "i-2", Time: now}, false},
+		{"unknown instance", Interruption{InstanceType: "r4.xlarge", Time: now}, false},
+		{"another unknown instance", Interruption{InstanceType: "r4.xlarge", Time: now}, false},
+		{"outside the window", Interruption{InstanceID: "i-3", InstanceType: "r4.xlarge", Time: now.Add(-5 * time.Hour)}, true},
+		{"other type", Interruption{InstanceID: "i-4", InstanceType: "r5.xlarge", Time: now}, true},
+	}
+	for _, c := range cases {
+		if got := tracker.Record(c.interruption); got != c.want {
+			t.Errorf("%v: Record() = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if rate := tracker.Rate("r4.xlarge", now); rate != 1.5/4 {
+		t.Errorf("Rate(r4.xlarge) = %v, want %v", rate, 1.5/4)
+	}
+	if last := tracker.LastInterrupted("r4.xlarge"); !last.Equal(now.Add(-time.Hour)) {
+		t.Errorf("LastInterrupted(r4.xlarge) = %v, want %v", last, now.Add(-time.Hour))
+	}
+	if got := len(tracker.Interruptions()); got != 3 {
+		t.Errorf("%v interruptions held after pruning, want 3", got)
+	}
+}
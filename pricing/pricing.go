@@ -1,18 +1,24 @@
 package pricing
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"math"
 	"sort"
 	"strconv"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/montanaflynn/stats"
 	// "github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/davidboren/k8-spot-daemon/awscode"
 	instanceConfig "github.com/davidboren/k8-spot-daemon/config"
+	"github.com/davidboren/k8-spot-daemon/history"
 )
 
 type FullSummary struct {
@@ -24,6 +30,11 @@ type FullSummary struct {
 	Mem         float64
 	PricePerCPU float64
 	PricePerGB  float64
+
+	InterruptionRate      float64
+	RecentlyInterrupted   bool
+	InterruptionBucket    int
+	InterruptionFrequency string
 }
 
 type InstanceDetails struct {
@@ -32,18 +43,41 @@ type InstanceDetails struct {
 	Cpus float64 `yaml:"cpus"`
 }
 
-func ReadDetails() map[string]InstanceDetails {
-	machines, _ := instanceConfig.Asset("config/machines.yaml")
+func ReadDetails() (map[string]InstanceDetails, error) {
+	machines, err := instanceConfig.Asset("config/machines.yaml")
+	if err != nil {
+		return nil, err
+	}
 
 	detailList := make([]InstanceDetails, 0)
 	detailMap := make(map[string]InstanceDetails)
 
-	yaml.Unmarshal(machines, &detailList)
+	if err := yaml.Unmarshal(machines, &detailList); err != nil {
+		return nil, fmt.Errorf("could not parse instance catalog: %w", err)
+	}
 
 	for _, each := range detailList {
 		detailMap[each.Name] = each
 	}
-	return detailMap
+	return detailMap, nil
+}
+
+// GetInstanceDetails looks an instance type up in the bundled catalog, falling
+// back to EC2 for types the catalog does not know about.
+func GetInstanceDetails(ctx context.Context, sess *session.Session, instanceType string) (InstanceDetails, error) {
+	instanceDetails, err := ReadDetails()
+	if err != nil {
+		return InstanceDetails{}, err
+	}
+	if details, found := instanceDetails[instanceType]; found {
+		return details, nil
+	}
+	slog.Info("instance type is not in the catalog, fetching its details from EC2", "instanceType", instanceType)
+	cpus, mem, err := awscode.DescribeInstanceType(ctx, sess, instanceType)
+	if err != nil {
+		return InstanceDetails{}, err
+	}
+	return InstanceDetails{Name: instanceType, Mem: mem, Cpus: cpus}, nil
 }
 
 func TimeWeight(now time.Time, timeStamp time.Time) float64 {
@@ -51,31 +85,129 @@ func TimeWeight(now time.Time, timeStamp time.Time) float64 {
 	return 1.0 / (0.2 + hoursAgo)
 }
 
-func DescribePricing(sess *session.Session, spotConfig awscode.SpotConfig) []FullSummary {
-	instanceDetails := ReadDetails()
-	bigInstanceTypes := map[string]InstanceDetails{}
-	for _, each := range instanceDetails {
-		bigInstanceTypes[each.Name] = each
+// GetTimeWeight returns the weighting kernel named by spotConfig.WeightingKernel.
+func GetTimeWeight(spotConfig awscode.SpotConfig) func(time.Time, time.Time) float64 {
+	switch spotConfig.WeightingKernel {
+	case awscode.KernelUniform:
+		return func(now time.Time, timeStamp time.Time) float64 { return 1.0 }
+	case awscode.KernelExponential:
+		halfLife := spotConfig.HistoricalHours / 4
+		return func(now time.Time, timeStamp time.Time) float64 {
+			return math.Exp2(-now.Sub(timeStamp).Hours() / halfLife)
+		}
+	}
+	return TimeWeight
+}
+
+// Inputs are everything DescribePricing prices instance types from, as read
+// at Time: the spot price history of each type, the interruptions tracked
+// and the interruption frequencies of the region.
+type Inputs struct {
+	Time          time.Time
+	SpotPrices    map[string][]ec2.SpotPrice
+	Interruptions []Interruption
+	Frequencies   map[string]InterruptionBucket
+}
+
+// GetInputs reads the spot price history of every catalogued instance type
+// over the historical window, through priceHistory if it is not nil, along
+// with the interruptions in interruptionTracker and the frequency file.
+func GetInputs(ctx context.Context, sess *session.Session, spotConfig awscode.SpotConfig,
+	interruptionTracker *InterruptionTracker, priceHistory *history.Store) (Inputs, error) {
+
+	instanceDetails, err := ReadDetails()
+	if err != nil {
+		return Inputs{}, err
+	}
+	instanceTypes := []string{}
+	for _, obj := range instanceDetails {
+		instanceTypes = append(instanceTypes, obj.Name)
+	}
+	if len(instanceTypes) == 0 {
+		return Inputs{}, fmt.Errorf("You have no instanceTypes...")
+	}
+	now := time.Now()
+	priceMap, err := GetSpotPrices(ctx, sess, priceHistory, instanceTypes, []string{spotConfig.RegionName},
+		time.Duration(spotConfig.HistoricalHours*float64(time.Hour)))
+	if err != nil {
+		return Inputs{}, err
+	}
+
+	frequencies := map[string]InterruptionBucket{}
+	if len(spotConfig.InterruptionFrequencyFile) > 0 {
+		frequencies, err = ReadInterruptionFrequencies(spotConfig.InterruptionFrequencyFile, spotConfig.RegionName)
+		if err != nil {
+			slog.Warn("could not read interruption frequencies", "error", err)
+			frequencies = map[string]InterruptionBucket{}
+		}
+	}
+	interruptions := []Interruption{}
+	if interruptionTracker != nil {
+		interruptions = interruptionTracker.Interruptions()
+	}
+	return Inputs{Time: now, SpotPrices: priceMap, Interruptions: interruptions, Frequencies: frequencies}, nil
+}
+
+// Within returns inputs with the spot price history narrowed to the
+// historical window before Time, so that inputs fetched once for the longest
+// window can be shared by configurations with shorter ones.  As when it is
+// fetched for that window, the history of each zone still begins with the
+// price in effect at the window's start.
+func (inputs Inputs) Within(historical time.Duration) Inputs {
+	since := inputs.Time.Add(-historical)
+	narrowed := inputs
+	narrowed.SpotPrices = map[string][]ec2.SpotPrice{}
+	for instanceType, prices := range inputs.SpotPrices {
+		inEffect := map[string]int{}
+		for i, price := range prices {
+			if aws.TimeValue(price.Timestamp).After(since) {
+				continue
+			}
+			zone := aws.StringValue(price.AvailabilityZone)
+			if last, found := inEffect[zone]; !found || aws.TimeValue(price.Timestamp).After(aws.TimeValue(prices[last].Timestamp)) {
+				inEffect[zone] = i
+			}
+		}
+		kept := []ec2.SpotPrice{}
+		for i, price := range prices {
+			first, found := inEffect[aws.StringValue(price.AvailabilityZone)]
+			if aws.TimeValue(price.Timestamp).After(since) || (found && first == i) {
+				kept = append(kept, price)
+			}
+		}
+		if len(kept) > 0 {
+			narrowed.SpotPrices[instanceType] = kept
+		}
+	}
+	return narrowed
+}
+
+// DescribePricing prices every instance type from inputs, cheapest per GB
+// first.  It reads nothing but the bundled catalog, so the same inputs always
+// give the same prices.
+func DescribePricing(spotConfig awscode.SpotConfig, inputs Inputs) ([]FullSummary, error) {
+	instanceDetails, err := ReadDetails()
+	if err != nil {
+		return nil, err
 	}
-	regionNames := []string{spotConfig.RegionName}
+	avgList := SummarizePrices(inputs.SpotPrices, instanceDetails, inputs.Time, GetTimeWeight(spotConfig))
 
-	avgList := CompileAverages(sess, bigInstanceTypes, regionNames,
-		time.Duration(spotConfig.HistoricalHours), TimeWeight)
+	interruptionTracker := NewInterruptionTracker(time.Duration(spotConfig.InterruptionWindowHours * float64(time.Hour)))
+	for _, interruption := range inputs.Interruptions {
+		interruptionTracker.Record(interruption)
+	}
+	ApplyInterruptions(avgList, interruptionTracker,
+		time.Second*time.Duration(spotConfig.InterruptionExclusionSeconds), inputs.Time)
+	ApplyInterruptionFrequencies(avgList, inputs.Frequencies)
 
-	sort.Sort(ByPricePerGB(avgList))
-	fmt.Printf("Averaged Pricing Data for last '%v' hours: \n", spotConfig.HistoricalHours)
+	sort.Stable(ByPricePerGB(avgList))
 	for _, obj := range avgList {
-		fmt.Printf("    %12v || Price: %7.3f | GB: %9.4f | Cpus: %3v | Cpus/GB: %0.3f | Price/GB: %8.4f | Price/Cpu: %3.4f | Coef of Var: %8.4f\n",
-			obj.Name,
-			obj.Price,
-			obj.Mem,
-			obj.Cpus,
-			float64(obj.Cpus)/float64(obj.Mem),
-			obj.PricePerGB,
-			obj.PricePerCPU,
-			obj.CoefVar)
-	}
-	return avgList
+		slog.Debug("averaged pricing", "instanceType", obj.Name, "historicalHours", spotConfig.HistoricalHours,
+			"price", obj.Price, "memoryGB", obj.Mem, "cpus", obj.Cpus,
+			"pricePerGB", obj.PricePerGB, "pricePerCpu", obj.PricePerCPU, "coefVar", obj.CoefVar,
+			"interruptionsPerHour", obj.InterruptionRate, "interruptionFrequency", obj.InterruptionFrequency)
+	}
+	return avgList, nil
 }
 
 // ByPrice implements sort.Interface for []PriceSummary based on
@@ -100,20 +232,20 @@ func Volatility(prices []float64) (float64, float64) {
 	return priceSTD / priceMean, priceSTD
 }
 
-func CompileAverages(sess *session.Session, instanceDetails map[string]InstanceDetails,
-	regionNames []string, historicalHours time.Duration,
-	TimeWeight func(time.Time, time.Time) float64) []FullSummary {
+// SummarizePrices averages the price history of each instance type in
+// instanceDetails, weighting each point by its age at now.  Types without
+// price history are left out.
+func SummarizePrices(priceMap map[string][]ec2.SpotPrice, instanceDetails map[string]InstanceDetails,
+	now time.Time, TimeWeight func(time.Time, time.Time) float64) []FullSummary {
 
 	instanceTypes := []string{}
-	for _, obj := range instanceDetails {
-		instanceTypes = append(instanceTypes, obj.Name)
-	}
-	if len(instanceTypes) == 0 {
-		panic("You have no instanceTypes...")
+	for instanceType := range priceMap {
+		if _, found := instanceDetails[instanceType]; found {
+			instanceTypes = append(instanceTypes, instanceType)
+		}
 	}
-	priceMap := awscode.GetSpotPrices(sess, instanceTypes, regionNames, historicalHours)
+	sort.Strings(instanceTypes)
 	sumList := []FullSummary{}
-	now := time.Now()
 	for _, intype := range instanceTypes {
 		weightSum := 0.0
 		timestamps := []time.Time{}
@@ -0,0 +1,53 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInputsWithin(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	price := func(zone string, hoursAgo float64, spotPrice string) ec2.SpotPrice {
+		return ec2.SpotPrice{AvailabilityZone: aws.String(zone), SpotPrice: aws.String(spotPrice),
+			Timestamp: aws.Time(now.Add(-time.Duration(hoursAgo * float64(time.Hour))))}
+	}
+	// Newest first, as DescribeSpotPriceHistory returns them.
+	inputs := Inputs{Time: now, SpotPrices: map[string][]ec2.SpotPrice{
+		"r4.xlarge": {price("a", 1, "0.13"), price("b", 2, "0.20"), price("a", 4, "0.12"), price("a", 6, "0.11"),
+			price("b", 8, "0.19"), price("a", 9, "0.10")},
+		"r3.xlarge": {price("a", 10, "0.05")}}}
+
+	cases := []struct {
+		name  string
+		hours float64
+		want  map[string][]string
+	}{
+		{"everything", 12, map[string][]string{
+			"r4.xlarge": {"0.13", "0.20", "0.12", "0.11", "0.19", "0.10"}, "r3.xlarge": {"0.05"}}},
+		{"starts with the price in effect", 5, map[string][]string{
+			"r4.xlarge": {"0.13", "0.20", "0.12", "0.11", "0.19"}, "r3.xlarge": {"0.05"}}},
+		{"starts on a point", 4, map[string][]string{
+			"r4.xlarge": {"0.13", "0.20", "0.12", "0.19"}, "r3.xlarge": {"0.05"}}},
+		{"only prices in effect", 0.5, map[string][]string{
+			"r4.xlarge": {"0.13", "0.20"}, "r3.xlarge": {"0.05"}}},
+	}
+	for _, c := range cases {
+		narrowed := inputs.Within(time.Duration(c.hours * float64(time.Hour)))
+		got := map[string][]string{}
+		for instanceType, prices := range narrowed.SpotPrices {
+			for _, price := range prices {
+				got[instanceType] = append(got[instanceType], aws.StringValue(price.SpotPrice))
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) || !narrowed.Time.Equal(now) {
+			t.Errorf("%v: Within = %v, want %v", c.name, got, c.want)
+		}
+		if len(inputs.SpotPrices["r4.xlarge"]) != 6 {
+			t.Fatalf("%v: Within changed the inputs it narrowed", c.name)
+		}
+	}
+}